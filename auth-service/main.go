@@ -41,7 +41,7 @@ func (s *AuthServer) Register(ctx context.Context, req *pb.RegisterRequest) (*pb
 
 	userID := uuid.New()
 	query := `
-        INSERT INTO users (id, username, password, email, created_at)
+        INSERT INTO users (user_UUID, username, password, email, created_at)
         VALUES ($1, $2, $3, $4, $5)
     `
 	_, err = s.db.ExecContext(ctx, query, userID, req.Username, hashedPassword, req.Email, time.Now())
@@ -65,11 +65,12 @@ func (s *AuthServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb.Login
 		ID       uuid.UUID
 		Username string
 		Password string
+		Role     string
 	}
 	query := `
-        SELECT id, username, password FROM users WHERE username = $1
+        SELECT user_UUID, username, password, role FROM users WHERE username = $1
     `
-	err := s.db.QueryRowContext(ctx, query, req.Username).Scan(&user.ID, &user.Username, &user.Password)
+	err := s.db.QueryRowContext(ctx, query, req.Username).Scan(&user.ID, &user.Username, &user.Password, &user.Role)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("неверное имя пользователя или пароль")
@@ -83,8 +84,9 @@ func (s *AuthServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb.Login
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub": user.ID.String(),
-		"exp": time.Now().Add(24 * time.Hour).Unix(),
+		"sub":  user.ID.String(),
+		"role": user.Role,
+		"exp":  time.Now().Add(24 * time.Hour).Unix(),
 	})
 
 	tokenString, err := token.SignedString([]byte(s.jwtSecret))
@@ -158,7 +160,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("Не удалось прослушивать порт: %v", err)
 	}
-	log.Printf("Auth-service слушает на порту %d", cfg.Port)
+	log.Printf("Auth-service слушает на порту %s", cfg.Port)
 
 	//запуск сервера
 	go func() {
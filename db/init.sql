@@ -3,21 +3,292 @@ CREATE EXTENSION IF NOT EXISTS pgcrypto;
 
 -- Создание таблицы users, если она не существует
 CREATE TABLE IF NOT EXISTS users (
-    user_UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_UUID UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     username VARCHAR(50) NOT NULL UNIQUE,
     password VARCHAR(255) NOT NULL,
     email VARCHAR(255) NOT NULL UNIQUE,
+    role VARCHAR(20) NOT NULL DEFAULT 'customer',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
 CREATE TABLE IF NOT EXISTS orders (
-    order_UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_UUID UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     user_UUID UUID NOT NULL,
-    items VARCHAR(255)NOT NULL,
+    items TEXT[] NOT NULL,
     status VARCHAR(20) NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    -- поисковый вектор по товарам, статусу и имени клиента, заполняется триггером
+    search TSVECTOR,
     FOREIGN KEY (user_UUID) REFERENCES users(user_UUID) ON DELETE CASCADE
 );
 
 -- Индекс для быстрого поиска 
 CREATE INDEX IF NOT EXISTS idx_orders_order_UUID ON orders(order_UUID);
+
+-- История заказов клиента с постраничной выдачей по (created_at, order_UUID)
+CREATE INDEX IF NOT EXISTS idx_orders_user_created ON orders(user_UUID, created_at DESC, order_UUID DESC);
+
+-- Админский список всех заказов и фильтр по статусу
+CREATE INDEX IF NOT EXISTS idx_orders_created ON orders(created_at DESC, order_UUID DESC);
+CREATE INDEX IF NOT EXISTS idx_orders_status_created ON orders(status, created_at DESC);
+
+-- Полнотекстовый поиск по заказам
+CREATE OR REPLACE FUNCTION orders_search_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search := to_tsvector('simple',
+        array_to_string(NEW.items, ' ') || ' ' || NEW.status || ' ' ||
+        coalesce((SELECT username FROM users WHERE user_UUID = NEW.user_UUID), ''));
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_orders_search ON orders;
+CREATE TRIGGER trg_orders_search
+    BEFORE INSERT OR UPDATE OF items, status, user_UUID ON orders
+    FOR EACH ROW EXECUTE FUNCTION orders_search_update();
+
+CREATE INDEX IF NOT EXISTS idx_orders_search ON orders USING GIN(search);
+
+-- Ответы на запросы создания заказа с заголовком Idempotency-Key
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_UUID UUID NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status_code INT NOT NULL,
+    content_type VARCHAR(255) NOT NULL DEFAULT '',
+    response TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_UUID, idempotency_key)
+);
+
+-- Transactional outbox: сообщения для Kafka, сохраненные в одной транзакции с заказом
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL PRIMARY KEY,
+    topic VARCHAR(255) NOT NULL,
+    message_key VARCHAR(255) NOT NULL,
+    payload BYTEA NOT NULL,
+    content_type VARCHAR(100) NOT NULL DEFAULT 'application/json',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP
+);
+
+-- Индекс для выборки неотправленных сообщений
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox(id) WHERE sent_at IS NULL;
+
+-- Склад ингредиентов кухни
+CREATE TABLE IF NOT EXISTS ingredients (
+    name VARCHAR(100) PRIMARY KEY,
+    unit VARCHAR(20) NOT NULL,
+    stock NUMERIC(12, 3) NOT NULL DEFAULT 0 CHECK (stock >= 0),
+    -- при таком запасе кухня предупреждает, что ингредиент пора пополнить
+    low_stock NUMERIC(12, 3) NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Заказы, по которым ингредиенты уже списаны: повторная обработка заказа не списывает их второй раз
+CREATE TABLE IF NOT EXISTS inventory_consumption (
+    order_id VARCHAR(255) PRIMARY KEY,
+    consumed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Предзаказы: время, к которому нужно приготовить заказ, и correlation ID запроса,
+-- с которым заказ уйдет на кухню
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS scheduled_for TIMESTAMPTZ;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS correlation_id VARCHAR(255);
+
+-- Выборка предзаказов, которые пора отправить на кухню
+CREATE INDEX IF NOT EXISTS idx_orders_scheduled ON orders(scheduled_for) WHERE status = 'scheduled';
+
+-- Занятость 15-минутных слотов предзаказов
+CREATE TABLE IF NOT EXISTS schedule_slots (
+    slot_start TIMESTAMPTZ PRIMARY KEY,
+    booked INT NOT NULL DEFAULT 0
+);
+
+-- Приоритет заказа на кухне и время готовности для компенсации задержанных заказов
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS priority SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS ready_at TIMESTAMPTZ;
+
+-- Когда кухня взяла заказ в работу: после этого состав заказа не меняется
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS kitchen_taken_at TIMESTAMPTZ;
+
+-- Курьеры службы доставки. user_UUID связывает курьера с учетной записью auth-service
+CREATE TABLE IF NOT EXISTS couriers (
+    courier_UUID UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_UUID UUID UNIQUE REFERENCES users(user_UUID) ON DELETE SET NULL,
+    name VARCHAR(100) NOT NULL,
+    phone VARCHAR(30) NOT NULL DEFAULT '',
+    -- offline, available или break: готов ли курьер брать заказы
+    status VARCHAR(20) NOT NULL DEFAULT 'offline',
+    -- сколько заказов курьер везет одновременно
+    max_load INT NOT NULL DEFAULT 1,
+    -- последнее известное местоположение
+    lat DOUBLE PRECISION,
+    lon DOUBLE PRECISION,
+    location_at TIMESTAMPTZ,
+    -- когда курьер последний раз освободился, при равной загрузке заказ получает тот, кто дольше ждет
+    idle_since TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Смены курьеров: заказы назначаются только курьерам на смене
+CREATE TABLE IF NOT EXISTS courier_shifts (
+    id BIGSERIAL PRIMARY KEY,
+    courier_UUID UUID NOT NULL REFERENCES couriers(courier_UUID) ON DELETE CASCADE,
+    starts_at TIMESTAMPTZ NOT NULL,
+    ends_at TIMESTAMPTZ NOT NULL,
+    CHECK (ends_at > starts_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_courier_shifts_active ON courier_shifts(courier_UUID, starts_at, ends_at);
+
+-- Доставки готовых заказов
+CREATE TABLE IF NOT EXISTS deliveries (
+    order_UUID UUID PRIMARY KEY,
+    courier_UUID UUID REFERENCES couriers(courier_UUID),
+    -- pending, assigned, picked_up, delivered, failed_delivery или returned
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    correlation_id VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    assigned_at TIMESTAMPTZ,
+    picked_up_at TIMESTAMPTZ,
+    delivered_at TIMESTAMPTZ
+);
+
+-- Очередь доставок без курьера и активные доставки курьера
+CREATE INDEX IF NOT EXISTS idx_deliveries_pending ON deliveries(created_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_deliveries_courier_active ON deliveries(courier_UUID) WHERE status IN ('assigned', 'picked_up');
+
+-- Состав заказа для курьера, код подтверждения получения и причина неудачной доставки
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS items TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS pin VARCHAR(6) NOT NULL DEFAULT '';
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS failure_reason TEXT;
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS failed_at TIMESTAMPTZ;
+
+-- Подтверждение доставки: фото, подпись клиента и проверенный код
+CREATE TABLE IF NOT EXISTS delivery_proofs (
+    order_UUID UUID PRIMARY KEY REFERENCES deliveries(order_UUID) ON DELETE CASCADE,
+    -- расположение файлов в хранилище подтверждений
+    photo_key VARCHAR(255),
+    signature_key VARCHAR(255),
+    pin_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- История GPS-координат курьеров, последние координаты хранятся в Redis
+CREATE TABLE IF NOT EXISTS courier_locations (
+    id BIGSERIAL PRIMARY KEY,
+    courier_UUID UUID NOT NULL REFERENCES couriers(courier_UUID) ON DELETE CASCADE,
+    lat DOUBLE PRECISION NOT NULL,
+    lon DOUBLE PRECISION NOT NULL,
+    -- точность GPS в метрах
+    accuracy DOUBLE PRECISION,
+    recorded_at TIMESTAMPTZ NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_courier_locations_courier ON courier_locations(courier_UUID, recorded_at);
+
+-- Тип заказа (доставка или самовывоз), адрес доставки, зона доставки и суммы заказа в копейках
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS order_type VARCHAR(20) NOT NULL DEFAULT 'delivery';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS address JSONB;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivery_zone VARCHAR(50);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS subtotal BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivery_fee BIGINT NOT NULL DEFAULT 0;
+
+-- Куда везти заказ
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS address TEXT NOT NULL DEFAULT '';
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS address_comment TEXT NOT NULL DEFAULT '';
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS dest_lat DOUBLE PRECISION;
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS dest_lon DOUBLE PRECISION;
+
+-- Оценка времени доставки, для самовывоза - времени готовности. Пересчитывается на каждом статусе заказа
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS eta TIMESTAMPTZ;
+
+-- Поездка курьера с попутными заказами: порядок объезда клиентов и оценка прибытия к каждому
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS batch_UUID UUID;
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS stop_seq INT NOT NULL DEFAULT 1;
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS eta TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_deliveries_batch ON deliveries(batch_UUID) WHERE batch_UUID IS NOT NULL;
+
+-- Служба доставки заказа: собственные курьеры (inhouse) или внешняя служба и номер доставки в ней
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS provider VARCHAR(50) NOT NULL DEFAULT 'inhouse';
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS external_id VARCHAR(255);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_deliveries_external ON deliveries(provider, external_id) WHERE external_id IS NOT NULL;
+
+-- Доставка, завершенная поддержкой вручную: кто и когда
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS resolved_by UUID;
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS resolved_at TIMESTAMPTZ;
+
+-- Неудачная доставка: код причины, номер попытки, когда заказ снова встанет в очередь и когда вернулся в ресторан
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS failure_code VARCHAR(30);
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 1;
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS retry_at TIMESTAMPTZ;
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS returned_at TIMESTAMPTZ;
+
+-- Попытки курьера связаться с клиентом
+CREATE TABLE IF NOT EXISTS delivery_contact_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    order_UUID UUID NOT NULL REFERENCES deliveries(order_UUID) ON DELETE CASCADE,
+    courier_UUID UUID REFERENCES couriers(courier_UUID),
+    -- номер попытки доставки
+    attempt INT NOT NULL,
+    -- call, sms, intercom или doorbell
+    method VARCHAR(20) NOT NULL,
+    -- no_answer, busy, answered или wrong_number
+    result VARCHAR(20) NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_delivery_contact_attempts_order ON delivery_contact_attempts(order_UUID);
+
+-- Компенсации за недоставленные заказы: refund - возврат денег, credit - бонусы на счет клиента. Суммы в копейках
+CREATE TABLE IF NOT EXISTS order_compensations (
+    order_UUID UUID PRIMARY KEY REFERENCES orders(order_UUID) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL,
+    reason VARCHAR(30) NOT NULL,
+    amount BIGINT NOT NULL,
+    -- requested - ждет выплаты
+    status VARCHAR(20) NOT NULL DEFAULT 'requested',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Бонусный счет клиента
+CREATE TABLE IF NOT EXISTS customer_credits (
+    user_UUID UUID PRIMARY KEY REFERENCES users(user_UUID) ON DELETE CASCADE,
+    balance BIGINT NOT NULL DEFAULT 0
+);
+
+-- Настройки уведомлений клиента. Клиенту без настроек приходят письма о статусах из NOTIFY_DEFAULT_STATUSES
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_UUID UUID PRIMARY KEY REFERENCES users(user_UUID) ON DELETE CASCADE,
+    -- ru или en
+    language VARCHAR(2) NOT NULL DEFAULT 'ru',
+    email BOOLEAN NOT NULL DEFAULT true,
+    sms BOOLEAN NOT NULL DEFAULT false,
+    push BOOLEAN NOT NULL DEFAULT false,
+    phone VARCHAR(20),
+    push_token TEXT,
+    -- статусы заказа, о которых сообщать
+    statuses TEXT[] NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Отправленные уведомления: по каждому каналу уведомление о событии отправляется один раз
+CREATE TABLE IF NOT EXISTS notification_log (
+    event_id VARCHAR(255) NOT NULL,
+    -- email, sms или push
+    channel VARCHAR(10) NOT NULL,
+    order_UUID UUID NOT NULL,
+    user_UUID UUID NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    sent_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (event_id, channel)
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_log_order ON notification_log(order_UUID);
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/events/eta"
+)
+
+// стратегия в событии courier_assigned, когда курьера назначил человек
+const manualStrategy = "manual"
+
+// ActiveDelivery - незавершенная доставка в списке для поддержки
+type ActiveDelivery struct {
+	OrderID  uuid.UUID `json:"order_id"`
+	Status   string    `json:"status"`
+	Provider string    `json:"provider"`
+	// номер доставки во внешней службе
+	ExternalID  *string    `json:"external_id,omitempty"`
+	CourierID   *uuid.UUID `json:"courier_id,omitempty"`
+	CourierName *string    `json:"courier_name,omitempty"`
+	Address     string     `json:"address,omitempty"`
+	BatchID     *uuid.UUID `json:"batch_id,omitempty"`
+	Stop        int        `json:"stop"`
+	ETA         *time.Time `json:"eta,omitempty"`
+	// номер попытки доставки и когда заказ снова встанет в очередь после неудачной попытки
+	Attempt    int        `json:"attempt"`
+	RetryAt    *time.Time `json:"retry_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	AssignedAt *time.Time `json:"assigned_at,omitempty"`
+	PickedUpAt *time.Time `json:"picked_up_at,omitempty"`
+}
+
+// ActiveFilter - фильтры списка активных доставок, пустое поле не фильтрует
+type ActiveFilter struct {
+	Status    string
+	Provider  string
+	CourierID *uuid.UUID
+}
+
+// ActiveDeliveries возвращает доставки в очереди, назначенные и в пути, начиная с самых старых
+func ActiveDeliveries(ctx context.Context, db *sql.DB, f ActiveFilter) ([]ActiveDelivery, error) {
+	if f.Status != "" && f.Status != DeliveryPending && f.Status != DeliveryAssigned && f.Status != DeliveryPickedUp {
+		return nil, fmt.Errorf("%w: активные статусы - pending, assigned и picked_up", errDeliveryState)
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT d.order_UUID, d.status, d.provider, d.external_id, d.courier_UUID, c.name, d.address,
+			d.batch_UUID, d.stop_seq, d.eta, d.attempts, d.retry_at, d.created_at, d.assigned_at, d.picked_up_at
+		FROM deliveries d
+		LEFT JOIN couriers c ON c.courier_UUID = d.courier_UUID
+		WHERE d.status IN ('pending', 'assigned', 'picked_up')
+			AND ($1::text = '' OR d.status = $1)
+			AND ($2::text = '' OR d.provider = $2)
+			AND ($3::uuid IS NULL OR d.courier_UUID = $3)
+		ORDER BY d.created_at`,
+		f.Status, f.Provider, f.CourierID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []ActiveDelivery{}
+	for rows.Next() {
+		var d ActiveDelivery
+		if err := rows.Scan(&d.OrderID, &d.Status, &d.Provider, &d.ExternalID, &d.CourierID, &d.CourierName, &d.Address,
+			&d.BatchID, &d.Stop, &d.ETA, &d.Attempt, &d.RetryAt, &d.CreatedAt, &d.AssignedAt, &d.PickedUpAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// withdrawFunc отзывает заказ у внешней службы provider, которой он передан под номером externalID
+type withdrawFunc func(ctx context.Context, orderID uuid.UUID, provider string, externalID sql.NullString) error
+
+// Reassign вручную отдает заказ, который еще не забрали, курьеру courierID, а без курьера возвращает его
+// в очередь диспетчера. Загрузка и смена курьера не проверяются: человек знает больше диспетчера.
+// Заказ уходит из поездки с попутными заказами и у внешней службы, если был передан ей: withdraw вызывается
+// после всех проверок, перед фиксацией транзакции
+func (d *Dispatcher) Reassign(ctx context.Context, orderID uuid.UUID, courierID *uuid.UUID, withdraw withdrawFunc) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		q          queued
+		status     string
+		provider   string
+		externalID sql.NullString
+		prev       *uuid.UUID
+		lat, lon   sql.NullFloat64
+		courier    Candidate
+		courierLat sql.NullFloat64
+		courierLon sql.NullFloat64
+	)
+	err = tx.QueryRowContext(ctx, `
+		SELECT order_UUID, status, provider, external_id, correlation_id, pin, courier_UUID, dest_lat, dest_lon
+		FROM deliveries
+		WHERE order_UUID = $1
+		FOR UPDATE`,
+		orderID).Scan(&q.orderID, &status, &provider, &externalID, &q.correlationID, &q.pin, &prev, &lat, &lon)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errDeliveryNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if status != DeliveryPending && status != DeliveryAssigned {
+		return fmt.Errorf("%w: заказ уже у курьера (%s)", errDeliveryState, status)
+	}
+	if lat.Valid && lon.Valid {
+		q.dest = &eta.Point{Lat: lat.Float64, Lon: lon.Float64}
+	}
+
+	var arrive *time.Time
+	now := time.Now()
+	if courierID == nil {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE deliveries SET status = 'pending', courier_UUID = NULL, assigned_at = NULL,
+				batch_UUID = NULL, stop_seq = 1, eta = NULL, provider = $2, external_id = NULL
+			WHERE order_UUID = $1`,
+			orderID, InHouseProvider)
+	} else {
+		err = tx.QueryRowContext(ctx, `
+			SELECT courier_UUID, name, lat, lon FROM couriers WHERE courier_UUID = $1 FOR UPDATE`,
+			*courierID).Scan(&courier.ID, &courier.Name, &courierLat, &courierLon)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errCourierNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if courierLat.Valid && courierLon.Valid {
+			courier.Location = &eta.Point{Lat: courierLat.Float64, Lon: courierLon.Float64}
+		}
+		_, etas := d.plan([]queued{q}, courier.Location, now)
+		arrive = etas[0]
+		_, err = tx.ExecContext(ctx, `
+			UPDATE deliveries SET status = 'assigned', courier_UUID = $2, assigned_at = now(),
+				batch_UUID = NULL, stop_seq = 1, eta = $3, provider = $4, external_id = NULL
+			WHERE order_UUID = $1`,
+			orderID, courier.ID, arrive, InHouseProvider)
+	}
+	if err != nil {
+		return fmt.Errorf("не удалось переназначить доставку: %w", err)
+	}
+	if prev != nil && (courierID == nil || *prev != *courierID) {
+		if err := releaseCourier(ctx, tx, *prev); err != nil {
+			return err
+		}
+	}
+	if status == DeliveryAssigned {
+		if err := withdraw(ctx, orderID, provider, externalID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// у прежнего курьера освободилось место, а заказ без курьера ждет распределения
+	d.Wake()
+	if courierID == nil {
+		log.Printf("Заказ %s вручную возвращен в очередь", orderID)
+		return nil
+	}
+	log.Printf("На заказ %s вручную назначен курьер %s", orderID, courier.Name)
+	a := events.CourierAssigned{
+		OrderID:     orderID.String(),
+		CourierID:   courier.ID.String(),
+		CourierName: courier.Name,
+		Strategy:    manualStrategy,
+		At:          now,
+		PIN:         q.pin,
+	}
+	if err := publish(d.events, d.enc, events.TypeCourierAssigned, q.correlationID, a.OrderID, a); err != nil {
+		log.Printf("ошибка отправки назначения курьера на заказ %s в брокер: %v", orderID, err)
+	}
+	if arrive != nil {
+		e := events.DeliveryETA{OrderID: orderID.String(), ArriveAt: *arrive, At: now}
+		if err := publish(d.events, d.enc, events.TypeDeliveryETA, q.correlationID, e.OrderID, e); err != nil {
+			log.Printf("ошибка отправки оценки времени доставки заказа %s в брокер: %v", orderID, err)
+		}
+	}
+	return nil
+}
+
+// Resolve принудительно завершает незавершенную доставку со статусом to (DeliveryDelivered или DeliveryFailed
+// по причине code), например когда курьер не может отметить доставку в приложении. Кто завершил доставку, сохраняется.
+// Если доставка не удалась, а внешняя служба еще не забрала заказ, перед фиксацией транзакции вызывается withdraw
+func (d *Dispatcher) Resolve(ctx context.Context, orderID uuid.UUID, to, code, note string, by uuid.UUID, withdraw withdrawFunc) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		status, provider, correlationID string
+		externalID                      sql.NullString
+		courierID                       *uuid.UUID
+	)
+	err = tx.QueryRowContext(ctx, `
+		SELECT status, provider, external_id, correlation_id, courier_UUID FROM deliveries
+		WHERE order_UUID = $1
+		FOR UPDATE`,
+		orderID).Scan(&status, &provider, &externalID, &correlationID, &courierID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errDeliveryNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if status != DeliveryPending && status != DeliveryAssigned && status != DeliveryPickedUp {
+		return fmt.Errorf("%w: %s -> %s", errDeliveryState, status, to)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE deliveries SET status = $2,
+			delivered_at = CASE WHEN $2 = 'delivered' THEN now() ELSE delivered_at END,
+			resolved_by = $3, resolved_at = now()
+		WHERE order_UUID = $1`,
+		orderID, to, by); err != nil {
+		return err
+	}
+	if to == DeliveryFailed {
+		if err := d.recordFailure(ctx, tx, orderID, code, note, false); err != nil {
+			return err
+		}
+	}
+	if courierID != nil {
+		if err := releaseCourier(ctx, tx, *courierID); err != nil {
+			return err
+		}
+	}
+	if to == DeliveryFailed && status == DeliveryAssigned {
+		if err := withdraw(ctx, orderID, provider, externalID); err != nil {
+			return err
+		}
+	}
+	if err := publishStatus(d.statusWriter, d.enc, correlationID, orderID.String(), orderStatuses[to]); err != nil {
+		return fmt.Errorf("ошибка отправки статуса заказа %s в брокер: %w", orderID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Заказ %s: %s вручную (пользователь %s)", orderID, to, by)
+	d.Wake()
+	return nil
+}
+
+// Reassign переназначает заказ вручную. Заказ, переданный внешней службе, отзывается у нее,
+// только если переназначение прошло проверки
+func (p *Providers) Reassign(ctx context.Context, orderID uuid.UUID, courierID *uuid.UUID) error {
+	return p.dispatcher.Reassign(ctx, orderID, courierID, p.withdraw)
+}
+
+// Resolve принудительно завершает доставку. Если доставка не удалась, а внешняя служба еще не забрала
+// заказ, заказ отзывается у нее, чтобы курьер службы не приехал за ним
+func (p *Providers) Resolve(ctx context.Context, orderID uuid.UUID, to, code, note string, by uuid.UUID) error {
+	return p.dispatcher.Resolve(ctx, orderID, to, code, note, by, p.withdraw)
+}
+
+// withdraw отзывает у внешней службы заказ, который ее курьер еще не забрал. Если отозвать не удалось,
+// транзакция переназначения или завершения откатывается и заказ остается у службы
+func (p *Providers) withdraw(ctx context.Context, orderID uuid.UUID, name string, externalID sql.NullString) error {
+	if name == InHouseProvider || !externalID.Valid {
+		return nil
+	}
+	provider, ok := p.Get(name)
+	if !ok {
+		return fmt.Errorf("%w: %q", errUnknownProvider, name)
+	}
+	if err := provider.Cancel(ctx, externalID.String); err != nil {
+		return fmt.Errorf("не удалось отозвать заказ у службы доставки %s: %w", name, err)
+	}
+	log.Printf("Заказ %s отозван у службы доставки %s", orderID, name)
+	return nil
+}
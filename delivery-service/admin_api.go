@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ActiveDeliveriesHandler отдает доставки в очереди, назначенные и в пути со статусом и курьером
+// GET /deliveries?status=assigned&provider=inhouse&courier_id=...
+func ActiveDeliveriesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := ActiveFilter{Status: query.Get("status"), Provider: query.Get("provider")}
+		if v := query.Get("courier_id"); v != "" {
+			courierID, err := uuid.Parse(v)
+			if err != nil {
+				http.Error(w, "Некорректный идентификатор курьера", http.StatusBadRequest)
+				return
+			}
+			filter.CourierID = &courierID
+		}
+
+		deliveries, err := ActiveDeliveries(r.Context(), db, filter)
+		switch {
+		case errors.Is(err, errDeliveryState):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			log.Printf("Ошибка чтения активных доставок: %v", err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, deliveries)
+	}
+}
+
+// ReassignHandler вручную назначает заказ курьеру, а без courier_id возвращает его в очередь диспетчера
+// POST /deliveries/{order}/reassign {"courier_id": "..."}
+func ReassignHandler(providers *Providers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID, ok := pathUUID(w, r, "order")
+		if !ok {
+			return
+		}
+		var req struct {
+			CourierID *uuid.UUID `json:"courier_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := providers.Reassign(r.Context(), orderID, req.CourierID); err != nil {
+			if errors.Is(err, errCourierNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			writeDeliveryError(w, orderID, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ResolveHandler принудительно завершает доставку: complete - заказ доставлен, fail - доставка не удалась.
+// Для fail нужен код причины (без кода - other) и пояснение
+// POST /deliveries/{order}/complete, POST /deliveries/{order}/fail {"code": "courier_issue", "reason": "Курьер попал в ДТП"}
+func ResolveHandler(providers *Providers, to string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID, ok := pathUUID(w, r, "order")
+		if !ok {
+			return
+		}
+		var req struct {
+			Code   string `json:"code"`
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if to == DeliveryFailed {
+			if req.Code == "" {
+				req.Code = ReasonOther
+			}
+			if _, err := ParseReason(req.Code); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if strings.TrimSpace(req.Reason) == "" {
+				http.Error(w, "Не указана причина", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if err := providers.Resolve(r.Context(), orderID, to, req.Code, req.Reason, userFrom(r.Context())); err != nil {
+			writeDeliveryError(w, orderID, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SupportReturnHandler отмечает возврат заказа в ресторан за курьера или внешнюю службу
+// POST /deliveries/{order}/return
+func SupportReturnHandler(dispatcher *Dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID, ok := pathUUID(w, r, "order")
+		if !ok {
+			return
+		}
+		if err := dispatcher.Return(r.Context(), orderID, nil); err != nil {
+			writeDeliveryError(w, orderID, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ContactsHandler возвращает попытки курьеров связаться с клиентом по заказу
+// GET /deliveries/{order}/contacts
+func ContactsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID, ok := pathUUID(w, r, "order")
+		if !ok {
+			return
+		}
+		contacts, err := Contacts(r.Context(), db, orderID)
+		if err != nil {
+			log.Printf("Ошибка получения попыток связаться с клиентом по заказу %s: %v", orderID, err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, contacts)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CouriersHandler отдает курьеров с их статусом, сменой и загрузкой
+// GET /couriers
+func CouriersHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		couriers, err := ListCouriers(r.Context(), db)
+		if err != nil {
+			log.Printf("Ошибка чтения курьеров: %v", err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, couriers)
+	}
+}
+
+// CreateCourierHandler регистрирует курьера
+// POST /couriers {"name": "Иван", "phone": "+79990000000", "max_load": 2, "user_id": "..."}
+func CreateCourierHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name    string     `json:"name"`
+			Phone   string     `json:"phone"`
+			MaxLoad int        `json:"max_load"`
+			UserID  *uuid.UUID `json:"user_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Name == "" {
+			http.Error(w, "Не указано имя курьера", http.StatusBadRequest)
+			return
+		}
+
+		courier, err := CreateCourier(r.Context(), db, Courier{
+			UserID:  req.UserID,
+			Name:    req.Name,
+			Phone:   req.Phone,
+			MaxLoad: req.MaxLoad,
+		})
+		if err != nil {
+			log.Printf("Ошибка регистрации курьера: %v", err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, courier)
+	}
+}
+
+// ShiftHandler планирует смену курьера
+// POST /couriers/{id}/shifts {"starts_at": "2024-01-01T10:00:00Z", "ends_at": "2024-01-01T18:00:00Z"}
+func ShiftHandler(db *sql.DB, dispatcher *Dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		courierID, ok := pathUUID(w, r, "id")
+		if !ok {
+			return
+		}
+		var req struct {
+			StartsAt time.Time `json:"starts_at"`
+			EndsAt   time.Time `json:"ends_at"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		shift, err := AddShift(r.Context(), db, Shift{CourierID: courierID, StartsAt: req.StartsAt, EndsAt: req.EndsAt})
+		switch {
+		case errors.Is(err, errCourierNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, errInvalidShift):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			log.Printf("Ошибка планирования смены: %v", err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		// смена могла начаться сразу
+		dispatcher.Wake()
+		writeJSON(w, http.StatusCreated, shift)
+	}
+}
+
+// CourierStatusHandler меняет статус курьера: offline, available или break, вместе со статусом
+// можно передать текущие координаты
+// POST /couriers/{id}/status {"status": "available", "lat": 55.75, "lon": 37.61}
+func CourierStatusHandler(db *sql.DB, dispatcher *Dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		courierID, ok := pathUUID(w, r, "id")
+		if !ok {
+			return
+		}
+		var req struct {
+			Status string   `json:"status"`
+			Lat    *float64 `json:"lat"`
+			Lon    *float64 `json:"lon"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if (req.Lat == nil) != (req.Lon == nil) {
+			http.Error(w, "Координаты передаются парой lat и lon", http.StatusBadRequest)
+			return
+		}
+
+		courier, err := SetCourierStatus(r.Context(), db, courierID, req.Status, req.Lat, req.Lon)
+		switch {
+		case errors.Is(err, errCourierNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, errCourierStatus):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			log.Printf("Ошибка смены статуса курьера: %v", err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		if courier.Status == CourierAvailable {
+			dispatcher.Wake()
+		}
+		writeJSON(w, http.StatusOK, courier)
+	}
+}
+
+// DeliveryActionHandler отмечает переход доставки, о котором курьер сообщил диспетчеру:
+// pickup - забрал заказ в ресторане, deliver - передал клиенту
+// POST /couriers/{id}/deliveries/{order}/{action}
+func DeliveryActionHandler(dispatcher *Dispatcher) http.HandlerFunc {
+	actions := map[string]func(context.Context, uuid.UUID, uuid.UUID) error{
+		"pickup":  dispatcher.PickUp,
+		"deliver": dispatcher.Deliver,
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		action, ok := actions[r.PathValue("action")]
+		if !ok {
+			http.Error(w, "Неизвестное действие", http.StatusNotFound)
+			return
+		}
+		courierID, ok := pathUUID(w, r, "id")
+		if !ok {
+			return
+		}
+		orderID, ok := pathUUID(w, r, "order")
+		if !ok {
+			return
+		}
+
+		if err := action(r.Context(), courierID, orderID); err != nil {
+			writeDeliveryError(w, orderID, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// pathUUID читает идентификатор из пути запроса и отвечает 400, если он некорректен
+func pathUUID(w http.ResponseWriter, r *http.Request, name string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(r.PathValue(name))
+	if err != nil {
+		http.Error(w, "Некорректный идентификатор", http.StatusBadRequest)
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Ошибка записи ответа: %v", err)
+	}
+}
+
+// ProviderWebhookHandler принимает уведомление внешней службы доставки о статусе доставки
+// POST /providers/{provider}/webhook
+func ProviderWebhookHandler(providers *Providers, dispatcher *Dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := providers.Get(r.PathValue("provider"))
+		if !ok {
+			http.Error(w, errUnknownProvider.Error(), http.StatusNotFound)
+			return
+		}
+		update, err := provider.Webhook(r)
+		switch {
+		case errors.Is(err, errBadSignature):
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		case errors.Is(err, errNoWebhook):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch err := dispatcher.ProviderUpdate(r.Context(), provider.Name(), update); {
+		case errors.Is(err, errDeliveryNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, errDeliveryState):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			log.Printf("Ошибка обработки вебхука службы доставки %s: %v", provider.Name(), err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
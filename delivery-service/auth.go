@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+// роли, которым доступен API службы доставки
+const (
+	RoleDispatcher = "dispatcher"
+	RoleAdmin      = "admin"
+	RoleCourier    = "courier"
+	RoleSupport    = "support"
+)
+
+// courierKey - ключ идентификатора курьера в контексте запроса
+type courierKey struct{}
+
+// userKey - ключ идентификатора пользователя auth-service в контексте запроса
+type userKey struct{}
+
+// dispatcherOnly пропускает запрос, только если токен auth-service подписан ключом JWT_SECRET_KEY
+// и выдан диспетчеру доставки или администратору
+func dispatcherOnly(secret string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		_, role, err := tokenClaims(secret, token)
+		if err != nil {
+			log.Printf("Ошибка при валидации токена: %v", err)
+			http.Error(w, "Недействительный токен", http.StatusUnauthorized)
+			return
+		}
+		if role != RoleDispatcher && role != RoleAdmin {
+			http.Error(w, "Доступ только для диспетчеров доставки", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// supportOnly пропускает запрос, только если токен auth-service выдан сотруднику поддержки, диспетчеру
+// доставки или администратору, и кладет в контекст запроса идентификатор пользователя
+func supportOnly(secret string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		userID, role, err := tokenClaims(secret, token)
+		if err != nil {
+			log.Printf("Ошибка при валидации токена: %v", err)
+			http.Error(w, "Недействительный токен", http.StatusUnauthorized)
+			return
+		}
+		if role != RoleSupport && role != RoleDispatcher && role != RoleAdmin {
+			http.Error(w, "Доступ только для поддержки и диспетчеров доставки", http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), userKey{}, userID)))
+	}
+}
+
+// userFrom возвращает идентификатор пользователя, которого пропустил supportOnly
+func userFrom(ctx context.Context) uuid.UUID {
+	id, _ := ctx.Value(userKey{}).(uuid.UUID)
+	return id
+}
+
+// courierOnly пропускает запрос, только если токен auth-service выдан курьеру, и кладет
+// в контекст запроса идентификатор курьера, связанного с пользователем из токена
+func courierOnly(secret string, db *sql.DB, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		userID, role, err := tokenClaims(secret, token)
+		if err != nil {
+			log.Printf("Ошибка при валидации токена: %v", err)
+			http.Error(w, "Недействительный токен", http.StatusUnauthorized)
+			return
+		}
+		if role != RoleCourier {
+			http.Error(w, "Доступ только для курьеров", http.StatusForbidden)
+			return
+		}
+
+		var courierID uuid.UUID
+		err = db.QueryRowContext(r.Context(),
+			`SELECT courier_UUID FROM couriers WHERE user_UUID = $1`, userID).Scan(&courierID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Пользователь не зарегистрирован как курьер", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка поиска курьера: %v", err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), courierKey{}, courierID)))
+	}
+}
+
+// courierFrom возвращает идентификатор курьера, которого пропустил courierOnly
+func courierFrom(ctx context.Context) uuid.UUID {
+	id, _ := ctx.Value(courierKey{}).(uuid.UUID)
+	return id
+}
+
+// tokenClaims проверяет подпись токена и возвращает идентификатор и роль пользователя
+func tokenClaims(secret, tokenString string) (uuid.UUID, string, error) {
+	if secret == "" {
+		return uuid.Nil, "", fmt.Errorf("JWT_SECRET_KEY не задан")
+	}
+	if tokenString == "" {
+		return uuid.Nil, "", fmt.Errorf("токен не предоставлен")
+	}
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("неожиданный метод подписи: %v", token.Header["alg"])
+		}
+		return []byte(secret), nil
+	})
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return uuid.Nil, "", fmt.Errorf("недействительный токен")
+	}
+	role, _ := claims["role"].(string)
+	sub, _ := claims["sub"].(string)
+	userID, _ := uuid.Parse(sub)
+	return userID, role, nil
+}
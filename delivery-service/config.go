@@ -2,14 +2,113 @@ package main
 
 import (
 	"log"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
+
+	"github.com/sandrinasava/cafe-services/events/eta"
 )
 
 type Config struct {
+	Server struct {
+		Port string `env:"PORT" env-default:"8083"`
+	}
+
 	Kafka struct {
-		Broker string `env:"KAFKA_BROKER" env-default:"kafka:9092"`
-		Topic  string `env:"KAFKA_TOPIC_READY" env-default:"ready_orders"`
+		Broker      string `env:"KAFKA_BROKER" env-default:"kafka:9092"`
+		Topic       string `env:"KAFKA_TOPIC_READY" env-default:"ready_orders"`
+		TopicStatus string `env:"KAFKA_TOPIC_STATUS" env-default:"order_status"`
+		// топик событий доставки: назначение курьеров, загрузка курьеров и оценки времени доставки
+		TopicDelivery string `env:"KAFKA_TOPIC_DELIVERY" env-default:"delivery_events"`
+		// как часто отправлять в брокер смещения обработанных сообщений
+		CommitInterval time.Duration `env:"KAFKA_COMMIT_INTERVAL" env-default:"1s"`
+	}
+
+	Events struct {
+		// формат, в котором сервис публикует события: json или protobuf
+		Encoding string `env:"EVENTS_ENCODING" env-default:"json"`
+	}
+
+	Retry struct {
+		// задержки перед повторами, для каждой создается топик <topic>.retry.N
+		Delays []time.Duration `env:"RETRY_DELAYS" env-default:"5s,30s,2m"`
+		// общее число попыток обработки сообщения, после которого оно уходит в <topic>.dlq
+		MaxAttempts int `env:"RETRY_MAX_ATTEMPTS" env-default:"4"`
+	}
+
+	Dispatch struct {
+		// как выбирать курьера: nearest - ближайший к ресторану, least_loaded - наименее загруженный
+		Strategy string `env:"DISPATCH_STRATEGY" env-default:"nearest"`
+		// как часто повторять распределение ожидающих доставок, например когда начинаются смены
+		Interval time.Duration `env:"DISPATCH_INTERVAL" env-default:"10s"`
+		// средняя поездка курьера для оценки времени доставки, пока нет статистики доставок
+		Trip time.Duration `env:"ETA_TRIP_TIME" env-default:"30m"`
+	}
+
+	Batch struct {
+		// сколько попутных заказов курьер везет за одну поездку. 1 - каждый заказ отдельно
+		MaxOrders int `env:"BATCH_MAX_ORDERS" env-default:"3"`
+		// сколько готовый заказ ждет попутных, прежде чем уйти курьеру один
+		Window time.Duration `env:"BATCH_WINDOW" env-default:"3m"`
+		// на сколько градусов могут расходиться направления из ресторана на попутные заказы
+		MaxAngle float64 `env:"BATCH_MAX_ANGLE" env-default:"30"`
+	}
+
+	Failure struct {
+		// сколько всего попыток доставки, если заказ не удалось передать клиенту
+		MaxAttempts int `env:"DELIVERY_MAX_ATTEMPTS" env-default:"2"`
+		// через сколько после возврата заказа в ресторан пробовать доставить его еще раз
+		RetryDelay time.Duration `env:"DELIVERY_RETRY_DELAY" env-default:"30m"`
+		// сколько раз курьер должен попытаться связаться с клиентом, прежде чем отметить, что клиент недоступен
+		MinContacts int `env:"DELIVERY_MIN_CONTACT_ATTEMPTS" env-default:"2"`
+	}
+
+	Providers struct {
+		// основная служба доставки зоны: зона:служба через запятую, например outer:mock
+		Zones map[string]string `env:"DELIVERY_PROVIDERS"`
+		// служба доставки зон, которых нет в DELIVERY_PROVIDERS
+		Default string `env:"DELIVERY_PROVIDER_DEFAULT" env-default:"inhouse"`
+		// кому передается заказ, если основная служба от него отказалась
+		Fallback string `env:"DELIVERY_PROVIDER_FALLBACK" env-default:"inhouse"`
+		// сколько ждать ответа внешней службы
+		Timeout time.Duration `env:"DELIVERY_PROVIDER_TIMEOUT" env-default:"5s"`
+	}
+
+	MockProvider struct {
+		// тестовая служба доставки mock: ее API /mock-provider/... поднимается в этом же сервисе
+		Enabled    bool   `env:"MOCK_PROVIDER" env-default:"false"`
+		URL        string `env:"MOCK_PROVIDER_URL" env-default:"http://localhost:8083/mock-provider"`
+		WebhookURL string `env:"MOCK_PROVIDER_WEBHOOK_URL" env-default:"http://localhost:8083/providers/mock/webhook"`
+		Secret     string `env:"MOCK_PROVIDER_SECRET" env-default:"mock-secret"`
+		// дальше скольких метров от ресторана служба не везет
+		Radius float64 `env:"MOCK_PROVIDER_RADIUS" env-default:"5000"`
+		// через сколько курьер службы забирает заказ и еще через сколько передает клиенту
+		Step time.Duration `env:"MOCK_PROVIDER_STEP" env-default:"1m"`
+	}
+
+	// модель скорости курьера для оценки времени доставки, общая с order-service
+	ETA eta.Model
+
+	Restaurant struct {
+		// координаты ресторана, где курьеры забирают заказы
+		Lat float64 `env:"RESTAURANT_LAT" env-default:"55.7558"`
+		Lon float64 `env:"RESTAURANT_LON" env-default:"37.6173"`
+	}
+
+	Proof struct {
+		// где хранить фото и подписи: local - каталог PROOF_DIR, s3 - бакет S3-совместимого хранилища
+		Storage string `env:"PROOF_STORAGE" env-default:"local"`
+		Dir     string `env:"PROOF_DIR" env-default:"/var/lib/delivery/proofs"`
+		// максимальный размер запроса с подтверждением доставки в байтах
+		MaxSize int64 `env:"PROOF_MAX_SIZE" env-default:"10485760"`
+	}
+
+	S3 struct {
+		Endpoint  string `env:"S3_ENDPOINT"`
+		Bucket    string `env:"S3_BUCKET"`
+		AccessKey string `env:"S3_ACCESS_KEY"`
+		SecretKey string `env:"S3_SECRET_KEY"`
+		UseSSL    bool   `env:"S3_USE_SSL" env-default:"true"`
 	}
 
 	DB struct {
@@ -19,6 +118,10 @@ type Config struct {
 		Host     string `env:"REDIS_HOST" env-default:"redis:6379"`
 		Password string `env:"REDIS_PASSWORD" env-default:"defaultpassword"`
 	}
+
+	JWT struct {
+		SecretKey string `env:"JWT_SECRET_KEY"`
+	}
 }
 
 func loadConfig() (*Config, error) {
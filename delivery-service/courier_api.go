@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var errProofType = errors.New("подтверждение должно быть изображением JPEG, PNG или WebP")
+
+// расширения файлов подтверждений по типу содержимого
+var proofExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// MyDeliveriesHandler отдает курьеру заказы, которые он должен забрать или уже везет
+// GET /courier/deliveries
+func MyDeliveriesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deliveries, err := CourierDeliveries(r.Context(), db, courierFrom(r.Context()))
+		if err != nil {
+			log.Printf("Ошибка чтения доставок курьера: %v", err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, deliveries)
+	}
+}
+
+// PickupHandler отмечает, что курьер забрал заказ в ресторане
+// POST /courier/deliveries/{order}/pickup
+func PickupHandler(dispatcher *Dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID, ok := pathUUID(w, r, "order")
+		if !ok {
+			return
+		}
+		if err := dispatcher.PickUp(r.Context(), courierFrom(r.Context()), orderID); err != nil {
+			writeDeliveryError(w, orderID, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ProofHandler завершает доставку с подтверждением. Тело - multipart/form-data с полями
+// photo (фото заказа у клиента), signature (изображение подписи клиента) и pin (код, который назвал клиент),
+// нужно хотя бы одно из них
+// POST /courier/deliveries/{order}/deliver
+func ProofHandler(dispatcher *Dispatcher, store ProofStore, maxSize int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID, ok := pathUUID(w, r, "order")
+		if !ok {
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+		if err := r.ParseMultipartForm(maxSize); err != nil {
+			http.Error(w, "Некорректное подтверждение или файл слишком большой", http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		proof := Proof{PIN: strings.TrimSpace(r.FormValue("pin"))}
+		for field, key := range map[string]*string{"photo": &proof.PhotoKey, "signature": &proof.SignatureKey} {
+			file, header, err := r.FormFile(field)
+			if errors.Is(err, http.ErrMissingFile) {
+				continue
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			*key, err = saveProofFile(r, store, orderID, field, file, header)
+			file.Close()
+			if errors.Is(err, errProofType) {
+				http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+				return
+			}
+			if err != nil {
+				log.Printf("Ошибка сохранения подтверждения доставки %s: %v", orderID, err)
+				http.Error(w, "Не удалось сохранить подтверждение", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		err := dispatcher.DeliverWithProof(r.Context(), courierFrom(r.Context()), orderID, &proof)
+		switch {
+		case errors.Is(err, errNoProof):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, errWrongPIN):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case err != nil:
+			writeDeliveryError(w, orderID, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// saveProofFile проверяет тип файла по содержимому и сохраняет его под ключом <заказ>/<поле>.<расширение>
+func saveProofFile(r *http.Request, store ProofStore, orderID uuid.UUID, field string, file multipart.File, header *multipart.FileHeader) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	contentType := http.DetectContentType(head[:n])
+	ext, ok := proofExtensions[contentType]
+	if !ok {
+		return "", errProofType
+	}
+
+	key := fmt.Sprintf("%s/%s%s", orderID, field, ext)
+	body := io.MultiReader(bytes.NewReader(head[:n]), file)
+	if err := store.Save(r.Context(), key, contentType, body, header.Size); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// FailHandler отмечает, что курьер не смог передать заказ клиенту. code - код причины, без кода - other,
+// reason - пояснение. Отметить, что клиент недоступен, можно только после попыток с ним связаться
+// POST /courier/deliveries/{order}/fail {"code": "customer_unreachable", "reason": "Клиент не открывает дверь"}
+func FailHandler(dispatcher *Dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID, ok := pathUUID(w, r, "order")
+		if !ok {
+			return
+		}
+		var req struct {
+			Code   string `json:"code"`
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Code == "" {
+			req.Code = ReasonOther
+		}
+		if _, err := ParseReason(req.Code); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Code == ReasonOther && strings.TrimSpace(req.Reason) == "" {
+			http.Error(w, "Не указана причина", http.StatusBadRequest)
+			return
+		}
+
+		if err := dispatcher.Fail(r.Context(), courierFrom(r.Context()), orderID, req.Code, req.Reason); err != nil {
+			writeDeliveryError(w, orderID, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ReturnHandler отмечает, что курьер привез в ресторан заказ, который не удалось доставить
+// POST /courier/deliveries/{order}/return
+func ReturnHandler(dispatcher *Dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID, ok := pathUUID(w, r, "order")
+		if !ok {
+			return
+		}
+		courierID := courierFrom(r.Context())
+		if err := dispatcher.Return(r.Context(), orderID, &courierID); err != nil {
+			writeDeliveryError(w, orderID, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ContactHandler сохраняет попытку курьера связаться с клиентом по заказу в пути
+// POST /courier/deliveries/{order}/contacts {"method": "call", "result": "no_answer", "note": "Дважды"}
+func ContactHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID, ok := pathUUID(w, r, "order")
+		if !ok {
+			return
+		}
+		var c Contact
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.OrderID = orderID
+
+		c, err := LogContact(r.Context(), db, courierFrom(r.Context()), c)
+		switch {
+		case errors.Is(err, errContactMethod), errors.Is(err, errContactResult):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, errContactNoOrder):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			log.Printf("Ошибка сохранения попытки связаться с клиентом по заказу %s: %v", orderID, err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		default:
+			writeJSON(w, http.StatusCreated, c)
+		}
+	}
+}
+
+// writeDeliveryError отвечает на ошибку перехода статуса доставки
+func writeDeliveryError(w http.ResponseWriter, orderID uuid.UUID, err error) {
+	switch {
+	case errors.Is(err, errDeliveryNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errDeliveryState), errors.Is(err, errNoContact):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errFailureReason):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Ошибка смены статуса доставки %s: %v", orderID, err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+	}
+}
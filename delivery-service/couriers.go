@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// статусы курьера, которые он выставляет сам
+const (
+	CourierOffline   = "offline"
+	CourierAvailable = "available"
+	CourierBreak     = "break"
+)
+
+var (
+	errCourierNotFound = errors.New("курьер не найден")
+	errCourierStatus   = errors.New("недопустимый статус курьера: ожидается offline, available или break")
+	errInvalidShift    = errors.New("смена должна заканчиваться позже, чем начинается")
+)
+
+// Courier - курьер с текущей загрузкой
+type Courier struct {
+	ID      uuid.UUID  `json:"id"`
+	UserID  *uuid.UUID `json:"user_id,omitempty"`
+	Name    string     `json:"name"`
+	Phone   string     `json:"phone"`
+	Status  string     `json:"status"`
+	MaxLoad int        `json:"max_load"`
+	// сколько заказов курьер сейчас везет или должен забрать
+	Load       int        `json:"load"`
+	OnShift    bool       `json:"on_shift"`
+	Lat        *float64   `json:"lat,omitempty"`
+	Lon        *float64   `json:"lon,omitempty"`
+	LocationAt *time.Time `json:"location_at,omitempty"`
+}
+
+// Shift - смена курьера
+type Shift struct {
+	ID        int64     `json:"id"`
+	CourierID uuid.UUID `json:"courier_id"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+}
+
+// courierColumns - поля курьера с загрузкой и признаком смены, порядок совпадает с scanCourier
+const courierColumns = `c.courier_UUID, c.user_UUID, c.name, c.phone, c.status, c.max_load,
+	(SELECT count(*) FROM deliveries d WHERE d.courier_UUID = c.courier_UUID AND d.status IN ('assigned', 'picked_up')),
+	EXISTS (SELECT 1 FROM courier_shifts s WHERE s.courier_UUID = c.courier_UUID AND now() BETWEEN s.starts_at AND s.ends_at),
+	c.lat, c.lon, c.location_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanCourier(row scanner) (Courier, error) {
+	var c Courier
+	err := row.Scan(&c.ID, &c.UserID, &c.Name, &c.Phone, &c.Status, &c.MaxLoad,
+		&c.Load, &c.OnShift, &c.Lat, &c.Lon, &c.LocationAt)
+	return c, err
+}
+
+// CreateCourier регистрирует курьера. Новый курьер не на линии, пока не выставит статус available.
+// Пользователь auth-service, с которым связан курьер, получает роль courier, если у него была роль клиента:
+// после повторного входа его токен открывает API курьера
+func CreateCourier(ctx context.Context, db *sql.DB, c Courier) (Courier, error) {
+	if c.MaxLoad <= 0 {
+		c.MaxLoad = 1
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Courier{}, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO couriers (user_UUID, name, phone, max_load)
+		VALUES ($1, $2, $3, $4)
+		RETURNING courier_UUID, status`,
+		c.UserID, c.Name, c.Phone, c.MaxLoad).Scan(&c.ID, &c.Status)
+	if err != nil {
+		return Courier{}, err
+	}
+	if c.UserID != nil {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE users SET role = $2 WHERE user_UUID = $1 AND role = 'customer'`, *c.UserID, RoleCourier); err != nil {
+			return Courier{}, err
+		}
+	}
+	return c, tx.Commit()
+}
+
+// GetCourier возвращает курьера с текущей загрузкой
+func GetCourier(ctx context.Context, db *sql.DB, id uuid.UUID) (Courier, error) {
+	c, err := scanCourier(db.QueryRowContext(ctx,
+		`SELECT `+courierColumns+` FROM couriers c WHERE c.courier_UUID = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Courier{}, errCourierNotFound
+	}
+	return c, err
+}
+
+// ListCouriers возвращает всех курьеров, сначала тех, кто на линии
+func ListCouriers(ctx context.Context, db *sql.DB) ([]Courier, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+courierColumns+` FROM couriers c
+		ORDER BY c.status = 'available' DESC, c.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	couriers := []Courier{}
+	for rows.Next() {
+		c, err := scanCourier(rows)
+		if err != nil {
+			return nil, err
+		}
+		couriers = append(couriers, c)
+	}
+	return couriers, rows.Err()
+}
+
+// SetCourierStatus меняет статус курьера и, если переданы координаты, его местоположение
+func SetCourierStatus(ctx context.Context, db *sql.DB, id uuid.UUID, status string, lat, lon *float64) (Courier, error) {
+	switch status {
+	case CourierOffline, CourierAvailable, CourierBreak:
+	default:
+		return Courier{}, errCourierStatus
+	}
+
+	res, err := db.ExecContext(ctx, `
+		UPDATE couriers SET status = $2,
+			lat = COALESCE($3, lat), lon = COALESCE($4, lon),
+			location_at = CASE WHEN $3::double precision IS NULL THEN location_at ELSE now() END
+		WHERE courier_UUID = $1`,
+		id, status, lat, lon)
+	if err != nil {
+		return Courier{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return Courier{}, err
+	} else if n == 0 {
+		return Courier{}, errCourierNotFound
+	}
+	return GetCourier(ctx, db, id)
+}
+
+// AddShift планирует смену курьера
+func AddShift(ctx context.Context, db *sql.DB, s Shift) (Shift, error) {
+	if !s.EndsAt.After(s.StartsAt) {
+		return Shift{}, errInvalidShift
+	}
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO courier_shifts (courier_UUID, starts_at, ends_at)
+		SELECT courier_UUID, $2, $3 FROM couriers WHERE courier_UUID = $1
+		RETURNING id`,
+		s.CourierID, s.StartsAt, s.EndsAt).Scan(&s.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Shift{}, errCourierNotFound
+	}
+	if err != nil {
+		return Shift{}, fmt.Errorf("не удалось сохранить смену: %w", err)
+	}
+	return s, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var (
+	errWrongPIN = errors.New("код не совпадает с кодом заказа")
+	errNoProof  = errors.New("нужно подтверждение доставки: фото, подпись или код клиента")
+)
+
+// Delivery - доставка заказа, назначенная курьеру
+type Delivery struct {
+	OrderID uuid.UUID `json:"order_id"`
+	Status  string    `json:"status"`
+	Items   []string  `json:"items"`
+	Address string    `json:"address,omitempty"`
+	// подъезд, этаж, код домофона
+	AddressComment string     `json:"address_comment,omitempty"`
+	Lat            *float64   `json:"lat,omitempty"`
+	Lon            *float64   `json:"lon,omitempty"`
+	AssignedAt     *time.Time `json:"assigned_at,omitempty"`
+	PickedUpAt     *time.Time `json:"picked_up_at,omitempty"`
+	// поездка с попутными заказами и номер клиента в маршруте
+	BatchID *uuid.UUID `json:"batch_id,omitempty"`
+	Stop    int        `json:"stop"`
+	// когда курьер должен доехать до клиента
+	ETA *time.Time `json:"eta,omitempty"`
+}
+
+// CourierDeliveries возвращает заказы, которые курьер должен забрать или уже везет, в порядке назначения,
+// заказы одной поездки - в порядке объезда
+func CourierDeliveries(ctx context.Context, db *sql.DB, courierID uuid.UUID) ([]Delivery, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT order_UUID, status, items, address, address_comment, dest_lat, dest_lon, assigned_at, picked_up_at,
+			batch_UUID, stop_seq, eta
+		FROM deliveries
+		WHERE courier_UUID = $1 AND status IN ('assigned', 'picked_up')
+		ORDER BY assigned_at, stop_seq`, courierID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		if err := rows.Scan(&d.OrderID, &d.Status, pq.Array(&d.Items), &d.Address, &d.AddressComment, &d.Lat, &d.Lon,
+			&d.AssignedAt, &d.PickedUpAt, &d.BatchID, &d.Stop, &d.ETA); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// Proof - подтверждение доставки. Файлы уже лежат в ProofStore, здесь только их ключи
+type Proof struct {
+	PhotoKey     string
+	SignatureKey string
+	// код, который клиент назвал курьеру
+	PIN string
+}
+
+// save проверяет код клиента и сохраняет подтверждение в транзакции завершения доставки
+func (p *Proof) save(ctx context.Context, tx *sql.Tx, orderID uuid.UUID) error {
+	if p.PhotoKey == "" && p.SignatureKey == "" && p.PIN == "" {
+		return errNoProof
+	}
+
+	pinVerified := false
+	if p.PIN != "" {
+		var pin string
+		if err := tx.QueryRowContext(ctx, `SELECT pin FROM deliveries WHERE order_UUID = $1`, orderID).Scan(&pin); err != nil {
+			return err
+		}
+		if pin == "" || subtle.ConstantTimeCompare([]byte(pin), []byte(p.PIN)) != 1 {
+			return errWrongPIN
+		}
+		pinVerified = true
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO delivery_proofs (order_UUID, photo_key, signature_key, pin_verified)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4)
+		ON CONFLICT (order_UUID) DO UPDATE SET photo_key = EXCLUDED.photo_key,
+			signature_key = EXCLUDED.signature_key, pin_verified = EXCLUDED.pin_verified`,
+		orderID, p.PhotoKey, p.SignatureKey, pinVerified)
+	if err != nil {
+		return fmt.Errorf("не удалось сохранить подтверждение доставки: %w", err)
+	}
+	return nil
+}
+
+// newPIN - случайный четырехзначный код получения заказа
+func newPIN() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(10000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%04d", n.Int64()), nil
+}
@@ -0,0 +1,674 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/events/eta"
+)
+
+// статусы доставки
+const (
+	DeliveryPending   = "pending"
+	DeliveryAssigned  = "assigned"
+	DeliveryPickedUp  = "picked_up"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed_delivery"
+)
+
+// сколько ожидающих доставок диспетчер распределяет за одну транзакцию
+const dispatchBatch = 50
+
+var (
+	errDeliveryNotFound = errors.New("доставка не найдена или назначена другому курьеру")
+	errDeliveryState    = errors.New("недопустимый переход статуса доставки")
+	errUnknownStrategy  = errors.New("неизвестная стратегия диспетчера: ожидается nearest или least_loaded")
+)
+
+// Candidate - курьер на смене и на линии, у которого есть место для еще одного заказа
+type Candidate struct {
+	ID       uuid.UUID
+	Name     string
+	Load     int
+	MaxLoad  int
+	Location *eta.Point
+	// когда курьер последний раз освободился
+	IdleSince time.Time
+}
+
+// Strategy выбирает курьера для заказа, который нужно забрать в точке pickup.
+// Choose возвращает индекс курьера в candidates, список не пуст
+type Strategy interface {
+	Name() string
+	Choose(pickup eta.Point, candidates []Candidate) int
+}
+
+// strategies - стратегии, доступные через DISPATCH_STRATEGY
+var strategies = map[string]Strategy{
+	"nearest":      Nearest{},
+	"least_loaded": LeastLoaded{},
+}
+
+// ParseStrategy возвращает стратегию диспетчера по имени
+func ParseStrategy(name string) (Strategy, error) {
+	s, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", errUnknownStrategy, name)
+	}
+	return s, nil
+}
+
+// Nearest назначает ближайшего к ресторану курьера. Курьеры без известного местоположения
+// получают заказ, только если других нет, при равном расстоянии - менее загруженный
+type Nearest struct{}
+
+func (Nearest) Name() string { return "nearest" }
+
+func (Nearest) Choose(pickup eta.Point, candidates []Candidate) int {
+	best, bestDist := 0, math.Inf(1)
+	for i, c := range candidates {
+		dist := math.Inf(1)
+		if c.Location != nil {
+			dist = eta.Distance(*c.Location, pickup)
+		}
+		if i == 0 || dist < bestDist || (dist == bestDist && lessLoaded(c, candidates[best])) {
+			best, bestDist = i, dist
+		}
+	}
+	return best
+}
+
+// LeastLoaded назначает курьера с наименьшим числом заказов, при равной загрузке - того,
+// кто дольше ждет заказа
+type LeastLoaded struct{}
+
+func (LeastLoaded) Name() string { return "least_loaded" }
+
+func (LeastLoaded) Choose(pickup eta.Point, candidates []Candidate) int {
+	best := 0
+	for i, c := range candidates[1:] {
+		if lessLoaded(c, candidates[best]) {
+			best = i + 1
+		}
+	}
+	return best
+}
+
+func lessLoaded(a, b Candidate) bool {
+	if a.Load != b.Load {
+		return a.Load < b.Load
+	}
+	return a.IdleSince.Before(b.IdleSince)
+}
+
+// Dispatcher назначает курьеров на готовые заказы. Заказ из ready_orders сохраняется в deliveries
+// и ждет, пока на смене появится свободный курьер. Диспетчер распределяет ожидающие доставки
+// сразу после новых заказов и смены статусов курьеров, а также раз в interval - так заказы
+// получают курьеров, чьи смены начались по расписанию
+type Dispatcher struct {
+	db           *sql.DB
+	events       *kafka.Writer
+	statusWriter *kafka.Writer
+	enc          events.Encoding
+	strategy     Strategy
+	pickup       eta.Point
+	interval     time.Duration
+	wake         chan struct{}
+	// модель скорости курьера и средняя поездка, пока нет статистики доставок
+	model    eta.Model
+	trip     time.Duration
+	batching Batching
+	failures FailurePolicy
+	// когда диспетчер последний раз без ошибок разобрал очередь, UnixNano
+	lastRun atomic.Int64
+}
+
+func NewDispatcher(db *sql.DB, eventsWriter, statusWriter *kafka.Writer, enc events.Encoding, strategy Strategy, pickup eta.Point, interval time.Duration, model eta.Model, trip time.Duration, batching Batching, failures FailurePolicy) *Dispatcher {
+	return &Dispatcher{
+		db:           db,
+		events:       eventsWriter,
+		statusWriter: statusWriter,
+		enc:          enc,
+		strategy:     strategy,
+		pickup:       pickup,
+		interval:     interval,
+		wake:         make(chan struct{}, 1),
+		model:        model,
+		trip:         trip,
+		batching:     batching,
+		failures:     failures,
+	}
+}
+
+// LastRun возвращает, когда диспетчер последний раз без ошибок разобрал очередь. Нулевое время - еще ни разу
+func (d *Dispatcher) LastRun() time.Time {
+	if n := d.lastRun.Load(); n != 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
+// Wake просит диспетчера распределить ожидающие доставки, не дожидаясь интервала
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue сохраняет доставку заказа, принятого службой provider, и придумывает код, который клиент назовет курьеру.
+// Заказ собственных курьеров встает в очередь диспетчера, заказ внешней службы сразу считается назначенным,
+// а ее оценка прибытия отправляется order-service. Повторное сообщение о том же заказе игнорируется
+func (d *Dispatcher) Enqueue(ctx context.Context, orderID uuid.UUID, order events.Order, correlationID, provider string, delivery ProviderDelivery) error {
+	pin, err := newPIN()
+	if err != nil {
+		return err
+	}
+	// у заказов, оформленных до появления адресов, координат нет
+	var lat, lon *float64
+	if order.Lat != 0 || order.Lon != 0 {
+		lat, lon = &order.Lat, &order.Lon
+	}
+	status, externalID, arriveAt := DeliveryPending, (*string)(nil), (*time.Time)(nil)
+	if provider != InHouseProvider {
+		status, externalID, arriveAt = DeliveryAssigned, &delivery.ExternalID, delivery.ArriveAt
+	}
+	_, err = d.db.ExecContext(ctx, `
+		INSERT INTO deliveries (order_UUID, items, pin, correlation_id, address, address_comment, dest_lat, dest_lon,
+			provider, external_id, status, assigned_at, eta)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, CASE WHEN $11 = 'assigned' THEN now() END, $12)
+		ON CONFLICT (order_UUID) DO NOTHING`,
+		orderID, pq.Array(order.Items), pin, correlationID, order.Address, order.AddressComment, lat, lon,
+		provider, externalID, status, arriveAt)
+	if err != nil {
+		return fmt.Errorf("не удалось сохранить доставку: %w", err)
+	}
+	if status == DeliveryPending {
+		d.Wake()
+		return nil
+	}
+	if arriveAt != nil {
+		a := events.DeliveryETA{OrderID: orderID.String(), ArriveAt: *arriveAt, At: time.Now()}
+		if err := publish(d.events, d.enc, events.TypeDeliveryETA, correlationID, a.OrderID, a); err != nil {
+			log.Printf("ошибка отправки оценки времени доставки заказа %s в брокер: %v", orderID, err)
+		}
+	}
+	return nil
+}
+
+// Provider возвращает службу, которой передан заказ. Пустая строка - заказ еще никому не передан
+func (d *Dispatcher) Provider(ctx context.Context, orderID uuid.UUID) (string, error) {
+	var provider string
+	err := d.db.QueryRowContext(ctx, `SELECT provider FROM deliveries WHERE order_UUID = $1`, orderID).Scan(&provider)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return provider, err
+}
+
+// Withdraw убирает из очереди доставку, на которую еще не назначен курьер
+func (d *Dispatcher) Withdraw(ctx context.Context, orderID uuid.UUID) error {
+	res, err := d.db.ExecContext(ctx, `
+		DELETE FROM deliveries WHERE order_UUID = $1 AND status = 'pending' AND provider = $2`,
+		orderID, InHouseProvider)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	// доставки нет - отзывать нечего
+	if provider, err := d.Provider(ctx, orderID); err != nil || provider == "" {
+		return err
+	}
+	return fmt.Errorf("%w: курьер уже назначен", errDeliveryState)
+}
+
+// Run распределяет доставки до отмены ctx
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			assigned, err := d.dispatch(ctx)
+			if err != nil {
+				log.Printf("Ошибка распределения доставок: %v", err)
+				break
+			}
+			// пачка разобрана целиком - возможно, в очереди есть еще доставки
+			if assigned < dispatchBatch {
+				d.lastRun.Store(time.Now().UnixNano())
+				break
+			}
+		}
+		if err := d.publishLoad(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Ошибка публикации загрузки курьеров: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// dispatch назначает курьеров на самые старые ожидающие доставки и возвращает число назначений.
+// Попутные заказы объединяются в одну поездку курьера, заказ ждет попутных не дольше окна объединения
+func (d *Dispatcher) dispatch(ctx context.Context) (int, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// SKIP LOCKED: несколько экземпляров сервиса не назначат курьеров на одну доставку дважды
+	rows, err := tx.QueryContext(ctx, `
+		SELECT order_UUID, correlation_id, pin, created_at, dest_lat, dest_lon FROM deliveries
+		WHERE status = 'pending' AND (retry_at IS NULL OR retry_at <= now())
+		ORDER BY created_at
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED`, dispatchBatch)
+	if err != nil {
+		return 0, err
+	}
+	var queue []queued
+	for rows.Next() {
+		var (
+			q        queued
+			lat, lon sql.NullFloat64
+		)
+		if err := rows.Scan(&q.orderID, &q.correlationID, &q.pin, &q.createdAt, &lat, &lon); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if lat.Valid && lon.Valid {
+			q.dest = &eta.Point{Lat: lat.Float64, Lon: lon.Float64}
+		}
+		queue = append(queue, q)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(queue) == 0 {
+		return 0, nil
+	}
+
+	candidates, err := d.candidates(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		assignments []events.CourierAssigned
+		arrivals    []events.DeliveryETA
+	)
+	now := time.Now()
+	for _, group := range d.batching.Group(d.pickup, queue) {
+		if !d.batching.Ready(group, now) {
+			continue
+		}
+		free := candidates[:0:0]
+		for _, c := range candidates {
+			if c.Load < c.MaxLoad {
+				free = append(free, c)
+			}
+		}
+		if len(free) == 0 {
+			break
+		}
+		chosen := free[d.strategy.Choose(d.pickup, free)]
+		// курьер берет столько заказов поездки, сколько у него свободных мест, остальные ждут следующего
+		if n := chosen.MaxLoad - chosen.Load; len(group) > n {
+			group = group[:n]
+		}
+
+		stops, etas := d.plan(group, chosen.Location, now)
+		var batchID *uuid.UUID
+		if len(stops) > 1 {
+			id := uuid.New()
+			batchID = &id
+		}
+		for i, q := range stops {
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE deliveries SET courier_UUID = $2, status = 'assigned', assigned_at = now(),
+					batch_UUID = $3, stop_seq = $4, eta = $5
+				WHERE order_UUID = $1`,
+				q.orderID, chosen.ID, batchID, i+1, etas[i]); err != nil {
+				return 0, fmt.Errorf("не удалось назначить курьера: %w", err)
+			}
+			assignments = append(assignments, events.CourierAssigned{
+				OrderID:     q.orderID.String(),
+				CourierID:   chosen.ID.String(),
+				CourierName: chosen.Name,
+				Strategy:    d.strategy.Name(),
+				At:          now,
+				PIN:         q.pin,
+			})
+			if etas[i] != nil {
+				arrivals = append(arrivals, events.DeliveryETA{OrderID: q.orderID.String(), ArriveAt: *etas[i], At: now})
+			}
+		}
+		for i := range candidates {
+			if candidates[i].ID == chosen.ID {
+				candidates[i].Load += len(stops)
+			}
+		}
+		if batchID != nil {
+			log.Printf("Курьер %s везет %d заказа одной поездкой %s", chosen.Name, len(stops), batchID)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	correlation := make(map[string]string, len(queue))
+	for _, q := range queue {
+		correlation[q.orderID.String()] = q.correlationID
+	}
+	for _, a := range assignments {
+		log.Printf("На заказ %s назначен курьер %s (%s)", a.OrderID, a.CourierName, a.Strategy)
+		if err := publish(d.events, d.enc, events.TypeCourierAssigned, correlation[a.OrderID], a.OrderID, a); err != nil {
+			log.Printf("ошибка отправки назначения курьера на заказ %s в брокер: %v", a.OrderID, err)
+		}
+	}
+	for _, a := range arrivals {
+		if err := publish(d.events, d.enc, events.TypeDeliveryETA, correlation[a.OrderID], a.OrderID, a); err != nil {
+			log.Printf("ошибка отправки оценки времени доставки заказа %s в брокер: %v", a.OrderID, err)
+		}
+	}
+	return len(assignments), nil
+}
+
+// plan упорядочивает заказы поездки по маршруту из ресторана и оценивает время прибытия к каждому клиенту.
+// Курьер сначала едет из from в ресторан, если где он неизвестно - считается уже в ресторане.
+// Заказы без адреса идут в конце маршрута без оценки
+func (d *Dispatcher) plan(group []queued, from *eta.Point, now time.Time) ([]queued, []*time.Time) {
+	var (
+		routed, rest []queued
+		points       []eta.Point
+	)
+	for _, q := range group {
+		if q.dest == nil {
+			rest = append(rest, q)
+			continue
+		}
+		routed = append(routed, q)
+		points = append(points, *q.dest)
+	}
+
+	order := Route(d.pickup, points)
+	stops := make([]queued, 0, len(group))
+	route := make([]eta.Point, 0, len(order))
+	for _, i := range order {
+		stops = append(stops, routed[i])
+		route = append(route, points[i])
+	}
+
+	start := now
+	if from != nil {
+		start = start.Add(d.model.Travel(*from, d.pickup, now))
+	}
+	etas := make([]*time.Time, len(group))
+	for i, t := range stopETAs(d.model, d.pickup, route, start) {
+		etas[i] = &t
+	}
+	return append(stops, rest...), etas
+}
+
+// candidates блокирует курьеров на смене и на линии до конца транзакции,
+// чтобы параллельное назначение не превысило их загрузку
+func (d *Dispatcher) candidates(ctx context.Context, tx *sql.Tx) ([]Candidate, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT c.courier_UUID, c.name, c.max_load, c.lat, c.lon, c.idle_since,
+			(SELECT count(*) FROM deliveries d WHERE d.courier_UUID = c.courier_UUID AND d.status IN ('assigned', 'picked_up'))
+		FROM couriers c
+		WHERE c.status = 'available'
+			AND EXISTS (SELECT 1 FROM courier_shifts s
+				WHERE s.courier_UUID = c.courier_UUID AND now() BETWEEN s.starts_at AND s.ends_at)
+		ORDER BY c.courier_UUID
+		FOR UPDATE OF c`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []Candidate
+	for rows.Next() {
+		var (
+			c        Candidate
+			lat, lon sql.NullFloat64
+		)
+		if err := rows.Scan(&c.ID, &c.Name, &c.MaxLoad, &lat, &lon, &c.IdleSince, &c.Load); err != nil {
+			return nil, err
+		}
+		if lat.Valid && lon.Valid {
+			c.Location = &eta.Point{Lat: lat.Float64, Lon: lon.Float64}
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
+}
+
+// PickUp отмечает, что курьер забрал заказ в ресторане, и сообщает order-service, что заказ в пути
+// и когда приедут заказы его поездки
+func (d *Dispatcher) PickUp(ctx context.Context, courierID, orderID uuid.UUID) error {
+	if err := d.advance(ctx, courierID, orderID, DeliveryAssigned, DeliveryPickedUp, "delivering", nil); err != nil {
+		return err
+	}
+	if err := d.publishArrivals(ctx, orderID); err != nil {
+		log.Printf("ошибка отправки оценки времени доставки заказа %s в брокер: %v", orderID, err)
+	}
+	return nil
+}
+
+// Deliver отмечает, что курьер передал заказ клиенту, и сообщает order-service, что заказ доставлен
+func (d *Dispatcher) Deliver(ctx context.Context, courierID, orderID uuid.UUID) error {
+	return d.DeliverWithProof(ctx, courierID, orderID, nil)
+}
+
+// DeliverWithProof завершает доставку и сохраняет подтверждение в той же транзакции.
+// Если курьер передал код, он должен совпасть с кодом доставки
+func (d *Dispatcher) DeliverWithProof(ctx context.Context, courierID, orderID uuid.UUID, proof *Proof) error {
+	var save func(*sql.Tx) error
+	if proof != nil {
+		save = func(tx *sql.Tx) error { return proof.save(ctx, tx, orderID) }
+	}
+	if err := d.advance(ctx, courierID, orderID, DeliveryPickedUp, DeliveryDelivered, "delivered", save); err != nil {
+		return err
+	}
+	// у курьера освободилось место для следующего заказа
+	d.Wake()
+	return nil
+}
+
+// Fail отмечает, что курьер не смог передать заказ клиенту по причине code, и сообщает об этом order-service.
+// Курьер везет заказ обратно в ресторан и отмечает возврат через Return
+func (d *Dispatcher) Fail(ctx context.Context, courierID, orderID uuid.UUID, code, note string) error {
+	saveReason := func(tx *sql.Tx) error {
+		return d.recordFailure(ctx, tx, orderID, code, note, true)
+	}
+	if err := d.advance(ctx, courierID, orderID, DeliveryPickedUp, DeliveryFailed, "failed_delivery", saveReason); err != nil {
+		return err
+	}
+	d.Wake()
+	return nil
+}
+
+// статус заказа в order-service по статусу доставки
+var orderStatuses = map[string]string{
+	DeliveryPickedUp:  "delivering",
+	DeliveryDelivered: "delivered",
+	DeliveryFailed:    "failed_delivery",
+}
+
+// ProviderUpdate применяет статус доставки из вебхука внешней службы. Повторный вебхук с тем же статусом
+// ничего не меняет. Если служба отказалась от заказа до того, как его забрали, заказ возвращается
+// в очередь собственных курьеров
+func (d *Dispatcher) ProviderUpdate(ctx context.Context, provider string, u ProviderUpdate) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		orderID               uuid.UUID
+		status, correlationID string
+	)
+	err = tx.QueryRowContext(ctx, `
+		SELECT order_UUID, status, correlation_id FROM deliveries
+		WHERE provider = $1 AND external_id = $2
+		FOR UPDATE`,
+		provider, u.ExternalID).Scan(&orderID, &status, &correlationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errDeliveryNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if status == u.Status {
+		return nil
+	}
+	// курьер службы привез заказ обратно в ресторан
+	if u.Status == DeliveryReturned {
+		tx.Rollback()
+		return d.Return(ctx, orderID, nil)
+	}
+
+	switch {
+	case u.Status == ProviderCancelled && status == DeliveryAssigned:
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE deliveries SET status = 'pending', provider = $2, external_id = NULL, assigned_at = NULL, eta = NULL
+			WHERE order_UUID = $1`,
+			orderID, InHouseProvider); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("Служба доставки %s отказалась от заказа %s, заказ повезут собственные курьеры", provider, orderID)
+		d.Wake()
+		return nil
+	case u.Status == DeliveryPickedUp && status == DeliveryAssigned,
+		u.Status == DeliveryDelivered && status == DeliveryPickedUp,
+		u.Status == DeliveryFailed && (status == DeliveryAssigned || status == DeliveryPickedUp):
+	default:
+		return fmt.Errorf("%w: %s -> %s", errDeliveryState, status, u.Status)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE deliveries SET status = $2,
+			picked_up_at = CASE WHEN $2 = 'picked_up' THEN now() ELSE picked_up_at END,
+			delivered_at = CASE WHEN $2 = 'delivered' THEN now() ELSE delivered_at END,
+			eta = COALESCE($3, eta)
+		WHERE order_UUID = $1`,
+		orderID, u.Status, u.ArriveAt); err != nil {
+		return err
+	}
+	if u.Status == DeliveryFailed {
+		// служба сообщает причину своими словами: известный код сохраняется как код, остальное - как other
+		code, err := ParseReason(u.Reason)
+		if err != nil {
+			code = ReasonOther
+		}
+		if err := d.recordFailure(ctx, tx, orderID, code, u.Reason, false); err != nil {
+			return err
+		}
+	}
+	if err := publishStatus(d.statusWriter, d.enc, correlationID, orderID.String(), orderStatuses[u.Status]); err != nil {
+		return fmt.Errorf("ошибка отправки статуса заказа %s в брокер: %w", orderID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Заказ %s: %s (служба доставки %s)", orderID, u.Status, provider)
+
+	if u.ArriveAt != nil && u.Status == DeliveryPickedUp {
+		a := events.DeliveryETA{OrderID: orderID.String(), ArriveAt: *u.ArriveAt, At: time.Now()}
+		if err := publish(d.events, d.enc, events.TypeDeliveryETA, correlationID, a.OrderID, a); err != nil {
+			log.Printf("ошибка отправки оценки времени доставки заказа %s в брокер: %v", orderID, err)
+		}
+	}
+	return nil
+}
+
+// advance переводит доставку курьера из статуса from в to и вызывает apply в той же транзакции.
+// Статус заказа публикуется до фиксации транзакции: если брокер недоступен, переход не сохраняется
+// и курьер может повторить запрос
+func (d *Dispatcher) advance(ctx context.Context, courierID, orderID uuid.UUID, from, to, orderStatus string, apply func(*sql.Tx) error) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status, correlationID string
+	err = tx.QueryRowContext(ctx, `
+		SELECT status, correlation_id FROM deliveries
+		WHERE order_UUID = $1 AND courier_UUID = $2
+		FOR UPDATE`,
+		orderID, courierID).Scan(&status, &correlationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errDeliveryNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if status != from {
+		return fmt.Errorf("%w: %s -> %s", errDeliveryState, status, to)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE deliveries SET status = $2,
+			picked_up_at = CASE WHEN $2 = 'picked_up' THEN now() ELSE picked_up_at END,
+			delivered_at = CASE WHEN $2 = 'delivered' THEN now() ELSE delivered_at END
+		WHERE order_UUID = $1`,
+		orderID, to); err != nil {
+		return err
+	}
+	if apply != nil {
+		if err := apply(tx); err != nil {
+			return err
+		}
+	}
+	if to != DeliveryPickedUp {
+		if err := releaseCourier(ctx, tx, courierID); err != nil {
+			return err
+		}
+	}
+
+	// Статус в бд и кэше обновляет order-service по событию из order_status
+	if err := publishStatus(d.statusWriter, d.enc, correlationID, orderID.String(), orderStatus); err != nil {
+		return fmt.Errorf("ошибка отправки статуса заказа %s в брокер: %w", orderID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Заказ %s: %s", orderID, to)
+	return nil
+}
+
+// releaseCourier ставит курьера, развезшего все заказы, в конец очереди ожидающих
+func releaseCourier(ctx context.Context, tx *sql.Tx, courierID uuid.UUID) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE couriers SET idle_since = now()
+		WHERE courier_UUID = $1 AND NOT EXISTS (SELECT 1 FROM deliveries
+			WHERE courier_UUID = $1 AND status IN ('assigned', 'picked_up'))`,
+		courierID)
+	return err
+}
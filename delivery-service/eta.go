@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/events/eta"
+)
+
+// за какой период средняя длительность доставок считается поездкой курьера
+const tripWindow = 2 * time.Hour
+
+// publishArrivals пересчитывает время доставки заказов поездки, когда курьер забрал один из них в ресторане:
+// курьер выезжает сейчас и объезжает клиентов по маршруту. Оценка сохраняется и отправляется order-service
+func (d *Dispatcher) publishArrivals(ctx context.Context, orderID uuid.UUID) error {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT order_UUID, correlation_id, dest_lat, dest_lon FROM deliveries
+		WHERE (order_UUID = $1 OR batch_UUID = (SELECT batch_UUID FROM deliveries WHERE order_UUID = $1))
+			AND status IN ('assigned', 'picked_up') AND dest_lat IS NOT NULL AND dest_lon IS NOT NULL
+		ORDER BY stop_seq`, orderID)
+	if err != nil {
+		return err
+	}
+	var (
+		stops  []queued
+		points []eta.Point
+	)
+	for rows.Next() {
+		var q queued
+		var p eta.Point
+		if err := rows.Scan(&q.orderID, &q.correlationID, &p.Lat, &p.Lon); err != nil {
+			rows.Close()
+			return err
+		}
+		stops = append(stops, q)
+		points = append(points, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i, arrive := range stopETAs(d.model, d.pickup, points, now) {
+		q := stops[i]
+		if _, err := d.db.ExecContext(ctx, `UPDATE deliveries SET eta = $2 WHERE order_UUID = $1`, q.orderID, arrive); err != nil {
+			return err
+		}
+		a := events.DeliveryETA{OrderID: q.orderID.String(), ArriveAt: arrive, At: now}
+		if err := publish(d.events, d.enc, events.TypeDeliveryETA, q.correlationID, a.OrderID, a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishLoad сообщает, сколько курьеров свободно и сколько заказов ждет курьера. По этим данным
+// order-service оценивает время доставки нового заказа. Поездка курьера - средняя длительность
+// доставки собственными курьерами от назначения до передачи клиенту за последние tripWindow, пока доставок не было - d.trip
+func (d *Dispatcher) publishLoad(ctx context.Context) error {
+	var (
+		load events.CourierLoad
+		trip sql.NullFloat64
+	)
+	err := d.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT count(*) FILTER (WHERE c.load < c.max_load) FROM (
+				SELECT c.max_load, (SELECT count(*) FROM deliveries d
+					WHERE d.courier_UUID = c.courier_UUID AND d.status IN ('assigned', 'picked_up')) AS load
+				FROM couriers c
+				WHERE c.status = 'available'
+					AND EXISTS (SELECT 1 FROM courier_shifts s
+						WHERE s.courier_UUID = c.courier_UUID AND now() BETWEEN s.starts_at AND s.ends_at)) c),
+			(SELECT count(*) FROM couriers c
+				WHERE c.status = 'available'
+					AND EXISTS (SELECT 1 FROM courier_shifts s
+						WHERE s.courier_UUID = c.courier_UUID AND now() BETWEEN s.starts_at AND s.ends_at)),
+			(SELECT count(*) FROM deliveries WHERE status = 'pending' AND (retry_at IS NULL OR retry_at <= now())),
+			(SELECT EXTRACT(EPOCH FROM avg(delivered_at - assigned_at)) FROM deliveries
+				WHERE status = 'delivered' AND provider = $2 AND delivered_at > now() - $1 * interval '1 second')`,
+		tripWindow.Seconds(), InHouseProvider).Scan(&load.Available, &load.OnShift, &load.Queued, &trip)
+	if err != nil {
+		return err
+	}
+
+	avgTrip := d.trip
+	if trip.Valid && trip.Float64 > 0 {
+		avgTrip = time.Duration(trip.Float64 * float64(time.Second))
+	}
+	if wait, ok := eta.CourierWait(load.Available, load.OnShift, load.Queued, avgTrip); ok {
+		load.EstimatedWaitSeconds = int64(wait.Seconds())
+	} else {
+		// курьеров на смене нет: заказ будет ждать хотя бы одну поездку после начала смены
+		load.EstimatedWaitSeconds = int64(avgTrip.Seconds())
+	}
+	load.At = time.Now()
+	return publish(d.events, d.enc, events.TypeCourierLoad, "", "courier_load", load)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sandrinasava/cafe-services/events"
+)
+
+// DeliveryReturned - курьер вернул в ресторан заказ, который не удалось доставить
+const DeliveryReturned = "returned"
+
+// Коды причин, по которым не удалась доставка
+const (
+	ReasonCustomerUnreachable = "customer_unreachable"
+	ReasonWrongAddress        = "wrong_address"
+	ReasonAccessDenied        = "access_denied"
+	ReasonCustomerRefused     = "customer_refused"
+	ReasonDamaged             = "damaged"
+	ReasonCourierIssue        = "courier_issue"
+	ReasonOther               = "other"
+)
+
+var (
+	errFailureReason = errors.New("неизвестный код причины: ожидается customer_unreachable, wrong_address, access_denied, " +
+		"customer_refused, damaged, courier_issue или other")
+	errNoContact      = errors.New("прежде чем отметить, что клиент недоступен, нужно попытаться с ним связаться")
+	errContactMethod  = errors.New("неизвестный способ связи: ожидается call, sms, intercom или doorbell")
+	errContactResult  = errors.New("неизвестный результат: ожидается no_answer, busy, answered или wrong_number")
+	errContactNoOrder = errors.New("с клиентом связываются, когда заказ в пути")
+)
+
+// reasonRule - что делать с заказом, который не удалось доставить по этой причине
+type reasonRule struct {
+	// можно ли попробовать доставить еще раз
+	retry bool
+	// компенсация клиенту, если доставить так и не удалось
+	compensation string
+	// причину можно указать только после попыток связаться с клиентом
+	needsContact bool
+}
+
+// по вине клиента еда возвращается бонусами, по вине ресторана или курьера - деньгами
+var reasonRules = map[string]reasonRule{
+	ReasonCustomerUnreachable: {retry: true, compensation: events.CompensationCredit, needsContact: true},
+	ReasonWrongAddress:        {retry: true, compensation: events.CompensationCredit},
+	ReasonAccessDenied:        {retry: true, compensation: events.CompensationCredit},
+	ReasonCustomerRefused:     {compensation: events.CompensationCredit},
+	ReasonDamaged:             {compensation: events.CompensationRefund},
+	ReasonCourierIssue:        {retry: true, compensation: events.CompensationRefund},
+	ReasonOther:               {compensation: events.CompensationRefund},
+}
+
+// FailurePolicy - правила повторной доставки заказов, которые не удалось передать клиенту
+type FailurePolicy struct {
+	// сколько всего попыток доставки
+	MaxAttempts int
+	// через сколько после возврата заказа в ресторан пробовать еще раз
+	RetryDelay time.Duration
+	// сколько раз курьер должен попытаться связаться с клиентом, прежде чем отметить, что клиент недоступен
+	MinContacts int
+}
+
+// ParseReason проверяет код причины
+func ParseReason(code string) (string, error) {
+	if _, ok := reasonRules[code]; !ok {
+		return "", fmt.Errorf("%w: %q", errFailureReason, code)
+	}
+	return code, nil
+}
+
+// Retry - будет ли еще попытка доставки после неудачной попытки attempt по причине code
+func (p FailurePolicy) Retry(code string, attempt int) bool {
+	return reasonRules[code].retry && attempt < p.MaxAttempts
+}
+
+// recordFailure сохраняет причину неудачной доставки в транзакции перехода в failed_delivery.
+// Если попыток больше не будет, просит order-service компенсировать заказ клиенту. Событие отправляется
+// до фиксации транзакции: повторный запрос отправит его еще раз, order-service учитывает его один раз.
+// checkContacts - курьер должен был попытаться связаться с клиентом, если клиент недоступен
+func (d *Dispatcher) recordFailure(ctx context.Context, tx *sql.Tx, orderID uuid.UUID, code, note string, checkContacts bool) error {
+	var (
+		attempt       int
+		correlationID string
+	)
+	if err := tx.QueryRowContext(ctx, `
+		SELECT attempts, correlation_id FROM deliveries WHERE order_UUID = $1`,
+		orderID).Scan(&attempt, &correlationID); err != nil {
+		return err
+	}
+
+	if checkContacts && reasonRules[code].needsContact {
+		var contacts int
+		if err := tx.QueryRowContext(ctx, `
+			SELECT count(*) FROM delivery_contact_attempts
+			WHERE order_UUID = $1 AND attempt = $2 AND result <> 'answered'`,
+			orderID, attempt).Scan(&contacts); err != nil {
+			return err
+		}
+		if contacts < d.failures.MinContacts {
+			return fmt.Errorf("%w: попыток %d из %d", errNoContact, contacts, d.failures.MinContacts)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE deliveries SET failure_code = $2, failure_reason = $3, failed_at = now()
+		WHERE order_UUID = $1`,
+		orderID, code, note); err != nil {
+		return err
+	}
+
+	if d.failures.Retry(code, attempt) {
+		return nil
+	}
+	c := events.CompensationRequested{
+		OrderID:  orderID.String(),
+		Kind:     reasonRules[code].compensation,
+		Reason:   code,
+		Attempts: attempt,
+		At:       time.Now(),
+	}
+	if err := publish(d.events, d.enc, events.TypeCompensationRequested, correlationID, c.OrderID, c); err != nil {
+		return fmt.Errorf("ошибка отправки запроса компенсации заказа %s в брокер: %w", orderID, err)
+	}
+	log.Printf("Заказ %s не доставлен после %d попыток (%s), запрошена компенсация: %s", orderID, attempt, code, c.Kind)
+	return nil
+}
+
+// Return отмечает, что заказ, который не удалось доставить, вернулся в ресторан. Если по причине неудачи
+// и числу попыток доставку можно повторить, заказ через RetryDelay снова встает в очередь собственных курьеров.
+// courierID - курьер, который привез заказ обратно; nil - возврат отмечает поддержка или внешняя служба
+func (d *Dispatcher) Return(ctx context.Context, orderID uuid.UUID, courierID *uuid.UUID) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		status, correlationID string
+		code                  sql.NullString
+		attempt               int
+		courier               *uuid.UUID
+	)
+	err = tx.QueryRowContext(ctx, `
+		SELECT status, correlation_id, failure_code, attempts, courier_UUID FROM deliveries
+		WHERE order_UUID = $1 AND ($2::uuid IS NULL OR courier_UUID = $2)
+		FOR UPDATE`,
+		orderID, courierID).Scan(&status, &correlationID, &code, &attempt, &courier)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errDeliveryNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if status != DeliveryFailed {
+		return fmt.Errorf("%w: %s -> %s", errDeliveryState, status, DeliveryReturned)
+	}
+
+	retry := d.failures.Retry(code.String, attempt)
+	if retry {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE deliveries SET status = 'pending', returned_at = now(), retry_at = now() + $2::float8 * interval '1 second',
+				attempts = attempts + 1, courier_UUID = NULL, assigned_at = NULL, picked_up_at = NULL,
+				batch_UUID = NULL, stop_seq = 1, eta = NULL, provider = $3, external_id = NULL
+			WHERE order_UUID = $1`,
+			orderID, d.failures.RetryDelay.Seconds(), InHouseProvider)
+	} else {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE deliveries SET status = 'returned', returned_at = now() WHERE order_UUID = $1`, orderID)
+	}
+	if err != nil {
+		return err
+	}
+	if courier != nil {
+		if err := releaseCourier(ctx, tx, *courier); err != nil {
+			return err
+		}
+	}
+	if err := publishStatus(d.statusWriter, d.enc, correlationID, orderID.String(), DeliveryReturned); err != nil {
+		return fmt.Errorf("ошибка отправки статуса заказа %s в брокер: %w", orderID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if retry {
+		log.Printf("Заказ %s вернулся в ресторан, повторная доставка через %s", orderID, d.failures.RetryDelay)
+	} else {
+		log.Printf("Заказ %s вернулся в ресторан", orderID)
+	}
+	d.Wake()
+	return nil
+}
+
+// Способы связи с клиентом и их результаты
+var (
+	contactMethods = map[string]bool{"call": true, "sms": true, "intercom": true, "doorbell": true}
+	contactResults = map[string]bool{"no_answer": true, "busy": true, "answered": true, "wrong_number": true}
+)
+
+// Contact - попытка курьера связаться с клиентом
+type Contact struct {
+	ID        int64      `json:"id"`
+	OrderID   uuid.UUID  `json:"order_id"`
+	CourierID *uuid.UUID `json:"courier_id,omitempty"`
+	// номер попытки доставки
+	Attempt int       `json:"attempt"`
+	Method  string    `json:"method"`
+	Result  string    `json:"result"`
+	Note    string    `json:"note,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// LogContact сохраняет попытку курьера связаться с клиентом по заказу, который он везет
+func LogContact(ctx context.Context, db *sql.DB, courierID uuid.UUID, c Contact) (Contact, error) {
+	if !contactMethods[c.Method] {
+		return Contact{}, fmt.Errorf("%w: %q", errContactMethod, c.Method)
+	}
+	if !contactResults[c.Result] {
+		return Contact{}, fmt.Errorf("%w: %q", errContactResult, c.Result)
+	}
+
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO delivery_contact_attempts (order_UUID, courier_UUID, attempt, method, result, note)
+		SELECT order_UUID, courier_UUID, attempts, $3, $4, $5 FROM deliveries
+		WHERE order_UUID = $1 AND courier_UUID = $2 AND status = 'picked_up'
+		RETURNING id, order_UUID, courier_UUID, attempt, method, result, note, at`,
+		c.OrderID, courierID, c.Method, c.Result, c.Note).Scan(
+		&c.ID, &c.OrderID, &c.CourierID, &c.Attempt, &c.Method, &c.Result, &c.Note, &c.At)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Contact{}, errContactNoOrder
+	}
+	return c, err
+}
+
+// Contacts возвращает попытки связаться с клиентом по заказу за все попытки доставки
+func Contacts(ctx context.Context, db *sql.DB, orderID uuid.UUID) ([]Contact, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, order_UUID, courier_UUID, attempt, method, result, note, at
+		FROM delivery_contact_attempts
+		WHERE order_UUID = $1
+		ORDER BY at`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []Contact{}
+	for rows.Next() {
+		var c Contact
+		if err := rows.Scan(&c.ID, &c.OrderID, &c.CourierID, &c.Attempt, &c.Method, &c.Result, &c.Note, &c.At); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, c)
+	}
+	return contacts, rows.Err()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/segmentio/kafka-go"
+)
+
+// сколько ждать ответа зависимости при проверке готовности
+const readinessTimeout = 2 * time.Second
+
+// Health отвечает на проверки живости и готовности сервиса
+type Health struct {
+	db         *sql.DB
+	rdb        *redis.Client
+	brokers    []string
+	dispatcher *Dispatcher
+	// диспетчер считается зависшим, если не разбирал очередь дольше stale
+	stale    time.Duration
+	stopping atomic.Bool
+}
+
+func NewHealth(db *sql.DB, rdb *redis.Client, brokers []string, dispatcher *Dispatcher, stale time.Duration) *Health {
+	return &Health{db: db, rdb: rdb, brokers: brokers, dispatcher: dispatcher, stale: stale}
+}
+
+// Stop отмечает, что сервис останавливается: проверка готовности перестает проходить,
+// чтобы балансировщик не отправлял новые запросы
+func (h *Health) Stop() {
+	h.stopping.Store(true)
+}
+
+// LiveHandler - процесс жив и отвечает на запросы
+// GET /healthz
+func (h *Health) LiveHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// ReadyHandler проверяет Postgres, Redis, Kafka и цикл диспетчера. Если что-то из них недоступно
+// или сервис останавливается, отвечает 503 с результатом каждой проверки
+// GET /readyz
+func (h *Health) ReadyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		checks := map[string]string{
+			"postgres":   errText(h.db.PingContext(ctx)),
+			"redis":      errText(h.rdb.Ping(ctx).Err()),
+			"kafka":      errText(h.pingKafka(ctx)),
+			"dispatcher": h.dispatcherState(),
+		}
+		status, code := "ready", http.StatusOK
+		for _, result := range checks {
+			if result != "ok" {
+				status, code = "not_ready", http.StatusServiceUnavailable
+			}
+		}
+		if h.stopping.Load() {
+			status, code = "stopping", http.StatusServiceUnavailable
+		}
+
+		last := h.dispatcher.LastRun()
+		resp := struct {
+			Status         string            `json:"status"`
+			Checks         map[string]string `json:"checks"`
+			LastDispatchAt *time.Time        `json:"last_dispatch_at,omitempty"`
+		}{Status: status, Checks: checks}
+		if !last.IsZero() {
+			resp.LastDispatchAt = &last
+		}
+		writeJSON(w, code, resp)
+	}
+}
+
+// pingKafka проверяет, что доступен хотя бы один брокер
+func (h *Health) pingKafka(ctx context.Context) error {
+	var err error
+	for _, broker := range h.brokers {
+		var conn *kafka.Conn
+		if conn, err = kafka.DialContext(ctx, "tcp", broker); err == nil {
+			return conn.Close()
+		}
+	}
+	return err
+}
+
+// dispatcherState - разбирает ли диспетчер очередь
+func (h *Health) dispatcherState() string {
+	last := h.dispatcher.LastRun()
+	switch {
+	case last.IsZero():
+		return "диспетчер еще не запущен"
+	case time.Since(last) > h.stale:
+		return "диспетчер не разбирал очередь с " + last.Format(time.RFC3339)
+	default:
+		return "ok"
+	}
+}
+
+func errText(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// заголовок с подписью вебхука: hex(HMAC-SHA256(secret, тело запроса))
+const signatureHeader = "X-Signature"
+
+// максимальный размер тела вебхука
+const maxWebhookSize = 1 << 20
+
+// HTTPProvider - внешняя служба доставки с JSON API:
+//   - POST /quotes - условия доставки, 200 {"fee", "eta"} или 422, если служба заказ не повезет;
+//   - POST /deliveries - передача заказа, 201 {"id", "eta"} или 422. Заголовок Idempotency-Key - идентификатор заказа;
+//   - DELETE /deliveries/{id} - отзыв заказа.
+//
+// Служба сообщает о статусах доставки вебхуком {"id", "status", "reason", "eta"}, подписанным секретом
+type HTTPProvider struct {
+	name    string
+	baseURL string
+	apiKey  string
+	secret  []byte
+	client  *http.Client
+}
+
+func NewHTTPProvider(name, baseURL, apiKey, secret string, timeout time.Duration) *HTTPProvider {
+	return &HTTPProvider{
+		name:    name,
+		baseURL: baseURL,
+		apiKey:  apiKey,
+		secret:  []byte(secret),
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// providerRequest - заказ в запросах к внешней службе
+type providerRequest struct {
+	OrderID        string   `json:"order_id"`
+	Items          []string `json:"items"`
+	Address        string   `json:"address"`
+	AddressComment string   `json:"address_comment,omitempty"`
+	Lat            float64  `json:"lat"`
+	Lon            float64  `json:"lon"`
+	PickupLat      float64  `json:"pickup_lat"`
+	PickupLon      float64  `json:"pickup_lon"`
+	Zone           string   `json:"zone,omitempty"`
+}
+
+type providerResponse struct {
+	ID    string     `json:"id"`
+	Fee   int64      `json:"fee"`
+	ETA   *time.Time `json:"eta"`
+	Error string     `json:"error"`
+}
+
+type providerWebhook struct {
+	ID     string     `json:"id"`
+	Status string     `json:"status"`
+	Reason string     `json:"reason"`
+	ETA    *time.Time `json:"eta"`
+}
+
+// статусы внешней службы и соответствующие статусы доставки
+var providerStatuses = map[string]string{
+	"picked_up": DeliveryPickedUp,
+	"delivered": DeliveryDelivered,
+	"failed":    DeliveryFailed,
+	"returned":  DeliveryReturned,
+	"cancelled": ProviderCancelled,
+}
+
+func (p *HTTPProvider) Name() string { return p.name }
+
+func (p *HTTPProvider) Quote(ctx context.Context, o ProviderOrder) (ProviderQuote, error) {
+	resp, err := p.call(ctx, http.MethodPost, "/quotes", o, http.StatusOK)
+	if err != nil {
+		return ProviderQuote{}, err
+	}
+	return ProviderQuote{Fee: resp.Fee, ArriveAt: resp.ETA}, nil
+}
+
+func (p *HTTPProvider) Create(ctx context.Context, o ProviderOrder) (ProviderDelivery, error) {
+	resp, err := p.call(ctx, http.MethodPost, "/deliveries", o, http.StatusCreated)
+	if err != nil {
+		return ProviderDelivery{}, err
+	}
+	if resp.ID == "" {
+		return ProviderDelivery{}, fmt.Errorf("служба доставки %s не вернула идентификатор доставки", p.name)
+	}
+	return ProviderDelivery{ExternalID: resp.ID, ArriveAt: resp.ETA}, nil
+}
+
+func (p *HTTPProvider) Cancel(ctx context.Context, externalID string) error {
+	_, err := p.call(ctx, http.MethodDelete, "/deliveries/"+url.PathEscape(externalID), ProviderOrder{}, http.StatusNoContent)
+	return err
+}
+
+func (p *HTTPProvider) Webhook(r *http.Request) (ProviderUpdate, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookSize))
+	if err != nil {
+		return ProviderUpdate{}, err
+	}
+	if !hmac.Equal([]byte(r.Header.Get(signatureHeader)), []byte(sign(p.secret, body))) {
+		return ProviderUpdate{}, errBadSignature
+	}
+	var hook providerWebhook
+	if err := json.Unmarshal(body, &hook); err != nil {
+		return ProviderUpdate{}, err
+	}
+	status, ok := providerStatuses[hook.Status]
+	if !ok {
+		return ProviderUpdate{}, fmt.Errorf("неизвестный статус доставки %q", hook.Status)
+	}
+	return ProviderUpdate{ExternalID: hook.ID, Status: status, Reason: hook.Reason, ArriveAt: hook.ETA}, nil
+}
+
+// call отправляет запрос службе и ждет статус want. 422 означает, что служба отказалась от заказа
+func (p *HTTPProvider) call(ctx context.Context, method, path string, o ProviderOrder, want int) (providerResponse, error) {
+	var body io.Reader
+	if method != http.MethodDelete {
+		req := providerRequest{
+			OrderID:        o.OrderID.String(),
+			Items:          o.Order.Items,
+			Address:        o.Order.Address,
+			AddressComment: o.Order.AddressComment,
+			PickupLat:      o.Pickup.Lat,
+			PickupLon:      o.Pickup.Lon,
+			Zone:           o.Order.Zone,
+		}
+		if o.Dest != nil {
+			req.Lat, req.Lon = o.Dest.Lat, o.Dest.Lon
+		}
+		data, err := json.Marshal(req)
+		if err != nil {
+			return providerResponse{}, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, body)
+	if err != nil {
+		return providerResponse{}, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		r.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+	if method == http.MethodPost {
+		r.Header.Set("Idempotency-Key", o.OrderID.String())
+	}
+	resp, err := p.client.Do(r)
+	if err != nil {
+		return providerResponse{}, fmt.Errorf("служба доставки %s недоступна: %w", p.name, err)
+	}
+	defer resp.Body.Close()
+
+	var out providerResponse
+	if resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(io.LimitReader(resp.Body, maxWebhookSize)).Decode(&out); err != nil && err != io.EOF {
+			return providerResponse{}, fmt.Errorf("некорректный ответ службы доставки %s: %w", p.name, err)
+		}
+	}
+	switch {
+	case resp.StatusCode == want:
+		return out, nil
+	case resp.StatusCode == http.StatusNotFound && method == http.MethodDelete:
+		// доставка уже отозвана или завершена
+		return out, nil
+	case resp.StatusCode == http.StatusUnprocessableEntity:
+		return providerResponse{}, fmt.Errorf("%w: %s", errProviderRejected, out.Error)
+	default:
+		return providerResponse{}, fmt.Errorf("служба доставки %s ответила %d: %s", p.name, resp.StatusCode, out.Error)
+	}
+}
+
+// sign - подпись тела вебхука
+func sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	// GEO-множество последних координат курьеров
+	locationsKey = "courier_locations"
+	// хеш курьер -> время последней координаты в секундах Unix, GEO-множество время не хранит
+	locationTimesKey = "courier_location_at"
+)
+
+var errInvalidLocation = errors.New("некорректные координаты")
+
+// Location - координаты курьера в момент времени
+type Location struct {
+	Lat float64   `json:"lat"`
+	Lon float64   `json:"lon"`
+	At  time.Time `json:"at"`
+	// точность GPS в метрах, если телефон ее сообщает
+	Accuracy *float64 `json:"accuracy,omitempty"`
+}
+
+// Tracking - где сейчас заказ: статус доставки, курьер и его последние координаты
+type Tracking struct {
+	OrderID     uuid.UUID  `json:"order_id"`
+	Status      string     `json:"status"`
+	CourierID   *uuid.UUID `json:"courier_id,omitempty"`
+	CourierName string     `json:"courier_name,omitempty"`
+	Location    *Location  `json:"location,omitempty"`
+}
+
+// Active - курьер едет за заказом или уже везет его
+func (t Tracking) Active() bool {
+	return t.Status == DeliveryAssigned || t.Status == DeliveryPickedUp
+}
+
+// Tracker принимает GPS-пинги курьеров. Последняя координата хранится в Redis GEO
+// и рассылается подписчикам через канал Redis, история - в Postgres
+type Tracker struct {
+	db  *sql.DB
+	rdb *redis.Client
+}
+
+func NewTracker(db *sql.DB, rdb *redis.Client) *Tracker {
+	return &Tracker{db: db, rdb: rdb}
+}
+
+// LocationChannel - канал Redis, в который публикуются координаты курьера
+func LocationChannel(courierID uuid.UUID) string {
+	return "courier_location:" + courierID.String()
+}
+
+// Record сохраняет координату курьера. Запоздавший пинг попадает в историю, но не перезаписывает
+// более свежую последнюю координату
+func (t *Tracker) Record(ctx context.Context, courierID uuid.UUID, loc Location) error {
+	if loc.Lat < -90 || loc.Lat > 90 || loc.Lon < -180 || loc.Lon > 180 {
+		return errInvalidLocation
+	}
+	if loc.At.IsZero() || loc.At.After(time.Now()) {
+		loc.At = time.Now()
+	}
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO courier_locations (courier_UUID, lat, lon, accuracy, recorded_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		courierID, loc.Lat, loc.Lon, loc.Accuracy, loc.At); err != nil {
+		return fmt.Errorf("не удалось сохранить координаты: %w", err)
+	}
+	// координаты в таблице курьеров использует диспетчер
+	res, err := tx.ExecContext(ctx, `
+		UPDATE couriers SET lat = $2, lon = $3, location_at = $4
+		WHERE courier_UUID = $1 AND (location_at IS NULL OR location_at < $4)`,
+		courierID, loc.Lat, loc.Lon, loc.At)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	id := courierID.String()
+	pipe := t.rdb.TxPipeline()
+	pipe.GeoAdd(ctx, locationsKey, &redis.GeoLocation{Name: id, Longitude: loc.Lon, Latitude: loc.Lat})
+	pipe.HSet(ctx, locationTimesKey, id, loc.At.Unix())
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("не удалось сохранить координаты в Redis: %w", err)
+	}
+
+	data, err := json.Marshal(loc)
+	if err != nil {
+		return err
+	}
+	return t.rdb.Publish(ctx, LocationChannel(courierID), data).Err()
+}
+
+// Latest возвращает последнюю координату курьера из Redis
+func (t *Tracker) Latest(ctx context.Context, courierID uuid.UUID) (*Location, error) {
+	id := courierID.String()
+	positions, err := t.rdb.GeoPos(ctx, locationsKey, id).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(positions) == 0 || positions[0] == nil {
+		return nil, nil
+	}
+	loc := &Location{Lat: positions[0].Latitude, Lon: positions[0].Longitude}
+
+	at, err := t.rdb.HGet(ctx, locationTimesKey, id).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	if sec, err := strconv.ParseInt(at, 10, 64); err == nil {
+		loc.At = time.Unix(sec, 0)
+	}
+	return loc, nil
+}
+
+// Order возвращает, где сейчас заказ
+func (t *Tracker) Order(ctx context.Context, orderID uuid.UUID) (Tracking, error) {
+	tr := Tracking{OrderID: orderID}
+	var name sql.NullString
+	err := t.db.QueryRowContext(ctx, `
+		SELECT d.status, d.courier_UUID, c.name FROM deliveries d
+		LEFT JOIN couriers c ON c.courier_UUID = d.courier_UUID
+		WHERE d.order_UUID = $1`, orderID).Scan(&tr.Status, &tr.CourierID, &name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Tracking{}, errDeliveryNotFound
+	}
+	if err != nil {
+		return Tracking{}, err
+	}
+	tr.CourierName = name.String
+
+	// координаты курьера видны, только пока он занят этим заказом
+	if tr.CourierID != nil && tr.Active() {
+		if tr.Location, err = t.Latest(ctx, *tr.CourierID); err != nil {
+			return Tracking{}, err
+		}
+	}
+	return tr, nil
+}
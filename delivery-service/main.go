@@ -3,8 +3,9 @@ package main
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
@@ -12,16 +13,17 @@ import (
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 	"github.com/segmentio/kafka-go"
+
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/events/eta"
+	"github.com/sandrinasava/cafe-services/events/retry"
 )
 
-type Order struct {
-	ID       string   `json:"id"`
-	Customer string   `json:"customer"`
-	Items    []string `json:"items"`
-	Status   string   `json:"status"`
-}
+// producer - имя сервиса в конвертах событий
+const producer = "delivery-service"
 
 func main() {
 	//получаю конфиги
@@ -29,9 +31,27 @@ func main() {
 	if err != nil {
 		log.Fatalf("Неудачная загрузка конфигураций: %v", err)
 	}
+	// сверяю схемы событий с реестром, несовместимые изменения не должны попасть в Kafka
+	if err := events.CheckSchemas(); err != nil {
+		log.Fatalf("Несовместимая схема событий: %v", err)
+	}
+	enc, err := events.ParseEncoding(cfg.Events.Encoding)
+	if err != nil {
+		log.Fatalf("Неудачная загрузка конфигураций: %v", err)
+	}
+	strategy, err := ParseStrategy(cfg.Dispatch.Strategy)
+	if err != nil {
+		log.Fatalf("Неудачная загрузка конфигураций: %v", err)
+	}
+	proofs, err := NewProofStore(cfg)
+	if err != nil {
+		log.Fatalf("Неудачная загрузка конфигураций: %v", err)
+	}
+
 	kafkaBroker := cfg.Kafka.Broker
 	DB_DSN := cfg.DB.DSN
 	topic := cfg.Kafka.Topic
+	topicStatus := cfg.Kafka.TopicStatus
 	redisHost := cfg.Redis.Host
 	redisPassword := cfg.Redis.Password
 
@@ -49,82 +69,168 @@ func main() {
 	}
 	defer db.Close()
 
-	//создаю консьюмера
-	kReader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: strings.Split(kafkaBroker, ","),
-		GroupID: "delivery-group",
-		Topic:   topic,
+	//создаю продюсера статусов заказов
+	statusWriter := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:  strings.Split(kafkaBroker, ","),
+		Topic:    topicStatus,
+		Balancer: &kafka.Hash{},
 	})
+	defer statusWriter.Close()
+
+	//создаю продюсера событий доставки
+	deliveryWriter := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:  strings.Split(kafkaBroker, ","),
+		Topic:    cfg.Kafka.TopicDelivery,
+		Balancer: &kafka.Hash{},
+	})
+	defer deliveryWriter.Close()
+
+	// диспетчер назначает курьеров на готовые заказы
+	failures := FailurePolicy{MaxAttempts: cfg.Failure.MaxAttempts, RetryDelay: cfg.Failure.RetryDelay, MinContacts: cfg.Failure.MinContacts}
+	batching := Batching{MaxOrders: cfg.Batch.MaxOrders, Window: cfg.Batch.Window, MaxAngle: cfg.Batch.MaxAngle}
+	restaurant := eta.Point{Lat: cfg.Restaurant.Lat, Lon: cfg.Restaurant.Lon}
+	dispatcher := NewDispatcher(db, deliveryWriter, statusWriter, enc, strategy, restaurant, cfg.Dispatch.Interval, cfg.ETA, cfg.Dispatch.Trip, batching, failures)
+
+	// службы доставки: собственные курьеры и тестовая внешняя служба
+	streamsCtx, streamsCancel := context.WithCancel(context.Background())
+	defer streamsCancel()
+	deliveryProviders := []DeliveryProvider{NewInHouse(dispatcher)}
+	if cfg.MockProvider.Enabled {
+		mock := NewMockProvider(streamsCtx, cfg.MockProvider.WebhookURL, cfg.MockProvider.Secret,
+			cfg.MockProvider.Radius, cfg.MockProvider.Step, cfg.ETA)
+		http.HandleFunc("POST /mock-provider/quotes", mock.QuoteHandler())
+		http.HandleFunc("POST /mock-provider/deliveries", mock.CreateHandler())
+		http.HandleFunc("DELETE /mock-provider/deliveries/{id}", mock.CancelHandler())
+		deliveryProviders = append(deliveryProviders,
+			NewHTTPProvider("mock", cfg.MockProvider.URL, "", cfg.MockProvider.Secret, cfg.Providers.Timeout))
+	}
+	providers, err := NewProviders(dispatcher, deliveryProviders, cfg.Providers.Zones, cfg.Providers.Default, cfg.Providers.Fallback)
+	if err != nil {
+		log.Fatalf("Неудачная загрузка конфигураций: %v", err)
+	}
+
+	//создаю консьюмера, неудачно обработанные сообщения уходят в топики повторов и DLQ
+	consumer := retry.NewConsumer(retry.Config{
+		Brokers:        strings.Split(kafkaBroker, ","),
+		Topic:          topic,
+		GroupID:        "delivery-group",
+		Delays:         cfg.Retry.Delays,
+		MaxAttempts:    cfg.Retry.MaxAttempts,
+		CommitInterval: cfg.Kafka.CommitInterval,
+	}, deliverOrder(providers))
+
+	// регистрация маршрутов управления курьерами
+	secret := cfg.JWT.SecretKey
+	http.HandleFunc("GET /couriers", dispatcherOnly(secret, CouriersHandler(db)))
+	http.HandleFunc("POST /couriers", dispatcherOnly(secret, CreateCourierHandler(db)))
+	http.HandleFunc("POST /couriers/{id}/shifts", dispatcherOnly(secret, ShiftHandler(db, dispatcher)))
+	http.HandleFunc("POST /couriers/{id}/status", dispatcherOnly(secret, CourierStatusHandler(db, dispatcher)))
+	http.HandleFunc("POST /couriers/{id}/deliveries/{order}/{action}", dispatcherOnly(secret, DeliveryActionHandler(dispatcher)))
+
+	// проверки живости и готовности: диспетчер считается зависшим, если пропустил три интервала
+	health := NewHealth(db, rdb, strings.Split(kafkaBroker, ","), dispatcher, 3*cfg.Dispatch.Interval)
+	http.HandleFunc("GET /healthz", health.LiveHandler())
+	http.HandleFunc("GET /readyz", health.ReadyHandler())
+
+	// API поддержки: активные доставки, ручное переназначение и принудительное завершение
+	http.HandleFunc("GET /deliveries", supportOnly(secret, ActiveDeliveriesHandler(db)))
+	http.HandleFunc("POST /deliveries/{order}/reassign", supportOnly(secret, ReassignHandler(providers)))
+	http.HandleFunc("POST /deliveries/{order}/complete", supportOnly(secret, ResolveHandler(providers, DeliveryDelivered)))
+	http.HandleFunc("POST /deliveries/{order}/fail", supportOnly(secret, ResolveHandler(providers, DeliveryFailed)))
+	http.HandleFunc("POST /deliveries/{order}/return", supportOnly(secret, SupportReturnHandler(dispatcher)))
+	http.HandleFunc("GET /deliveries/{order}/contacts", supportOnly(secret, ContactsHandler(db)))
+
+	// статусы доставок внешних служб, вебхуки подписаны секретом службы
+	http.HandleFunc("POST /providers/{provider}/webhook", ProviderWebhookHandler(providers, dispatcher))
+
+	// отслеживание курьеров: GPS-пинги и координаты курьера по заказу для order-service
+	tracker := NewTracker(db, rdb)
+	http.HandleFunc("GET /deliveries/{order}/tracking", TrackingHandler(tracker))
+	http.HandleFunc("GET /deliveries/{order}/tracking/stream", TrackingStreamHandler(streamsCtx, tracker))
+
+	// регистрация маршрутов мобильного API курьеров
+	http.HandleFunc("POST /courier/location", courierOnly(secret, db, LocationHandler(tracker)))
+	http.HandleFunc("GET /courier/deliveries", courierOnly(secret, db, MyDeliveriesHandler(db)))
+	http.HandleFunc("POST /courier/deliveries/{order}/pickup", courierOnly(secret, db, PickupHandler(dispatcher)))
+	http.HandleFunc("POST /courier/deliveries/{order}/deliver", courierOnly(secret, db, ProofHandler(dispatcher, proofs, cfg.Proof.MaxSize)))
+	http.HandleFunc("POST /courier/deliveries/{order}/fail", courierOnly(secret, db, FailHandler(dispatcher)))
+	http.HandleFunc("POST /courier/deliveries/{order}/contacts", courierOnly(secret, db, ContactHandler(db)))
+	http.HandleFunc("POST /courier/deliveries/{order}/return", courierOnly(secret, db, ReturnHandler(dispatcher)))
+
+	srv := &http.Server{
+		Addr: fmt.Sprintf(":%s", cfg.Server.Port),
+		// курьеры загружают фото подтверждения через мобильную сеть
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+	srv.RegisterOnShutdown(streamsCancel)
+	go func() {
+		log.Printf("Сервис доставки слушает на порту %s", cfg.Server.Port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("HTTP server failed: %v", err)
+		}
+	}()
 
 	//создаю канал, читающий сигналы ос
 	stop := make(chan os.Signal, 1)
 	//подписка на оповещение от ос о сигналах SIGINT(нажатие ctrl+c) и SIGTERM(завершение процесса)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
-	//создаю контекст с отменой по сигналу
+	//создаю контекст для остановки чтения новых сообщений
 	readctx, readCancel := context.WithCancel(context.Background())
+	defer readCancel()
+
+	consumed := make(chan struct{})
 	go func() {
-		<-stop
-		readCancel()
+		consumer.Run(readctx)
+		close(consumed)
 	}()
-
-	// запускаю горутину
+	dispatched := make(chan struct{})
 	go func() {
-		for {
-			// читаю из брокера если ctx не отменен
-			m, err := kReader.ReadMessage(readctx)
-			if err != nil {
-				if readctx.Err() != nil {
-					break
-				}
-				log.Printf("неудачное чтение из брокера: %v", err)
-				time.Sleep(1 * time.Second) //задержка при повторной попытке чтения
-				continue
-			}
-			// достаю данные из сообщения и десериализую
-			var order Order
-			if err := json.Unmarshal(m.Value, &order); err != nil {
-				log.Printf("неудачная сериализация сообщения: %v", err)
-				continue
-			}
-
-			// Имитация доставки заказа
-			time.Sleep(3 * time.Second)
-
-			order.Status = "delivered"
-
-			// Обновление статуса заказа в Redis и PostgreSQL
-			err = rdb.Set(context.Background(), order.ID, string(m.Value), 1*time.Hour).Err()
-			if err != nil {
-				log.Printf("Ошибка обновления статуса заказа в Redis: %v", err)
-			}
-
-			_, err = db.ExecContext(context.Background(), "UPDATE orders SET status=$1 WHERE id=$2", order.Status, order.ID)
-			if err != nil {
-				log.Printf("Ошибка обновления статуса заказа в PostgreSQL: %v", err)
-				continue
-			}
-
-			log.Printf("Заказ %s доставлен", order.ID)
-		}
+		dispatcher.Run(readctx)
+		close(dispatched)
 	}()
 
 	// Graceful Shutdown
 
+	// ожидание сигнала
+	<-stop
+
+	log.Println("Остановка Delivery-service")
+	health.Stop()
+
 	// Контекст с таймаутом для корректного завершения всех операций
 	shutdownctx, shutdowCancel := context.WithTimeout(context.Background(), 10*time.Second)
-
 	defer shutdowCancel()
-	// ожидание сигнала
-	<-stop
 
-	log.Println("Остановка Kitchen-service")
+	// перестаю читать новые заказы и назначать курьеров
+	readCancel()
+	for _, done := range []chan struct{}{consumed, dispatched} {
+		select {
+		case <-done:
+		case <-shutdownctx.Done():
+			log.Println("Не удалось дождаться завершения обработки заказов")
+		}
+	}
+
+	if err := srv.Shutdown(shutdownctx); err != nil {
+		log.Printf("Не удалось корректно остановить сервер: %v", err)
+	}
 
-	// закрытие консьюмера
-	if err := kReader.Close(); err != nil {
+	// закрытие консьюмера, накопленные смещения отправляются в брокер при закрытии
+	if err := consumer.Close(); err != nil {
 		log.Printf("Не удалось закрыть консьюмера Kafka: %v", err)
 	}
 
+	// закрытие продюсеров
+	if err := statusWriter.Close(); err != nil {
+		log.Printf("Не удалось закрыть продюсера Kafka: %v", err)
+	}
+	if err := deliveryWriter.Close(); err != nil {
+		log.Printf("Не удалось закрыть продюсера событий доставки Kafka: %v", err)
+	}
+
 	// закрытие клиента Redis
 	if err := rdb.Close(); err != nil {
 		log.Printf("Не удалось закрыть клиент Redis: %v", err)
@@ -134,8 +240,54 @@ func main() {
 	if err := db.Close(); err != nil {
 		log.Printf("Не удалось закрыть соединение с базой данных: %v", err)
 	}
-	// Ожидание завершения всех операций
-	<-shutdownctx.Done()
+	log.Println("Delivery-service остановлен")
+}
+
+// deliverOrder передает готовый заказ службе доставки его зоны. Собственным курьерам заказ назначает Dispatcher,
+// статусы доставки order-service получает, когда курьер забирает и передает заказ
+func deliverOrder(providers *Providers) retry.Handler {
+	return func(ctx context.Context, m kafka.Message) error {
+		// достаю данные из сообщения и десериализую
+		env, err := events.Decode(m)
+		if err != nil {
+			return retry.Permanent(err)
+		}
+		var order events.Order
+		if err := env.DecodePayload(&order); err != nil {
+			return retry.Permanent(fmt.Errorf("неудачная сериализация сообщения: %w", err))
+		}
+		orderID, err := uuid.Parse(order.ID)
+		if err != nil {
+			return retry.Permanent(fmt.Errorf("некорректный идентификатор заказа %q: %w", order.ID, err))
+		}
+
+		// самовывоз клиент забирает сам, курьер ему не нужен
+		if order.Type == events.OrderTypePickup {
+			log.Printf("Заказ %s - самовывоз, доставка не нужна", order.ID)
+			return nil
+		}
+		if err := providers.Deliver(ctx, orderID, order, env.CorrelationID); err != nil {
+			return err
+		}
+		log.Printf("Заказ %s ждет курьера", order.ID)
+		return nil
+	}
+}
+
+func publish(w *kafka.Writer, enc events.Encoding, eventType, correlationID, key string, payload interface{}) error {
+	env, err := events.New(eventType, producer, correlationID, payload)
+	if err != nil {
+		return err
+	}
+	m, err := env.Message(key, enc)
+	if err != nil {
+		return err
+	}
+	return w.WriteMessages(context.Background(), m)
+}
 
-	log.Println("Kitchen-service остановлен")
+// publishStatus сообщает order-service о смене статуса заказа
+func publishStatus(w *kafka.Writer, enc events.Encoding, correlationID, orderID, status string) error {
+	payload := events.StatusChanged{OrderID: orderID, Status: status, At: time.Now()}
+	return publish(w, enc, events.TypeStatusChanged, correlationID, orderID, payload)
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sandrinasava/cafe-services/events/eta"
+)
+
+// MockProvider - имитация внешней службы доставки с API HTTPProvider для локальной проверки.
+// Служба везет заказы не дальше radius метров от ресторана, а приняв заказ, через step сообщает
+// вебхуком, что курьер забрал заказ, и еще через step - что заказ доставлен
+type MockProvider struct {
+	ctx        context.Context
+	webhookURL string
+	secret     []byte
+	radius     float64
+	step       time.Duration
+	model      eta.Model
+	client     *http.Client
+
+	mu sync.Mutex
+	// доставки по идентификатору и идентификаторы по ключу идемпотентности
+	deliveries map[string]context.CancelFunc
+	byKey      map[string]string
+}
+
+// NewMockProvider создает имитацию службы. Доставки прекращаются при отмене ctx
+func NewMockProvider(ctx context.Context, webhookURL, secret string, radius float64, step time.Duration, model eta.Model) *MockProvider {
+	return &MockProvider{
+		ctx:        ctx,
+		webhookURL: webhookURL,
+		secret:     []byte(secret),
+		radius:     radius,
+		step:       step,
+		model:      model,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		deliveries: make(map[string]context.CancelFunc),
+		byKey:      make(map[string]string),
+	}
+}
+
+// QuoteHandler - условия доставки: 50 ₽ и 10 ₽ за километр от ресторана
+// POST /quotes
+func (m *MockProvider) QuoteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, arrive, ok := m.accept(w, r)
+		if !ok {
+			return
+		}
+		distance := eta.Distance(eta.Point{Lat: req.PickupLat, Lon: req.PickupLon}, eta.Point{Lat: req.Lat, Lon: req.Lon})
+		writeJSON(w, http.StatusOK, providerResponse{Fee: 5000 + int64(distance), ETA: &arrive})
+	}
+}
+
+// CreateHandler принимает заказ и запускает имитацию доставки
+// POST /deliveries
+func (m *MockProvider) CreateHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, arrive, ok := m.accept(w, r)
+		if !ok {
+			return
+		}
+		key := r.Header.Get("Idempotency-Key")
+
+		m.mu.Lock()
+		id, exists := m.byKey[key]
+		if !exists || key == "" {
+			id = uuid.NewString()
+			ctx, cancel := context.WithCancel(m.ctx)
+			m.deliveries[id] = cancel
+			if key != "" {
+				m.byKey[key] = id
+			}
+			go m.run(ctx, id, arrive)
+		}
+		m.mu.Unlock()
+		writeJSON(w, http.StatusCreated, providerResponse{ID: id, ETA: &arrive})
+	}
+}
+
+// CancelHandler отзывает заказ
+// DELETE /deliveries/{id}
+func (m *MockProvider) CancelHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		cancel, ok := m.deliveries[r.PathValue("id")]
+		delete(m.deliveries, r.PathValue("id"))
+		m.mu.Unlock()
+		if !ok {
+			writeJSON(w, http.StatusNotFound, providerResponse{Error: "доставка не найдена"})
+			return
+		}
+		cancel()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// accept разбирает заказ и отвечает 422, если адрес дальше radius
+func (m *MockProvider) accept(w http.ResponseWriter, r *http.Request) (providerRequest, time.Time, bool) {
+	var req providerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, providerResponse{Error: err.Error()})
+		return req, time.Time{}, false
+	}
+	pickup, dest := eta.Point{Lat: req.PickupLat, Lon: req.PickupLon}, eta.Point{Lat: req.Lat, Lon: req.Lon}
+	if req.Lat == 0 && req.Lon == 0 || eta.Distance(pickup, dest) > m.radius {
+		writeJSON(w, http.StatusUnprocessableEntity, providerResponse{Error: "адрес вне зоны обслуживания"})
+		return req, time.Time{}, false
+	}
+	now := time.Now()
+	return req, now.Add(m.step + m.model.Travel(pickup, dest, now) + m.model.Handover), true
+}
+
+// run имитирует доставку: курьер забирает заказ и через step передает его клиенту
+func (m *MockProvider) run(ctx context.Context, id string, arrive time.Time) {
+	defer func() {
+		m.mu.Lock()
+		delete(m.deliveries, id)
+		m.mu.Unlock()
+	}()
+	for _, status := range []string{"picked_up", "delivered"} {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(m.step):
+		}
+		m.notify(ctx, providerWebhook{ID: id, Status: status, ETA: &arrive})
+	}
+}
+
+// notify отправляет подписанный вебхук о статусе доставки
+func (m *MockProvider) notify(ctx context.Context, hook providerWebhook) {
+	body, err := json.Marshal(hook)
+	if err != nil {
+		return
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, m.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Ошибка вебхука тестовой службы доставки: %v", err)
+		return
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(signatureHeader, sign(m.secret, body))
+	resp, err := m.client.Do(r)
+	if err != nil {
+		log.Printf("Ошибка вебхука тестовой службы доставки: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Вебхук тестовой службы доставки о доставке %s (%s) вернул %d", hook.ID, hook.Status, resp.StatusCode)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// ProofStore хранит файлы подтверждения доставки: фото заказа у двери и подпись клиента
+type ProofStore interface {
+	// Save сохраняет файл под ключом key, повторное сохранение перезаписывает файл
+	Save(ctx context.Context, key, contentType string, r io.Reader, size int64) error
+}
+
+// NewProofStore создает хранилище подтверждений по конфигурации: local - каталог на диске,
+// s3 - бакет S3-совместимого хранилища
+func NewProofStore(cfg *Config) (ProofStore, error) {
+	switch cfg.Proof.Storage {
+	case "local":
+		return &LocalProofStore{dir: cfg.Proof.Dir}, nil
+	case "s3":
+		return NewS3ProofStore(cfg.S3.Endpoint, cfg.S3.AccessKey, cfg.S3.SecretKey, cfg.S3.Bucket, cfg.S3.UseSSL)
+	default:
+		return nil, fmt.Errorf("неизвестное хранилище подтверждений %q: ожидается local или s3", cfg.Proof.Storage)
+	}
+}
+
+// LocalProofStore хранит подтверждения в каталоге на диске
+type LocalProofStore struct {
+	dir string
+}
+
+func (s *LocalProofStore) Save(ctx context.Context, key, contentType string, r io.Reader, size int64) error {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// пишу во временный файл, чтобы оборванная загрузка не оставила половину фото
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// S3ProofStore хранит подтверждения в бакете S3-совместимого хранилища (AWS S3, MinIO и др.)
+type S3ProofStore struct {
+	client *minio.Client
+	bucket string
+}
+
+func NewS3ProofStore(endpoint, accessKey, secretKey, bucket string, useSSL bool) (*S3ProofStore, error) {
+	if endpoint == "" || bucket == "" {
+		return nil, fmt.Errorf("для хранилища s3 нужны S3_ENDPOINT и S3_BUCKET")
+	}
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: useSSL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3ProofStore{client: client, bucket: bucket}, nil
+}
+
+func (s *S3ProofStore) Save(ctx context.Context, key, contentType string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/events/eta"
+)
+
+// InHouseProvider - имя собственной службы доставки: заказ встает в очередь диспетчера
+const InHouseProvider = "inhouse"
+
+// статус из вебхука: служба доставки отказалась от уже принятого заказа
+const ProviderCancelled = "cancelled"
+
+var (
+	errProviderRejected = errors.New("служба доставки отказалась от заказа")
+	errUnknownProvider  = errors.New("неизвестная служба доставки")
+	errNoWebhook        = errors.New("служба доставки не присылает вебхуков")
+	errBadSignature     = errors.New("неверная подпись вебхука")
+)
+
+// ProviderOrder - заказ, который передается службе доставки
+type ProviderOrder struct {
+	OrderID uuid.UUID
+	Order   events.Order
+	// где курьер забирает заказ и куда везет. У заказов до появления адресов Dest нет
+	Pickup eta.Point
+	Dest   *eta.Point
+}
+
+// ProviderQuote - условия, на которых служба доставки готова везти заказ. Fee в копейках
+type ProviderQuote struct {
+	Fee      int64
+	ArriveAt *time.Time
+}
+
+// ProviderDelivery - заказ, принятый службой доставки
+type ProviderDelivery struct {
+	// идентификатор доставки в службе, по нему приходят вебхуки
+	ExternalID string
+	ArriveAt   *time.Time
+}
+
+// ProviderUpdate - статус доставки, о котором сообщила служба: один из DeliveryPickedUp,
+// DeliveryDelivered, DeliveryFailed, DeliveryReturned или ProviderCancelled. ArriveAt - новая оценка прибытия.
+// Reason - причина неудачной доставки, код из Reason* или текст
+type ProviderUpdate struct {
+	ExternalID string
+	Status     string
+	Reason     string
+	ArriveAt   *time.Time
+}
+
+// DeliveryProvider - служба доставки: собственные курьеры или внешняя служба.
+// Отказ принять заказ возвращается ошибкой errProviderRejected
+type DeliveryProvider interface {
+	Name() string
+	// Quote спрашивает, повезет ли служба заказ, за сколько и когда он приедет
+	Quote(ctx context.Context, o ProviderOrder) (ProviderQuote, error)
+	// Create передает заказ службе. Повторная передача того же заказа не создает вторую доставку
+	Create(ctx context.Context, o ProviderOrder) (ProviderDelivery, error)
+	// Cancel отзывает заказ у службы
+	Cancel(ctx context.Context, externalID string) error
+	// Webhook проверяет и разбирает уведомление службы о статусе доставки
+	Webhook(r *http.Request) (ProviderUpdate, error)
+}
+
+// Providers выбирает службу доставки по зоне заказа. Если основная служба отказалась от заказа
+// или не ответила на запрос условий, заказ передается запасной
+type Providers struct {
+	dispatcher *Dispatcher
+	providers  map[string]DeliveryProvider
+	// основная служба для зоны, зоны не из списка везет def
+	zones    map[string]string
+	def      string
+	fallback string
+}
+
+func NewProviders(dispatcher *Dispatcher, providers []DeliveryProvider, zones map[string]string, def, fallback string) (*Providers, error) {
+	p := &Providers{
+		dispatcher: dispatcher,
+		providers:  make(map[string]DeliveryProvider, len(providers)),
+		zones:      zones,
+		def:        def,
+		fallback:   fallback,
+	}
+	for _, provider := range providers {
+		p.providers[provider.Name()] = provider
+	}
+	names := []string{def, fallback}
+	for _, name := range zones {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if _, ok := p.providers[name]; !ok {
+			return nil, fmt.Errorf("%w: %q", errUnknownProvider, name)
+		}
+	}
+	return p, nil
+}
+
+// Get возвращает службу доставки по имени
+func (p *Providers) Get(name string) (DeliveryProvider, bool) {
+	provider, ok := p.providers[name]
+	return provider, ok
+}
+
+// chain - службы, которым по очереди предлагается заказ из зоны
+func (p *Providers) chain(zone string) []DeliveryProvider {
+	primary, ok := p.zones[zone]
+	if !ok {
+		primary = p.def
+	}
+	chain := []DeliveryProvider{p.providers[primary]}
+	if p.fallback != primary {
+		chain = append(chain, p.providers[p.fallback])
+	}
+	return chain
+}
+
+// Deliver передает готовый заказ службе доставки его зоны, при отказе - запасной.
+// Если служба не ответила на передачу заказа, она могла его принять: заказ не передается запасной,
+// ошибка возвращается, и повтор обращается к той же службе с тем же Idempotency-Key.
+// Повторное сообщение о том же заказе игнорируется
+func (p *Providers) Deliver(ctx context.Context, orderID uuid.UUID, order events.Order, correlationID string) error {
+	provider, err := p.dispatcher.Provider(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if provider != "" {
+		log.Printf("Заказ %s уже передан службе доставки %s", orderID, provider)
+		return nil
+	}
+
+	o := ProviderOrder{OrderID: orderID, Order: order, Pickup: p.dispatcher.pickup}
+	if order.Lat != 0 || order.Lon != 0 {
+		o.Dest = &eta.Point{Lat: order.Lat, Lon: order.Lon}
+	}
+	var lastErr error
+	for _, provider := range p.chain(order.Zone) {
+		quote, err := provider.Quote(ctx, o)
+		if err != nil {
+			log.Printf("Служба доставки %s не повезет заказ %s: %v", provider.Name(), orderID, err)
+			lastErr = err
+			continue
+		}
+		delivery, err := provider.Create(ctx, o)
+		if errors.Is(err, errProviderRejected) {
+			log.Printf("Служба доставки %s не приняла заказ %s: %v", provider.Name(), orderID, err)
+			lastErr = err
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("не удалось передать заказ %s службе доставки %s: %w", orderID, provider.Name(), err)
+		}
+		if delivery.ArriveAt == nil {
+			delivery.ArriveAt = quote.ArriveAt
+		}
+
+		if err := p.dispatcher.Enqueue(ctx, orderID, order, correlationID, provider.Name(), delivery); err != nil {
+			// заказ не сохранился и будет передан заново - отзываю его, чтобы служба не везла его дважды
+			if cancelErr := provider.Cancel(ctx, delivery.ExternalID); cancelErr != nil {
+				log.Printf("Не удалось отозвать заказ %s у службы доставки %s: %v", orderID, provider.Name(), cancelErr)
+			}
+			return err
+		}
+		if provider.Name() != InHouseProvider {
+			log.Printf("Заказ %s передан службе доставки %s (доставка %s, стоимость %d)",
+				orderID, provider.Name(), delivery.ExternalID, quote.Fee)
+		}
+		return nil
+	}
+	return fmt.Errorf("ни одна служба доставки не приняла заказ %s: %w", orderID, lastErr)
+}
+
+// InHouse - собственные курьеры. Заказ встает в очередь диспетчера, статусы доставки приходят
+// из приложения курьера, поэтому вебхуков нет
+type InHouse struct {
+	dispatcher *Dispatcher
+}
+
+func NewInHouse(dispatcher *Dispatcher) *InHouse {
+	return &InHouse{dispatcher: dispatcher}
+}
+
+func (*InHouse) Name() string { return InHouseProvider }
+
+// Quote - собственные курьеры везут любой заказ без доплаты, оценка прибытия - дорога из ресторана
+func (h *InHouse) Quote(ctx context.Context, o ProviderOrder) (ProviderQuote, error) {
+	if o.Dest == nil {
+		return ProviderQuote{}, nil
+	}
+	now := time.Now()
+	arrive := now.Add(h.dispatcher.model.Travel(o.Pickup, *o.Dest, now) + h.dispatcher.model.Handover)
+	return ProviderQuote{ArriveAt: &arrive}, nil
+}
+
+// Create принимает заказ сразу: доставку сохраняет Enqueue, курьера назначит диспетчер
+func (*InHouse) Create(ctx context.Context, o ProviderOrder) (ProviderDelivery, error) {
+	return ProviderDelivery{ExternalID: o.OrderID.String()}, nil
+}
+
+// Cancel убирает заказ из очереди, пока на него не назначен курьер
+func (h *InHouse) Cancel(ctx context.Context, externalID string) error {
+	orderID, err := uuid.Parse(externalID)
+	if err != nil {
+		return err
+	}
+	return h.dispatcher.Withdraw(ctx, orderID)
+}
+
+func (*InHouse) Webhook(r *http.Request) (ProviderUpdate, error) {
+	return ProviderUpdate{}, errNoWebhook
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sandrinasava/cafe-services/events/eta"
+)
+
+// Batching - правила объединения готовых заказов в одну поездку курьера
+type Batching struct {
+	// сколько заказов курьер может везти за одну поездку. 1 - без объединения
+	MaxOrders int
+	// сколько заказ ждет попутных, прежде чем уйти курьеру один
+	Window time.Duration
+	// заказы попутные, если направления на них из ресторана расходятся не больше чем на MaxAngle градусов
+	MaxAngle float64
+}
+
+// queued - доставка в очереди диспетчера
+type queued struct {
+	orderID       uuid.UUID
+	correlationID string
+	pin           string
+	createdAt     time.Time
+	// адрес клиента, у заказов до появления адресов его нет
+	dest *eta.Point
+}
+
+// Bearing - направление из a на b в градусах от севера по часовой стрелке
+func Bearing(a, b eta.Point) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+	y := math.Sin(dLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLon)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
+
+// angleBetween - наименьший угол между направлениями в градусах
+func angleBetween(a, b float64) float64 {
+	d := math.Abs(a - b)
+	if d > 180 {
+		d = 360 - d
+	}
+	return d
+}
+
+// Group делит очередь на поездки: к самому старому заказу добавляются следующие по очереди заказы
+// в том же направлении от ресторана, пока поездка не заполнится. Заказы без адреса едут по одному
+func (b Batching) Group(pickup eta.Point, queue []queued) [][]queued {
+	used := make([]bool, len(queue))
+	var groups [][]queued
+	for i, seed := range queue {
+		if used[i] {
+			continue
+		}
+		used[i] = true
+		group := []queued{seed}
+		if seed.dest == nil || b.MaxOrders <= 1 {
+			groups = append(groups, group)
+			continue
+		}
+		direction := Bearing(pickup, *seed.dest)
+		for j := i + 1; j < len(queue) && len(group) < b.MaxOrders; j++ {
+			q := queue[j]
+			if used[j] || q.dest == nil || angleBetween(direction, Bearing(pickup, *q.dest)) > b.MaxAngle {
+				continue
+			}
+			used[j] = true
+			group = append(group, q)
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// Ready - поездку пора отдавать курьеру: она заполнена, ее самый старый заказ ждет попутных дольше окна
+// или попутных у него не будет - заказ без адреса едет один
+func (b Batching) Ready(group []queued, now time.Time) bool {
+	if len(group) >= b.MaxOrders || group[0].dest == nil {
+		return true
+	}
+	return now.Sub(group[0].createdAt) >= b.Window
+}
+
+// Route возвращает порядок объезда точек stops из start: сначала жадно к ближайшей еще не посещенной точке,
+// затем маршрут улучшается 2-opt - разворотом участков, пока это сокращает путь. Курьер не возвращается в start
+func Route(start eta.Point, stops []eta.Point) []int {
+	order := make([]int, 0, len(stops))
+	visited := make([]bool, len(stops))
+	at := start
+	for range stops {
+		next, best := -1, math.Inf(1)
+		for i, p := range stops {
+			if !visited[i] {
+				if d := eta.Distance(at, p); d < best {
+					next, best = i, d
+				}
+			}
+		}
+		visited[next] = true
+		order = append(order, next)
+		at = stops[next]
+	}
+
+	// точка маршрута по индексу: -1 - старт
+	point := func(k int) eta.Point {
+		if k < 0 {
+			return start
+		}
+		return stops[order[k]]
+	}
+	for improved := true; improved; {
+		improved = false
+		for i := 0; i < len(order)-1; i++ {
+			for j := i + 1; j < len(order); j++ {
+				// разворот участка order[i..j]: ребра (i-1, i) и (j, j+1) заменяются на (i-1, j) и (i, j+1)
+				before := eta.Distance(point(i-1), point(i))
+				after := eta.Distance(point(i-1), point(j))
+				if j+1 < len(order) {
+					before += eta.Distance(point(j), point(j+1))
+					after += eta.Distance(point(i), point(j+1))
+				}
+				if after < before-1e-6 {
+					reverse(order[i : j+1])
+					improved = true
+				}
+			}
+		}
+	}
+	return order
+}
+
+func reverse(s []int) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
+
+// stopETAs оценивает, когда курьер, выехав из start в момент now, доберется до каждой точки маршрута.
+// На каждой точке он тратит время на передачу заказа
+func stopETAs(model eta.Model, start eta.Point, stops []eta.Point, now time.Time) []time.Time {
+	etas := make([]time.Time, len(stops))
+	at, t := start, now
+	for i, p := range stops {
+		t = t.Add(model.Travel(at, p, t))
+		etas[i] = t.Add(model.Handover)
+		t = etas[i]
+		at = p
+	}
+	return etas
+}
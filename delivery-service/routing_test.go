@@ -0,0 +1,146 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sandrinasava/cafe-services/events/eta"
+)
+
+func TestRoute(t *testing.T) {
+	start := eta.Point{}
+	tests := []struct {
+		name  string
+		stops []eta.Point
+		want  []int
+	}{
+		{name: "без точек", stops: nil, want: []int{}},
+		{name: "одна точка", stops: []eta.Point{{Lat: 0.01, Lon: 0.01}}, want: []int{0}},
+		{
+			name:  "две точки - сначала ближняя",
+			stops: []eta.Point{{Lat: 0.02, Lon: 0}, {Lat: 0.01, Lon: 0}},
+			want:  []int{1, 0},
+		},
+		{
+			// жадный обход идет в ближнюю среднюю точку, затем в (0.03, 0) и обратно через весь маршрут
+			// в (0, 0.04): путь пересекает сам себя. 2-opt разворачивает участок и объезжает точки по порядку
+			name:  "пересекающийся маршрут",
+			stops: []eta.Point{{Lat: 0, Lon: 0.04}, {Lat: 0.02, Lon: 0.02}, {Lat: 0.03, Lon: 0}},
+			want:  []int{2, 1, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Route(start, tt.stops); !slices.Equal(got, tt.want) {
+				t.Errorf("Route() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroup(t *testing.T) {
+	pickup := eta.Point{}
+	north1 := queued{orderID: uuid.New(), dest: &eta.Point{Lat: 0.01, Lon: 0}}
+	north2 := queued{orderID: uuid.New(), dest: &eta.Point{Lat: 0.02, Lon: 0.001}}
+	east := queued{orderID: uuid.New(), dest: &eta.Point{Lat: 0, Lon: 0.01}}
+	noAddr := queued{orderID: uuid.New()}
+	north3 := queued{orderID: uuid.New(), dest: &eta.Point{Lat: 0.03, Lon: 0}}
+
+	tests := []struct {
+		name     string
+		batching Batching
+		queue    []queued
+		want     [][]queued
+	}{
+		{
+			name:     "без объединения",
+			batching: Batching{MaxOrders: 1, MaxAngle: 30},
+			queue:    []queued{north1, north2},
+			want:     [][]queued{{north1}, {north2}},
+		},
+		{
+			name:     "попутные заказы в одной поездке",
+			batching: Batching{MaxOrders: 2, MaxAngle: 30},
+			queue:    []queued{north1, east, north2},
+			want:     [][]queued{{north1, north2}, {east}},
+		},
+		{
+			name:     "поездка не больше MaxOrders",
+			batching: Batching{MaxOrders: 2, MaxAngle: 30},
+			queue:    []queued{north1, north2, north3},
+			want:     [][]queued{{north1, north2}, {north3}},
+		},
+		{
+			name:     "заказ без адреса едет один",
+			batching: Batching{MaxOrders: 3, MaxAngle: 30},
+			queue:    []queued{noAddr, north1, north2},
+			want:     [][]queued{{noAddr}, {north1, north2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.batching.Group(pickup, tt.queue)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Group() = %d поездок, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !slices.EqualFunc(got[i], tt.want[i], func(a, b queued) bool { return a.orderID == b.orderID }) {
+					t.Errorf("поездка %d: %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReady(t *testing.T) {
+	now := time.Now()
+	b := Batching{MaxOrders: 3, Window: 2 * time.Minute}
+	dest := &eta.Point{Lat: 0.01, Lon: 0}
+
+	tests := []struct {
+		name  string
+		group []queued
+		want  bool
+	}{
+		{name: "ждет попутных", group: []queued{{createdAt: now, dest: dest}}, want: false},
+		{name: "окно истекло", group: []queued{{createdAt: now.Add(-3 * time.Minute), dest: dest}}, want: true},
+		{name: "поездка заполнена", group: []queued{{createdAt: now, dest: dest}, {dest: dest}, {dest: dest}}, want: true},
+		{name: "заказ без адреса не ждет окна", group: []queued{{createdAt: now}}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := b.Ready(tt.group, now); got != tt.want {
+				t.Errorf("Ready() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBearing(t *testing.T) {
+	origin := eta.Point{}
+	tests := []struct {
+		name string
+		to   eta.Point
+		want float64
+	}{
+		{name: "север", to: eta.Point{Lat: 0.01}, want: 0},
+		{name: "восток", to: eta.Point{Lon: 0.01}, want: 90},
+		{name: "юг", to: eta.Point{Lat: -0.01}, want: 180},
+		{name: "запад", to: eta.Point{Lon: -0.01}, want: 270},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Bearing(origin, tt.to); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Bearing() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got := angleBetween(350, 10); math.Abs(got-20) > 1e-9 {
+		t.Errorf("angleBetween(350, 10) = %v, want 20", got)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// как часто поток отслеживания перечитывает доставку: заказ мог быть доставлен или передан другому курьеру.
+// Заодно это keep-alive, чтобы прокси не закрывали соединение
+const trackingRefresh = 15 * time.Second
+
+// LocationHandler принимает GPS-пинг курьера
+// POST /courier/location {"lat": 55.75, "lon": 37.61, "accuracy": 12, "at": "2024-01-01T10:00:00Z"}
+func LocationHandler(tracker *Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var loc Location
+		if err := json.NewDecoder(r.Body).Decode(&loc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err := tracker.Record(r.Context(), courierFrom(r.Context()), loc)
+		if errors.Is(err, errInvalidLocation) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка сохранения координат курьера: %v", err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// TrackingHandler отдает статус доставки заказа и координаты курьера. Внутренний маршрут:
+// клиенту его проксирует order-service, проверив, что заказ принадлежит клиенту
+// GET /deliveries/{order}/tracking
+func TrackingHandler(tracker *Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID, ok := pathUUID(w, r, "order")
+		if !ok {
+			return
+		}
+		tracking, err := tracker.Order(r.Context(), orderID)
+		if errors.Is(err, errDeliveryNotFound) {
+			http.Error(w, "Заказ еще не передан в доставку", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка отслеживания заказа %s: %v", orderID, err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, tracking)
+	}
+}
+
+// TrackingStreamHandler отправляет координаты курьера в формате Server-Sent Events: первым событием
+// tracking приходит текущее состояние доставки, затем событие location на каждый пинг курьера.
+// Когда заказ доставлен, приходит последнее событие tracking и поток закрывается
+// GET /deliveries/{order}/tracking/stream
+func TrackingStreamHandler(ctx context.Context, tracker *Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID, ok := pathUUID(w, r, "order")
+		if !ok {
+			return
+		}
+		tracking, err := tracker.Order(r.Context(), orderID)
+		if errors.Is(err, errDeliveryNotFound) {
+			http.Error(w, "Заказ еще не передан в доставку", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка отслеживания заказа %s: %v", orderID, err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		// поток живет дольше WriteTimeout сервера
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			log.Printf("Не удалось снять таймаут записи для потока: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+
+		send := func(event string, v interface{}) error {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+
+		if err := send("tracking", tracking); err != nil || (tracking.Status != DeliveryPending && !tracking.Active()) {
+			return
+		}
+
+		// подписка на курьера, который сейчас везет заказ. Пока курьер не назначен, поток только ждет
+		var (
+			sub      *redis.PubSub
+			courier  *uuid.UUID
+			messages <-chan *redis.Message
+		)
+		follow := func(t Tracking) {
+			if sameCourier(t.CourierID, courier) {
+				return
+			}
+			if sub != nil {
+				sub.Close()
+				sub, messages = nil, nil
+			}
+			courier = t.CourierID
+			if courier != nil {
+				sub = tracker.rdb.Subscribe(r.Context(), LocationChannel(*courier))
+				messages = sub.Channel()
+			}
+		}
+		defer func() {
+			if sub != nil {
+				sub.Close()
+			}
+		}()
+		follow(tracking)
+
+		refresh := time.NewTicker(trackingRefresh)
+		defer refresh.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ctx.Done():
+				return
+			case <-refresh.C:
+				current, err := tracker.Order(r.Context(), orderID)
+				if err != nil {
+					log.Printf("Ошибка отслеживания заказа %s: %v", orderID, err)
+					continue
+				}
+				if current.Status != tracking.Status || !sameCourier(current.CourierID, tracking.CourierID) {
+					if err := send("tracking", current); err != nil {
+						return
+					}
+				} else if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				} else if err := rc.Flush(); err != nil {
+					return
+				}
+				if current.Status != DeliveryPending && !current.Active() {
+					return
+				}
+				tracking = current
+				follow(tracking)
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var loc Location
+				if err := json.Unmarshal([]byte(msg.Payload), &loc); err != nil {
+					log.Printf("неудачная десериализация координат курьера: %v", err)
+					continue
+				}
+				if err := send("location", loc); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+func sameCourier(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
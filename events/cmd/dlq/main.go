@@ -0,0 +1,174 @@
+// Команда dlq показывает сообщения из топика недоставленных сообщений и возвращает их на обработку.
+//
+//	dlq -brokers kafka:9092 -topic ready_orders.dlq list
+//	dlq -brokers kafka:9092 -topic ready_orders.dlq -partition 0 -offset 42 replay
+//
+// Без -offset replay возвращает все сообщения топика (или раздела, если указан -partition).
+// Сообщение возвращается в первый топик повторов исходного топика с группой консьюмера, которая не смогла
+// его обработать: остальные группы исходного топика его уже обработали и пропустят.
+// Возвращенное сообщение снова проходит полный цикл попыток
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/events/retry"
+)
+
+func main() {
+	brokers := flag.String("brokers", "localhost:9092", "адреса брокеров Kafka через запятую")
+	topic := flag.String("topic", "", "топик DLQ, например ready_orders.dlq")
+	partition := flag.Int("partition", -1, "раздел топика, -1 - все разделы")
+	offset := flag.Int64("offset", -1, "смещение сообщения, -1 - все сообщения")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Использование: dlq [флаги] list|replay\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *topic == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	addrs := strings.Split(*brokers, ",")
+	messages, err := readTopic(ctx, addrs, *topic, *partition, *offset)
+	if err != nil {
+		log.Fatalf("Не удалось прочитать %s: %v", *topic, err)
+	}
+
+	switch flag.Arg(0) {
+	case "list":
+		for _, m := range messages {
+			printMessage(m)
+		}
+		fmt.Printf("Всего сообщений: %d\n", len(messages))
+	case "replay":
+		if err := replay(ctx, addrs, messages); err != nil {
+			log.Fatalf("Не удалось вернуть сообщения: %v", err)
+		}
+		fmt.Printf("Возвращено сообщений: %d\n", len(messages))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// readTopic читает сообщения топика от начала до текущего конца каждого раздела
+func readTopic(ctx context.Context, brokers []string, topic string, partition int, offset int64) ([]kafka.Message, error) {
+	conn, err := kafka.DialContext(ctx, "tcp", brokers[0])
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	partitions, err := conn.ReadPartitions(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []kafka.Message
+	for _, p := range partitions {
+		if partition >= 0 && p.ID != partition {
+			continue
+		}
+		pconn, err := kafka.DialLeader(ctx, "tcp", brokers[0], topic, p.ID)
+		if err != nil {
+			return nil, err
+		}
+		first, last, err := pconn.ReadOffsets()
+		pconn.Close()
+		if err != nil {
+			return nil, err
+		}
+		if offset >= 0 {
+			if offset < first || offset >= last {
+				continue
+			}
+			first, last = offset, offset+1
+		}
+		if first >= last {
+			continue
+		}
+
+		r := kafka.NewReader(kafka.ReaderConfig{Brokers: brokers, Topic: topic, Partition: p.ID})
+		if err := r.SetOffset(first); err != nil {
+			r.Close()
+			return nil, err
+		}
+		for {
+			readCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			m, err := r.ReadMessage(readCtx)
+			cancel()
+			if err != nil {
+				r.Close()
+				return nil, err
+			}
+			messages = append(messages, m)
+			if m.Offset >= last-1 {
+				break
+			}
+		}
+		r.Close()
+	}
+	return messages, nil
+}
+
+func printMessage(m kafka.Message) {
+	fmt.Printf("%s/%d/%d key=%s\n", m.Topic, m.Partition, m.Offset, m.Key)
+	fmt.Printf("  источник:  %s/%s/%s\n", retry.Header(m, retry.HeaderOriginalTopic),
+		retry.Header(m, retry.HeaderOriginalPartition), retry.Header(m, retry.HeaderOriginalOffset))
+	fmt.Printf("  группа:    %s\n", retry.Header(m, retry.HeaderConsumerGroup))
+	fmt.Printf("  попыток:   %s\n", retry.Header(m, retry.HeaderAttempt))
+	fmt.Printf("  сбой:      %s\n", retry.Header(m, retry.HeaderFailedAt))
+	fmt.Printf("  ошибка:    %s\n", retry.Header(m, retry.HeaderError))
+	if env, err := events.Decode(m); err == nil {
+		fmt.Printf("  событие:   %s v%d от %s (%s), correlation_id=%s\n",
+			env.Type, env.SchemaVersion, env.Producer, env.OccurredAt.Format(time.RFC3339), env.CorrelationID)
+	} else {
+		fmt.Printf("  значение:  %q\n", m.Value)
+	}
+}
+
+// replay возвращает сообщения в первые топики повторов исходных топиков. Из метаданных повторов остаются
+// группа консьюмера и координаты исходного сообщения, счетчик попыток начинается заново
+func replay(ctx context.Context, brokers []string, messages []kafka.Message) error {
+	w := &kafka.Writer{Addr: kafka.TCP(brokers...), Balancer: &kafka.Hash{}}
+	defer w.Close()
+
+	out := make([]kafka.Message, 0, len(messages))
+	for _, m := range messages {
+		original := retry.Header(m, retry.HeaderOriginalTopic)
+		if original == "" {
+			return fmt.Errorf("у сообщения %s/%d/%d нет заголовка %s", m.Topic, m.Partition, m.Offset, retry.HeaderOriginalTopic)
+		}
+		if retry.Header(m, retry.HeaderConsumerGroup) == "" {
+			return fmt.Errorf("у сообщения %s/%d/%d нет заголовка %s", m.Topic, m.Partition, m.Offset, retry.HeaderConsumerGroup)
+		}
+		replayed := kafka.Message{Topic: retry.RetryTopic(original, 1), Key: m.Key, Value: m.Value}
+		for _, h := range m.Headers {
+			switch h.Key {
+			case retry.HeaderConsumerGroup, retry.HeaderOriginalTopic, retry.HeaderOriginalPartition, retry.HeaderOriginalOffset:
+				replayed.Headers = append(replayed.Headers, h)
+			default:
+				if !strings.HasPrefix(h.Key, "x-") {
+					replayed.Headers = append(replayed.Headers, h)
+				}
+			}
+		}
+		out = append(out, replayed)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return w.WriteMessages(ctx, out...)
+}
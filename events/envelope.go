@@ -0,0 +1,193 @@
+// Package events описывает сообщения, которыми сервисы обмениваются через Kafka:
+// версионированный конверт, полезные нагрузки событий, их кодирование в JSON или Protobuf
+// и локальный реестр схем, который проверяется при старте сервиса
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+)
+
+// Encoding - формат, в котором конверт записывается в Kafka
+type Encoding string
+
+const (
+	JSON     Encoding = "application/json"
+	Protobuf Encoding = "application/x-protobuf"
+)
+
+// ParseEncoding разбирает значение настройки формата: json или protobuf
+func ParseEncoding(s string) (Encoding, error) {
+	switch s {
+	case "", "json":
+		return JSON, nil
+	case "protobuf":
+		return Protobuf, nil
+	}
+	return "", fmt.Errorf("неизвестный формат событий: %s", s)
+}
+
+// ContentTypeHeader - заголовок Kafka с форматом конверта
+const ContentTypeHeader = "content-type"
+
+// Envelope - конверт события
+type Envelope struct {
+	ID            string
+	Type          string
+	SchemaVersion int
+	OccurredAt    time.Time
+	Producer      string
+	CorrelationID string
+
+	payload  interface{}
+	raw      []byte
+	encoding Encoding
+}
+
+// New создает конверт для события eventType с полезной нагрузкой payload.
+// Версия схемы берется из реестра. Пустой correlationID заменяется ID события,
+// чтобы цепочку событий одного заказа можно было проследить по первому из них
+func New(eventType, producer, correlationID string, payload interface{}) (Envelope, error) {
+	version, err := LatestVersion(eventType)
+	if err != nil {
+		return Envelope{}, err
+	}
+	id := uuid.NewString()
+	if correlationID == "" {
+		correlationID = id
+	}
+	return Envelope{
+		ID:            id,
+		Type:          eventType,
+		SchemaVersion: version,
+		OccurredAt:    time.Now().UTC(),
+		Producer:      producer,
+		CorrelationID: correlationID,
+		payload:       payload,
+	}, nil
+}
+
+// jsonEnvelope - конверт в формате JSON, полезная нагрузка - вложенный объект
+type jsonEnvelope struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	SchemaVersion int             `json:"schema_version"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	Producer      string          `json:"producer"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// protoEnvelope - конверт в формате Protobuf, полезная нагрузка - вложенное закодированное сообщение
+type protoEnvelope struct {
+	ID            string    `proto:"1"`
+	Type          string    `proto:"2"`
+	SchemaVersion int64     `proto:"3"`
+	OccurredAt    time.Time `proto:"4"`
+	Producer      string    `proto:"5"`
+	CorrelationID string    `proto:"6"`
+	Payload       []byte    `proto:"7"`
+}
+
+// Encode кодирует конверт в формате enc
+func (e Envelope) Encode(enc Encoding) ([]byte, error) {
+	switch enc {
+	case JSON:
+		payload, err := json.Marshal(e.payload)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(jsonEnvelope{
+			ID:            e.ID,
+			Type:          e.Type,
+			SchemaVersion: e.SchemaVersion,
+			OccurredAt:    e.OccurredAt,
+			Producer:      e.Producer,
+			CorrelationID: e.CorrelationID,
+			Payload:       payload,
+		})
+	case Protobuf:
+		payload, err := marshalProto(e.payload)
+		if err != nil {
+			return nil, err
+		}
+		return marshalProto(protoEnvelope{
+			ID:            e.ID,
+			Type:          e.Type,
+			SchemaVersion: int64(e.SchemaVersion),
+			OccurredAt:    e.OccurredAt,
+			Producer:      e.Producer,
+			CorrelationID: e.CorrelationID,
+			Payload:       payload,
+		})
+	}
+	return nil, fmt.Errorf("неизвестный формат событий: %s", enc)
+}
+
+// Message кодирует конверт в сообщение Kafka с ключом key
+func (e Envelope) Message(key string, enc Encoding) (kafka.Message, error) {
+	value, err := e.Encode(enc)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	return kafka.Message{
+		Key:     []byte(key),
+		Value:   value,
+		Headers: []kafka.Header{{Key: ContentTypeHeader, Value: []byte(enc)}},
+	}, nil
+}
+
+// Decode разбирает конверт из сообщения Kafka. Формат определяется по заголовку content-type,
+// без заголовка сообщение считается JSON. Полезная нагрузка разбирается отдельно через DecodePayload
+func Decode(m kafka.Message) (Envelope, error) {
+	enc := JSON
+	for _, h := range m.Headers {
+		if h.Key == ContentTypeHeader {
+			enc = Encoding(h.Value)
+		}
+	}
+	return DecodeBytes(m.Value, enc)
+}
+
+// DecodeBytes разбирает конверт, закодированный в формате enc
+func DecodeBytes(value []byte, enc Encoding) (Envelope, error) {
+	var e Envelope
+	switch enc {
+	case JSON:
+		var w jsonEnvelope
+		if err := json.Unmarshal(value, &w); err != nil {
+			return Envelope{}, fmt.Errorf("неудачная десериализация конверта: %w", err)
+		}
+		e = Envelope{ID: w.ID, Type: w.Type, SchemaVersion: w.SchemaVersion, OccurredAt: w.OccurredAt,
+			Producer: w.Producer, CorrelationID: w.CorrelationID, raw: w.Payload}
+	case Protobuf:
+		var w protoEnvelope
+		if err := unmarshalProto(value, &w); err != nil {
+			return Envelope{}, fmt.Errorf("неудачная десериализация конверта: %w", err)
+		}
+		e = Envelope{ID: w.ID, Type: w.Type, SchemaVersion: int(w.SchemaVersion), OccurredAt: w.OccurredAt,
+			Producer: w.Producer, CorrelationID: w.CorrelationID, raw: w.Payload}
+	default:
+		return Envelope{}, fmt.Errorf("неизвестный формат событий: %s", enc)
+	}
+	if e.Type == "" {
+		return Envelope{}, fmt.Errorf("сообщение не является конвертом события")
+	}
+	e.encoding = enc
+	return e, nil
+}
+
+// DecodePayload разбирает полезную нагрузку конверта в v
+func (e Envelope) DecodePayload(v interface{}) error {
+	switch e.encoding {
+	case JSON:
+		return json.Unmarshal(e.raw, v)
+	case Protobuf:
+		return unmarshalProto(e.raw, v)
+	}
+	return fmt.Errorf("неизвестный формат событий: %s", e.encoding)
+}
@@ -0,0 +1,123 @@
+// Package eta оценивает, когда заказ приедет к клиенту. Оценка складывается из ожидания кухни,
+// времени приготовления позиций, ожидания свободного курьера и дороги, которая считается по расстоянию
+// между точками и модели скорости курьера. Пакет общий для order-service и delivery-service,
+// чтобы клиент при оформлении заказа и курьер в пути получали оценку по одним правилам
+package eta
+
+import (
+	"math"
+	"time"
+)
+
+// радиус Земли в метрах
+const earthRadius = 6371000
+
+// Point - координаты на карте
+type Point struct {
+	Lat float64
+	Lon float64
+}
+
+// Distance - расстояние между точками по поверхности Земли в метрах (формула гаверсинусов)
+func Distance(a, b Point) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// Model - модель скорости курьера. Теги env позволяют встроить модель в конфигурацию сервиса,
+// тогда обе службы оценивают дорогу одинаково
+type Model struct {
+	// средняя скорость курьера, км/ч
+	Speed float64 `env:"ETA_SPEED" env-default:"20"`
+	// скорость в часы пик, км/ч
+	RushSpeed float64 `env:"ETA_RUSH_SPEED" env-default:"12"`
+	// часы пик по местному времени сервиса
+	RushHours []int `env:"ETA_RUSH_HOURS" env-default:"8,9,17,18,19"`
+	// во сколько раз путь по улицам длиннее расстояния по прямой
+	Detour float64 `env:"ETA_DETOUR" env-default:"1.4"`
+	// время на парковку, подъезд и передачу заказа клиенту
+	Handover time.Duration `env:"ETA_HANDOVER" env-default:"5m"`
+}
+
+// speed возвращает скорость курьера в момент at, м/с
+func (m Model) speed(at time.Time) float64 {
+	kmh := m.Speed
+	for _, h := range m.RushHours {
+		if at.Hour() == h && m.RushSpeed > 0 {
+			kmh = m.RushSpeed
+			break
+		}
+	}
+	if kmh <= 0 {
+		kmh = 20
+	}
+	return kmh * 1000 / 3600
+}
+
+// Travel - сколько курьер едет из from в to, если выезжает в момент at
+func (m Model) Travel(from, to Point, at time.Time) time.Duration {
+	detour := m.Detour
+	if detour < 1 {
+		detour = 1
+	}
+	seconds := Distance(from, to) * detour / m.speed(at)
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// Prep - время приготовления позиций заказа. Позиции готовятся параллельно на разных станциях,
+// поэтому заказ готов, когда готова самая долгая позиция. Позиции, которых нет в prepTimes,
+// готовятся fallback
+func Prep(items []string, prepTimes map[string]time.Duration, fallback time.Duration) time.Duration {
+	var prep time.Duration
+	for _, item := range items {
+		t, ok := prepTimes[item]
+		if !ok {
+			t = fallback
+		}
+		if t > prep {
+			prep = t
+		}
+	}
+	return prep
+}
+
+// CourierWait - через сколько освободится курьер для нового заказа. Пока свободных курьеров больше,
+// чем заказов в очереди, курьер назначается сразу. Иначе очередь разбирается волнами: за одну поездку
+// trip каждый курьер на смене забирает по заказу. Без курьеров на смене оценки нет
+func CourierWait(available, onShift, queued int, trip time.Duration) (time.Duration, bool) {
+	if queued < available {
+		return 0, true
+	}
+	if onShift <= 0 {
+		return 0, false
+	}
+	waves := (queued-available)/onShift + 1
+	return time.Duration(waves) * trip, true
+}
+
+// Estimate - из чего складывается оценка времени доставки
+type Estimate struct {
+	// ожидание, пока кухня возьмет заказ
+	Kitchen time.Duration
+	// приготовление
+	Prep time.Duration
+	// ожидание свободного курьера, начинается, когда заказ готов
+	Courier time.Duration
+	// дорога курьера до ресторана и от ресторана до клиента
+	Travel time.Duration
+	// парковка и передача заказа
+	Handover time.Duration
+}
+
+// Total - сколько всего ждать заказ
+func (e Estimate) Total() time.Duration {
+	return e.Kitchen + e.Prep + e.Courier + e.Travel + e.Handover
+}
+
+// Arrival - когда заказ приедет, если оценка сделана в момент now
+func (e Estimate) Arrival(now time.Time) time.Time {
+	return now.Add(e.Total())
+}
@@ -0,0 +1,35 @@
+package eta
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCourierWait(t *testing.T) {
+	const trip = 20 * time.Minute
+	tests := []struct {
+		name                       string
+		available, onShift, queued int
+		want                       time.Duration
+		wantOK                     bool
+	}{
+		{name: "есть свободный курьер", available: 2, onShift: 3, queued: 1, want: 0, wantOK: true},
+		{name: "очереди нет", available: 1, onShift: 1, queued: 0, want: 0, wantOK: true},
+		// все свободные курьеры заберут заказы из очереди, новый ждет первой волны
+		{name: "очередь равна числу свободных", available: 2, onShift: 3, queued: 2, want: trip, wantOK: true},
+		{name: "все заняты, очередь меньше смены", available: 0, onShift: 3, queued: 2, want: trip, wantOK: true},
+		{name: "очередь на несколько волн", available: 0, onShift: 2, queued: 5, want: 3 * trip, wantOK: true},
+		{name: "волна заполнена ровно", available: 1, onShift: 2, queued: 3, want: 2 * trip, wantOK: true},
+		{name: "нет курьеров на смене", available: 0, onShift: 0, queued: 0, want: 0, wantOK: false},
+		{name: "нет курьеров на смене, есть очередь", available: 0, onShift: 0, queued: 4, want: 0, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := CourierWait(tt.available, tt.onShift, tt.queued, trip)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("CourierWait(%d, %d, %d) = %v, %v, want %v, %v",
+					tt.available, tt.onShift, tt.queued, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
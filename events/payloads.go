@@ -0,0 +1,205 @@
+package events
+
+import "time"
+
+// Типы событий. Тип определяет схему полезной нагрузки
+const (
+	// TypeOrderCreated - новый заказ для кухни (топик new_orders), полезная нагрузка Order
+	TypeOrderCreated = "order_created"
+	// TypeOrderReady - заказ приготовлен (топик ready_orders), полезная нагрузка Order
+	TypeOrderReady = "order_ready"
+	// TypeOrderCancelled - клиент отменил заказ (топик order_events), полезная нагрузка OrderChanged
+	TypeOrderCancelled = "order_cancelled"
+	// TypeOrderUpdated - клиент изменил состав заказа (топик order_events), полезная нагрузка OrderChanged
+	TypeOrderUpdated = "order_updated"
+	// TypeStatusChanged - смена статуса заказа (топик order_status), полезная нагрузка StatusChanged
+	TypeStatusChanged = "status_changed"
+	// TypeTicketProgress - ход приготовления позиции заказа на станции кухни (топик kitchen_tickets),
+	// полезная нагрузка TicketProgress
+	TypeTicketProgress = "ticket_progress"
+	// TypeKitchenBusy - загрузка кухни (топик kitchen_load), полезная нагрузка KitchenBusy
+	TypeKitchenBusy = "kitchen_busy"
+	// TypeMenuAvailability - позиция меню закончилась или снова доступна (топик kitchen_inventory),
+	// полезная нагрузка MenuAvailability
+	TypeMenuAvailability = "menu_availability"
+	// TypeStockLow - запас ингредиента опустился до порога (топик kitchen_inventory), полезная нагрузка StockLow
+	TypeStockLow = "stock_low"
+	// TypeCourierAssigned - служба доставки назначила курьера на заказ (топик delivery_events),
+	// полезная нагрузка CourierAssigned
+	TypeCourierAssigned = "courier_assigned"
+	// TypeCourierLoad - загрузка курьеров (топик delivery_events), полезная нагрузка CourierLoad
+	TypeCourierLoad = "courier_load"
+	// TypeDeliveryETA - новая оценка времени доставки заказа (топик delivery_events), полезная нагрузка DeliveryETA
+	TypeDeliveryETA = "delivery_eta"
+	// TypeCompensationRequested - доставка не удалась окончательно, клиенту нужно вернуть деньги или начислить
+	// бонусы (топик delivery_events), полезная нагрузка CompensationRequested
+	TypeCompensationRequested = "compensation_requested"
+)
+
+// Order - заказ, который передается между сервисами
+type Order struct {
+	ID       string   `json:"id" proto:"1"`
+	Customer string   `json:"customer" proto:"2"`
+	Items    []string `json:"items" proto:"3"`
+	Status   string   `json:"status" proto:"4"`
+	// приоритет заказа на кухне, см. Priority*
+	Priority int `json:"priority,omitempty" proto:"5"`
+	// тип заказа, см. OrderType*. Пусто - доставка, как у заказов до появления самовывоза
+	Type string `json:"type,omitempty" proto:"6"`
+	// адрес доставки и его координаты. У самовывоза пусто
+	Address        string  `json:"address,omitempty" proto:"7"`
+	Lat            float64 `json:"lat,omitempty" proto:"8"`
+	Lon            float64 `json:"lon,omitempty" proto:"9"`
+	AddressComment string  `json:"address_comment,omitempty" proto:"10"`
+	// зона доставки, в которую попал адрес. По ней служба доставки выбирает, кто повезет заказ
+	Zone string `json:"zone,omitempty" proto:"11"`
+}
+
+// Типы заказа
+const (
+	// курьер везет заказ клиенту
+	OrderTypeDelivery = "delivery"
+	// клиент забирает заказ сам, служба доставки его не получает
+	OrderTypePickup = "pickup"
+)
+
+// Приоритеты заказа. Кухня берет в работу заказы с большим приоритетом раньше,
+// но каждый уровень стоит ограниченного времени ожидания, поэтому обычные заказы не голодают
+const (
+	PriorityNormal = 0
+	// еда для сотрудников
+	PriorityStaff = 1
+	// VIP-клиент
+	PriorityVIP = 2
+	// компенсация клиенту, чей предыдущий заказ задержался
+	PriorityCompensation = 3
+)
+
+// OrderChanged - отмена или изменение заказа клиентом
+type OrderChanged struct {
+	OrderID string   `json:"order_id" proto:"1"`
+	Items   []string `json:"items,omitempty" proto:"2"`
+}
+
+// StatusChanged - смена статуса заказа
+type StatusChanged struct {
+	OrderID string    `json:"order_id" proto:"1"`
+	Status  string    `json:"status" proto:"2"`
+	At      time.Time `json:"at" proto:"3"`
+}
+
+// TicketProgress - смена статуса талона станции кухни. Заказ делится на талоны по станциям
+// (гриль, холодный цех, напитки, десерты) и готов, когда готовы все его талоны
+type TicketProgress struct {
+	TicketID string    `json:"ticket_id" proto:"1"`
+	OrderID  string    `json:"order_id" proto:"2"`
+	Station  string    `json:"station" proto:"3"`
+	Items    []string  `json:"items" proto:"4"`
+	Status   string    `json:"status" proto:"5"`
+	At       time.Time `json:"at" proto:"6"`
+}
+
+// KitchenBusy - загрузка кухни. Кухня публикует его при смене состояния и периодически,
+// по нему order-service оценивает время готовности и временно перестает принимать заказы
+type KitchenBusy struct {
+	// кухня перестала читать новые заказы: на какой-то станции слишком много талонов
+	Busy bool `json:"busy" proto:"1"`
+	// сколько заказов ждет или готовится
+	Orders int `json:"orders" proto:"2"`
+	// оценка времени, через которое кухня освободится, в секундах
+	EstimatedWaitSeconds int64     `json:"estimated_wait_seconds" proto:"3"`
+	BusyStations         []string  `json:"busy_stations,omitempty" proto:"4"`
+	At                   time.Time `json:"at" proto:"5"`
+}
+
+// MenuAvailability - доступность позиции меню. Кухня снимает позицию с продажи, когда для нее
+// не хватает ингредиентов, и возвращает после пополнения склада
+type MenuAvailability struct {
+	Item      string `json:"item" proto:"1"`
+	Available bool   `json:"available" proto:"2"`
+	// ингредиенты, которых не хватает для позиции
+	MissingIngredients []string  `json:"missing_ingredients,omitempty" proto:"3"`
+	At                 time.Time `json:"at" proto:"4"`
+}
+
+// StockLow - запас ингредиента на складе кухни опустился до порога и его пора пополнить
+type StockLow struct {
+	Ingredient string    `json:"ingredient" proto:"1"`
+	Unit       string    `json:"unit" proto:"2"`
+	Stock      float64   `json:"stock" proto:"3"`
+	Threshold  float64   `json:"threshold" proto:"4"`
+	At         time.Time `json:"at" proto:"5"`
+}
+
+// CourierAssigned - курьер назначен на доставку готового заказа
+type CourierAssigned struct {
+	OrderID     string `json:"order_id" proto:"1"`
+	CourierID   string `json:"courier_id" proto:"2"`
+	CourierName string `json:"courier_name" proto:"3"`
+	// стратегия диспетчера, по которой выбран курьер
+	Strategy string    `json:"strategy" proto:"4"`
+	At       time.Time `json:"at" proto:"5"`
+	// код, который клиент называет курьеру при получении заказа
+	PIN string `json:"pin,omitempty" proto:"6"`
+}
+
+// CourierLoad - загрузка курьеров. Служба доставки публикует ее после распределения заказов
+// и периодически, по ней order-service оценивает, когда курьер заберет новый заказ
+type CourierLoad struct {
+	// курьеры на смене, которые могут взять еще заказ
+	Available int `json:"available" proto:"1"`
+	// все курьеры на линии и на смене
+	OnShift int `json:"on_shift" proto:"2"`
+	// готовые заказы, которые ждут курьера
+	Queued int `json:"queued" proto:"3"`
+	// оценка ожидания курьера для нового заказа в секундах
+	EstimatedWaitSeconds int64     `json:"estimated_wait_seconds" proto:"4"`
+	At                   time.Time `json:"at" proto:"5"`
+}
+
+// DeliveryETA - оценка, когда курьер привезет заказ. Служба доставки пересчитывает ее,
+// когда назначает курьера и когда курьер забирает заказ
+type DeliveryETA struct {
+	OrderID  string    `json:"order_id" proto:"1"`
+	ArriveAt time.Time `json:"arrive_at" proto:"2"`
+	At       time.Time `json:"at" proto:"3"`
+}
+
+// CompensationRequested - просьба службы доставки компенсировать клиенту заказ, который не удалось доставить
+// и больше не будут пытаться. Сумму считает order-service
+type CompensationRequested struct {
+	OrderID string `json:"order_id" proto:"1"`
+	// что сделать, см. Compensation*
+	Kind string `json:"kind" proto:"2"`
+	// код причины, по которой не удалась последняя попытка доставки
+	Reason string `json:"reason" proto:"3"`
+	// сколько раз пытались доставить заказ
+	Attempts int       `json:"attempts" proto:"4"`
+	At       time.Time `json:"at" proto:"5"`
+}
+
+// Виды компенсации
+const (
+	// вернуть клиенту деньги за заказ и доставку
+	CompensationRefund = "refund"
+	// начислить клиенту бонусы на стоимость еды
+	CompensationCredit = "credit"
+)
+
+// payloadTypes связывает тип события с типом полезной нагрузки.
+// По этим типам CheckSchemas сверяет код с реестром схем
+var payloadTypes = map[string]interface{}{
+	TypeOrderCreated:          Order{},
+	TypeOrderReady:            Order{},
+	TypeOrderCancelled:        OrderChanged{},
+	TypeOrderUpdated:          OrderChanged{},
+	TypeStatusChanged:         StatusChanged{},
+	TypeTicketProgress:        TicketProgress{},
+	TypeKitchenBusy:           KitchenBusy{},
+	TypeMenuAvailability:      MenuAvailability{},
+	TypeStockLow:              StockLow{},
+	TypeCourierAssigned:       CourierAssigned{},
+	TypeCourierLoad:           CourierLoad{},
+	TypeDeliveryETA:           DeliveryETA{},
+	TypeCompensationRequested: CompensationRequested{},
+}
@@ -0,0 +1,240 @@
+package events
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Кодирование в Protobuf по тегам `proto:"N"`, без сгенерированного кода.
+// Результат совместим на уровне wire-формата со схемами из proto/events.proto:
+// string -> string, bool -> bool, int/int64 -> int64, float64 -> double, []byte -> bytes,
+// срезы -> repeated, time.Time -> google.protobuf.Timestamp, структуры -> вложенные сообщения
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// protoField - поле структуры с номером в Protobuf
+type protoField struct {
+	index  int
+	number protowire.Number
+}
+
+func protoFields(t reflect.Type) ([]protoField, error) {
+	var fields []protoField
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("proto")
+		if tag == "" {
+			continue
+		}
+		n, err := strconv.Atoi(tag)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("неправильный номер proto у поля %s.%s", t.Name(), t.Field(i).Name)
+		}
+		fields = append(fields, protoField{index: i, number: protowire.Number(n)})
+	}
+	return fields, nil
+}
+
+// marshalProto кодирует структуру v в Protobuf
+func marshalProto(v interface{}) ([]byte, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("ожидалась структура, получено %s", rv.Type())
+	}
+	return appendMessage(nil, rv)
+}
+
+func appendMessage(b []byte, rv reflect.Value) ([]byte, error) {
+	fields, err := protoFields(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		fv := rv.Field(f.index)
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+			for i := 0; i < fv.Len(); i++ {
+				if b, err = appendValue(b, f.number, fv.Index(i), true); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if b, err = appendValue(b, f.number, fv, false); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// appendValue кодирует одно значение. Нулевые значения не кодируются (как в proto3),
+// кроме элементов repeated-полей
+func appendValue(b []byte, num protowire.Number, v reflect.Value, force bool) ([]byte, error) {
+	if !force && v.IsZero() {
+		return b, nil
+	}
+	switch {
+	case v.Type() == timeType:
+		t := v.Interface().(time.Time)
+		var ts []byte
+		if s := t.Unix(); s != 0 {
+			ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(s))
+		}
+		if n := t.Nanosecond(); n != 0 {
+			ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(n))
+		}
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, ts), nil
+	case v.Kind() == reflect.Pointer:
+		if v.IsNil() {
+			return b, nil
+		}
+		return appendValue(b, num, v.Elem(), true)
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendString(b, v.String()), nil
+	case reflect.Bool:
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		return protowire.AppendVarint(b, protowire.EncodeBool(v.Bool())), nil
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		return protowire.AppendVarint(b, uint64(v.Int())), nil
+	case reflect.Float64:
+		b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+		return protowire.AppendFixed64(b, math.Float64bits(v.Float())), nil
+	case reflect.Slice: // []byte
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, v.Bytes()), nil
+	case reflect.Struct:
+		msg, err := appendMessage(nil, v)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, msg), nil
+	}
+	return nil, fmt.Errorf("тип %s не поддерживается в proto", v.Type())
+}
+
+// unmarshalProto декодирует Protobuf в структуру, на которую указывает v. Неизвестные поля пропускаются
+func unmarshalProto(b []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("ожидался указатель на структуру, получено %T", v)
+	}
+	return consumeMessage(b, rv.Elem())
+}
+
+func consumeMessage(b []byte, rv reflect.Value) error {
+	fields, err := protoFields(rv.Type())
+	if err != nil {
+		return err
+	}
+	byNumber := make(map[protowire.Number]int, len(fields))
+	for _, f := range fields {
+		byNumber[f.number] = f.index
+	}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		idx, known := byNumber[num]
+		if !known {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		fv := rv.Field(idx)
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+			elem := reflect.New(fv.Type().Elem()).Elem()
+			if n, err = consumeValue(b, typ, elem); err != nil {
+				return err
+			}
+			fv.Set(reflect.Append(fv, elem))
+		} else if n, err = consumeValue(b, typ, fv); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func consumeValue(b []byte, typ protowire.Type, v reflect.Value) (int, error) {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return consumeValue(b, typ, v.Elem())
+	}
+
+	switch typ {
+	case protowire.VarintType:
+		x, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		switch v.Kind() {
+		case reflect.Bool:
+			v.SetBool(protowire.DecodeBool(x))
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			v.SetInt(int64(x))
+		default:
+			return 0, fmt.Errorf("varint нельзя записать в %s", v.Type())
+		}
+		return n, nil
+	case protowire.Fixed64Type:
+		x, n := protowire.ConsumeFixed64(b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		if v.Kind() != reflect.Float64 {
+			return 0, fmt.Errorf("fixed64 нельзя записать в %s", v.Type())
+		}
+		v.SetFloat(math.Float64frombits(x))
+		return n, nil
+	case protowire.BytesType:
+		raw, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		switch {
+		case v.Type() == timeType:
+			var ts struct {
+				Seconds int64 `proto:"1"`
+				Nanos   int64 `proto:"2"`
+			}
+			if err := consumeMessage(raw, reflect.ValueOf(&ts).Elem()); err != nil {
+				return 0, err
+			}
+			v.Set(reflect.ValueOf(time.Unix(ts.Seconds, ts.Nanos).UTC()))
+		case v.Kind() == reflect.String:
+			v.SetString(string(raw))
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes(append([]byte(nil), raw...))
+		case v.Kind() == reflect.Struct:
+			if err := consumeMessage(raw, v); err != nil {
+				return 0, err
+			}
+		default:
+			return 0, fmt.Errorf("bytes нельзя записать в %s", v.Type())
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("неподдерживаемый wire-тип %d", typ)
+}
@@ -0,0 +1,216 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// payloadSamples - заполненная полезная нагрузка каждого типа события
+var payloadSamples = map[string]interface{}{
+	TypeOrderCreated: Order{
+		ID: "o-1", Customer: "c-1", Items: []string{"burger", "", "cola"}, Status: "received",
+		Priority: PriorityVIP, Type: OrderTypeDelivery, Address: "Тверская, 1", Lat: 55.7558, Lon: -37.6173,
+		AddressComment: "домофон 12", Zone: "center",
+	},
+	TypeOrderReady:     Order{ID: "o-2", Items: []string{"salad"}, Status: "ready", Type: OrderTypePickup},
+	TypeOrderCancelled: OrderChanged{OrderID: "o-3"},
+	TypeOrderUpdated:   OrderChanged{OrderID: "o-4", Items: []string{"pizza", "pizza"}},
+	TypeStatusChanged: StatusChanged{
+		OrderID: "o-5", Status: "cooking", At: time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC),
+	},
+	TypeTicketProgress: TicketProgress{
+		TicketID: "t-1", OrderID: "o-6", Station: "grill", Items: []string{"burger"}, Status: "done",
+		At: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+	},
+	TypeKitchenBusy: KitchenBusy{
+		Busy: true, Orders: 12, EstimatedWaitSeconds: 1800, BusyStations: []string{"grill", "desserts"},
+		At: time.Date(2024, 3, 1, 12, 0, 1, 0, time.UTC),
+	},
+	TypeMenuAvailability: MenuAvailability{
+		Item: "burger", Available: false, MissingIngredients: []string{"bun"},
+		At: time.Date(2024, 3, 1, 12, 0, 2, 0, time.UTC),
+	},
+	TypeStockLow: StockLow{
+		Ingredient: "bun", Unit: "шт", Stock: 2.5, Threshold: 10, At: time.Date(2024, 3, 1, 12, 0, 3, 0, time.UTC),
+	},
+	TypeCourierAssigned: CourierAssigned{
+		OrderID: "o-7", CourierID: "k-1", CourierName: "Иван", Strategy: "nearest", PIN: "0421",
+		At: time.Date(2024, 3, 1, 12, 0, 4, 0, time.UTC),
+	},
+	TypeCourierLoad: CourierLoad{
+		Available: 0, OnShift: 3, Queued: 5, EstimatedWaitSeconds: 900,
+		At: time.Date(2024, 3, 1, 12, 0, 5, 0, time.UTC),
+	},
+	TypeDeliveryETA: DeliveryETA{
+		OrderID:  "o-8",
+		ArriveAt: time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC),
+		At:       time.Date(2024, 3, 1, 12, 0, 6, 0, time.UTC),
+	},
+	TypeCompensationRequested: CompensationRequested{
+		OrderID: "o-9", Kind: CompensationCredit, Reason: "customer_unreachable", Attempts: 2,
+		At: time.Date(2024, 3, 1, 12, 0, 7, 0, time.UTC),
+	},
+}
+
+func TestPayloadRoundTrip(t *testing.T) {
+	for eventType := range payloadTypes {
+		if _, ok := payloadSamples[eventType]; !ok {
+			t.Errorf("нет примера полезной нагрузки для события %s", eventType)
+		}
+	}
+
+	for _, enc := range []Encoding{JSON, Protobuf} {
+		for eventType, payload := range payloadSamples {
+			t.Run(string(enc)+"/"+eventType, func(t *testing.T) {
+				env, err := New(eventType, "test", "", payload)
+				if err != nil {
+					t.Fatalf("New() error = %v", err)
+				}
+				m, err := env.Message("key", enc)
+				if err != nil {
+					t.Fatalf("Message() error = %v", err)
+				}
+				decoded, err := Decode(m)
+				if err != nil {
+					t.Fatalf("Decode() error = %v", err)
+				}
+				if decoded.ID != env.ID || decoded.Type != eventType || decoded.SchemaVersion != env.SchemaVersion ||
+					!decoded.OccurredAt.Equal(env.OccurredAt) || decoded.CorrelationID != env.ID {
+					t.Errorf("конверт %+v, want %+v", decoded, env)
+				}
+
+				got := reflect.New(reflect.TypeOf(payload))
+				if err := decoded.DecodePayload(got.Interface()); err != nil {
+					t.Fatalf("DecodePayload() error = %v", err)
+				}
+				if !reflect.DeepEqual(got.Elem().Interface(), payload) {
+					t.Errorf("DecodePayload() = %+v, want %+v", got.Elem().Interface(), payload)
+				}
+			})
+		}
+	}
+}
+
+// protoInner и protoOuter проверяют вложенные сообщения и указатели, которых нет в текущих событиях
+type protoInner struct {
+	Name  string   `proto:"1"`
+	Count int      `proto:"2"`
+	Tags  []string `proto:"3"`
+}
+
+type protoOuter struct {
+	Title    string       `proto:"1"`
+	Inner    protoInner   `proto:"2"`
+	Children []protoInner `proto:"3"`
+	Note     *string      `proto:"4"`
+	Limit    *int64       `proto:"5"`
+	Due      *time.Time   `proto:"6"`
+	At       time.Time    `proto:"7"`
+	Times    []time.Time  `proto:"8"`
+	Ratio    float64      `proto:"9"`
+	Enabled  bool         `proto:"10"`
+	Raw      []byte       `proto:"11"`
+	Offset   int          `proto:"12"`
+	Skipped  string
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	note, zero := "срочно", int64(0)
+	due := time.Date(2024, 3, 1, 12, 0, 0, 500, time.UTC)
+	var zeroTime time.Time
+
+	tests := []struct {
+		name string
+		in   protoOuter
+	}{
+		{name: "пустое сообщение", in: protoOuter{}},
+		{name: "нулевое время", in: protoOuter{Title: "t", At: time.Time{}}},
+		{name: "начало эпохи Unix", in: protoOuter{At: time.Unix(0, 0).UTC()}},
+		{name: "время до эпохи Unix", in: protoOuter{At: time.Date(1969, 7, 20, 20, 17, 40, 0, time.UTC)}},
+		{name: "наносекунды", in: protoOuter{At: time.Date(2024, 3, 1, 12, 0, 0, 999999999, time.UTC)}},
+		{name: "вложенное сообщение", in: protoOuter{Inner: protoInner{Name: "a", Count: 3, Tags: []string{"x", "y"}}}},
+		{
+			name: "повторяющиеся вложенные сообщения",
+			in:   protoOuter{Children: []protoInner{{Name: "a"}, {}, {Count: -1, Tags: []string{""}}}},
+		},
+		{name: "повторяющиеся пустые строки", in: protoOuter{Inner: protoInner{Tags: []string{"", "", "z"}}}},
+		{name: "повторяющееся время", in: protoOuter{Times: []time.Time{due, time.Unix(0, 0).UTC()}}},
+		{name: "указатели", in: protoOuter{Note: &note, Due: &due}},
+		// указатель на нулевое значение отличается от nil и кодируется
+		{name: "указатель на ноль", in: protoOuter{Limit: &zero}},
+		{name: "указатель на нулевое время", in: protoOuter{Due: &zeroTime}},
+		{name: "числа и байты", in: protoOuter{Ratio: -0.25, Enabled: true, Raw: []byte{0, 1, 255}, Offset: -42}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := marshalProto(tt.in)
+			if err != nil {
+				t.Fatalf("marshalProto() error = %v", err)
+			}
+			var got protoOuter
+			if err := unmarshalProto(b, &got); err != nil {
+				t.Fatalf("unmarshalProto() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.in) {
+				t.Errorf("unmarshalProto() = %+v, want %+v", got, tt.in)
+			}
+		})
+	}
+}
+
+func TestProtoSkipsUntaggedAndUnknownFields(t *testing.T) {
+	b, err := marshalProto(&protoOuter{Title: "t", Inner: protoInner{Name: "a"}, Offset: 7, Skipped: "x"})
+	if err != nil {
+		t.Fatalf("marshalProto() error = %v", err)
+	}
+
+	// поле без тега proto не кодируется
+	var full protoOuter
+	if err := unmarshalProto(b, &full); err != nil {
+		t.Fatalf("unmarshalProto() error = %v", err)
+	}
+	if full.Skipped != "" {
+		t.Errorf("Skipped = %q, want пусто", full.Skipped)
+	}
+
+	// старая версия сообщения читает только известные ей поля
+	var old struct {
+		Title  string `proto:"1"`
+		Offset int    `proto:"12"`
+	}
+	if err := unmarshalProto(b, &old); err != nil {
+		t.Fatalf("unmarshalProto() error = %v", err)
+	}
+	if old.Title != "t" || old.Offset != 7 {
+		t.Errorf("unmarshalProto() = %+v, want Title=t, Offset=7", old)
+	}
+}
+
+func TestProtoErrors(t *testing.T) {
+	if _, err := marshalProto("строка"); err == nil {
+		t.Error("marshalProto(string) error = nil, want ошибку")
+	}
+	if _, err := marshalProto(struct {
+		M map[string]int `proto:"1"`
+	}{M: map[string]int{"a": 1}}); err == nil {
+		t.Error("marshalProto(map) error = nil, want ошибку")
+	}
+	var v protoOuter
+	if err := unmarshalProto(nil, v); err == nil {
+		t.Error("unmarshalProto(не указатель) error = nil, want ошибку")
+	}
+	// строка в поле 12 не может быть прочитана как int
+	b, err := marshalProto(struct {
+		Offset string `proto:"12"`
+	}{Offset: "x"})
+	if err != nil {
+		t.Fatalf("marshalProto() error = %v", err)
+	}
+	if err := unmarshalProto(b, &v); err == nil {
+		t.Error("unmarshalProto() несовпадающего типа error = nil, want ошибку")
+	}
+	if err := unmarshalProto([]byte{0x0a, 0x05, 'a'}, &v); err == nil {
+		t.Error("unmarshalProto() обрезанного сообщения error = nil, want ошибку")
+	}
+}
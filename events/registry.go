@@ -0,0 +1,205 @@
+package events
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// schemas.json - локальный реестр схем: все когда-либо выпущенные версии полезной нагрузки каждого события.
+// Новая версия добавляется в конец списка, старые версии не редактируются
+//
+//go:embed schemas.json
+var registryJSON []byte
+
+// Field - поле схемы полезной нагрузки
+type Field struct {
+	Name     string  `json:"name"`
+	Number   int     `json:"number"`
+	Type     string  `json:"type"`
+	Repeated bool    `json:"repeated,omitempty"`
+	Fields   []Field `json:"fields,omitempty"`
+}
+
+// Schema - версия схемы полезной нагрузки
+type Schema struct {
+	Version int     `json:"version"`
+	Fields  []Field `json:"fields"`
+}
+
+// loadRegistry разбирает реестр один раз: LatestVersion вызывается на каждое новое событие.
+// Результат общий для всех вызовов и не должен изменяться
+var loadRegistry = sync.OnceValues(func() (map[string][]Schema, error) {
+	var registry map[string][]Schema
+	if err := json.Unmarshal(registryJSON, &registry); err != nil {
+		return nil, fmt.Errorf("неудачная загрузка реестра схем: %w", err)
+	}
+	return registry, nil
+})
+
+// LatestVersion возвращает последнюю зарегистрированную версию схемы события
+func LatestVersion(eventType string) (int, error) {
+	registry, err := loadRegistry()
+	if err != nil {
+		return 0, err
+	}
+	versions := registry[eventType]
+	if len(versions) == 0 {
+		return 0, fmt.Errorf("схема события %s не зарегистрирована", eventType)
+	}
+	return versions[len(versions)-1].Version, nil
+}
+
+// CheckSchemas сверяет полезные нагрузки событий с реестром схем. Сервисы вызывают ее при старте:
+// каждая версия схемы должна быть совместима с предыдущей, а код - совпадать с последней версией.
+// Так несовместимое изменение структуры события не дойдет до Kafka
+func CheckSchemas() error {
+	registry, err := loadRegistry()
+	if err != nil {
+		return err
+	}
+
+	types := make([]string, 0, len(payloadTypes))
+	for t := range payloadTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	for _, eventType := range types {
+		versions := registry[eventType]
+		if len(versions) == 0 {
+			return fmt.Errorf("схема события %s не зарегистрирована", eventType)
+		}
+		for i, v := range versions {
+			if v.Version != i+1 {
+				return fmt.Errorf("событие %s: версии схемы должны идти подряд начиная с 1", eventType)
+			}
+			if i == 0 {
+				continue
+			}
+			if err := compatible(versions[i-1].Fields, v.Fields, ""); err != nil {
+				return fmt.Errorf("событие %s: версия %d несовместима с версией %d: %w", eventType, v.Version, v.Version-1, err)
+			}
+		}
+
+		latest := versions[len(versions)-1]
+		current, err := schemaOf(reflect.TypeOf(payloadTypes[eventType]))
+		if err != nil {
+			return fmt.Errorf("событие %s: %w", eventType, err)
+		}
+		if err := compatible(latest.Fields, current, ""); err != nil {
+			return fmt.Errorf("событие %s: несовместимое изменение схемы версии %d: %w", eventType, latest.Version, err)
+		}
+		if !reflect.DeepEqual(normalize(latest.Fields), normalize(current)) {
+			return fmt.Errorf("событие %s: схема изменилась, зарегистрируйте версию %d в schemas.json", eventType, latest.Version+1)
+		}
+	}
+	return nil
+}
+
+// compatible проверяет, что newer можно читать кодом, написанным для older, и наоборот:
+// поля нельзя удалять, переименовывать, менять им тип или номер, добавлять новые можно
+func compatible(older, newer []Field, prefix string) error {
+	byNumber := make(map[int]Field, len(newer))
+	byName := make(map[string]Field, len(newer))
+	for _, f := range newer {
+		byNumber[f.Number] = f
+		byName[f.Name] = f
+	}
+
+	for _, old := range older {
+		name := prefix + old.Name
+		f, ok := byNumber[old.Number]
+		if !ok {
+			if renamed, ok := byName[old.Name]; ok {
+				return fmt.Errorf("у поля %s изменен номер с %d на %d", name, old.Number, renamed.Number)
+			}
+			return fmt.Errorf("поле %s (%d) удалено", name, old.Number)
+		}
+		if f.Name != old.Name {
+			return fmt.Errorf("поле %d переименовано из %s в %s", old.Number, name, prefix+f.Name)
+		}
+		if f.Type != old.Type || f.Repeated != old.Repeated {
+			return fmt.Errorf("у поля %s изменен тип", name)
+		}
+		if f.Type == "message" {
+			if err := compatible(old.Fields, f.Fields, name+"."); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// normalize сортирует поля по номеру, чтобы порядок в реестре не влиял на сравнение
+func normalize(fields []Field) []Field {
+	out := make([]Field, len(fields))
+	copy(out, fields)
+	sort.Slice(out, func(i, j int) bool { return out[i].Number < out[j].Number })
+	for i := range out {
+		if out[i].Fields != nil {
+			out[i].Fields = normalize(out[i].Fields)
+		}
+	}
+	return out
+}
+
+// schemaOf строит схему по тегам json и proto структуры
+func schemaOf(t reflect.Type) ([]Field, error) {
+	var fields []Field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("proto")
+		if tag == "" {
+			continue
+		}
+		number, err := strconv.Atoi(tag)
+		if err != nil {
+			return nil, fmt.Errorf("неправильный номер proto у поля %s", sf.Name)
+		}
+
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "" {
+			name = sf.Name
+		}
+
+		ft := sf.Type
+		f := Field{Name: name, Number: number}
+		if ft.Kind() == reflect.Slice && ft.Elem().Kind() != reflect.Uint8 {
+			f.Repeated = true
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+
+		switch {
+		case ft == timeType:
+			f.Type = "timestamp"
+		case ft.Kind() == reflect.String:
+			f.Type = "string"
+		case ft.Kind() == reflect.Bool:
+			f.Type = "bool"
+		case ft.Kind() == reflect.Int, ft.Kind() == reflect.Int32, ft.Kind() == reflect.Int64:
+			f.Type = "int64"
+		case ft.Kind() == reflect.Float64:
+			f.Type = "double"
+		case ft.Kind() == reflect.Slice:
+			f.Type = "bytes"
+		case ft.Kind() == reflect.Struct:
+			f.Type = "message"
+			if f.Fields, err = schemaOf(ft); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("тип поля %s не поддерживается", sf.Name)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
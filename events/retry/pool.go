@@ -0,0 +1,248 @@
+package retry
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// границы корзин гистограммы времени обработки
+var latencyBuckets = []time.Duration{
+	100 * time.Millisecond, 500 * time.Millisecond, time.Second, 2 * time.Second,
+	5 * time.Second, 10 * time.Second, 30 * time.Second,
+}
+
+// job - прочитанное сообщение и консьюмер, через который нужно зафиксировать его смещение
+type job struct {
+	m       kafka.Message
+	reader  *kafka.Reader
+	offsets *partitionOffsets
+}
+
+// pool обрабатывает сообщения параллельно. Сообщения с одинаковым ключом (ID заказа) попадают
+// к одному обработчику и обрабатываются по порядку. Число прочитанных, но не обработанных
+// сообщений ограничено: когда лимит исчерпан, чтение из Kafka приостанавливается
+type pool struct {
+	c       *Consumer
+	workers []chan job
+	slots   chan struct{}
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	offsets map[topicPartition]*partitionOffsets
+	// фиксирует смещение в брокере, в тестах подменяется
+	commit func(ctx context.Context, r *kafka.Reader, m kafka.Message) error
+
+	queued    atomic.Int64
+	busy      atomic.Int64
+	processed atomic.Int64
+	failed    atomic.Int64
+
+	latencyMu sync.Mutex
+	latency   LatencyStats
+}
+
+type topicPartition struct {
+	topic     string
+	partition int
+}
+
+// partitionOffsets - сообщения раздела в порядке чтения и отметки об их обработке
+type partitionOffsets struct {
+	messages []kafka.Message
+	done     map[int64]bool
+}
+
+func newPool(c *Consumer, workers, maxInFlight int) *pool {
+	p := &pool{
+		c:       c,
+		workers: make([]chan job, workers),
+		slots:   make(chan struct{}, maxInFlight),
+		offsets: make(map[topicPartition]*partitionOffsets),
+		commit: func(ctx context.Context, r *kafka.Reader, m kafka.Message) error {
+			return r.CommitMessages(ctx, m)
+		},
+		latency: LatencyStats{Buckets: make(map[string]int64)},
+	}
+	for i := range p.workers {
+		p.workers[i] = make(chan job, maxInFlight)
+	}
+	return p
+}
+
+// start запускает обработчиков
+func (p *pool) start(ctx context.Context) {
+	for _, jobs := range p.workers {
+		p.wg.Add(1)
+		go func(jobs chan job) {
+			defer p.wg.Done()
+			for j := range jobs {
+				p.queued.Add(-1)
+				p.process(ctx, j)
+			}
+		}(jobs)
+	}
+}
+
+// dispatch передает сообщение обработчику. Блокируется, пока число сообщений в работе не опустится
+// ниже лимита; false - ctx отменен и сообщение не принято
+func (p *pool) dispatch(ctx context.Context, r *kafka.Reader, m kafka.Message) bool {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+	offsets := p.track(m)
+	p.queued.Add(1)
+	p.workers[p.worker(m)] <- job{m: m, reader: r, offsets: offsets}
+	return true
+}
+
+// stop дожидается обработки всех принятых сообщений
+func (p *pool) stop() {
+	for _, jobs := range p.workers {
+		close(jobs)
+	}
+	p.wg.Wait()
+}
+
+func (p *pool) worker(m kafka.Message) int {
+	if len(m.Key) == 0 {
+		return m.Partition % len(p.workers)
+	}
+	h := fnv.New32a()
+	h.Write(m.Key)
+	return int(h.Sum32() % uint32(len(p.workers)))
+}
+
+func (p *pool) process(ctx context.Context, j job) {
+	defer func() { <-p.slots }()
+	p.busy.Add(1)
+	defer p.busy.Add(-1)
+
+	// топик повторов общий для всех групп консьюмеров исходного топика, чужие повторы пропускаются
+	if p.c.foreign(j.m) {
+		p.complete(ctx, j)
+		return
+	}
+
+	start := time.Now()
+	// остановка сервиса не прерывает начатую обработку, иначе заказ будет приготовлен дважды
+	err := p.c.handler(context.WithoutCancel(ctx), j.m)
+	p.observe(time.Since(start))
+	if err != nil {
+		p.failed.Add(1)
+		if !p.c.route(ctx, j.m, err) {
+			// смещение не фиксирую, сообщение будет прочитано заново после перезапуска
+			return
+		}
+	} else {
+		p.processed.Add(1)
+	}
+	p.complete(ctx, j)
+}
+
+// track запоминает порядок сообщений раздела, чтобы фиксировать смещения без пропусков
+func (p *pool) track(m kafka.Message) *partitionOffsets {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := topicPartition{m.Topic, m.Partition}
+	o, ok := p.offsets[key]
+	// после перебалансировки раздел читается заново с зафиксированного смещения
+	if !ok || (len(o.messages) > 0 && m.Offset <= o.messages[len(o.messages)-1].Offset) {
+		o = &partitionOffsets{done: make(map[int64]bool)}
+		p.offsets[key] = o
+	}
+	o.messages = append(o.messages, m)
+	return o
+}
+
+// complete отмечает сообщение обработанным и фиксирует смещение последнего сообщения раздела,
+// перед которым обработаны все остальные. Иначе после падения потерялись бы заказы, которые
+// еще готовились, когда более поздние уже были готовы
+func (p *pool) complete(ctx context.Context, j job) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// сообщение, прочитанное до перебалансировки, не должно сдвигать смещение заново прочитанного раздела
+	o := j.offsets
+	if p.offsets[topicPartition{j.m.Topic, j.m.Partition}] != o {
+		return
+	}
+	o.done[j.m.Offset] = true
+
+	var last *kafka.Message
+	for len(o.messages) > 0 && o.done[o.messages[0].Offset] {
+		last = &o.messages[0]
+		delete(o.done, last.Offset)
+		o.messages = o.messages[1:]
+	}
+	if last == nil {
+		return
+	}
+	if err := p.commit(context.WithoutCancel(ctx), j.reader, *last); err != nil {
+		log.Printf("не удалось зафиксировать смещение %s/%d/%d: %v", last.Topic, last.Partition, last.Offset, err)
+	}
+}
+
+func (p *pool) observe(d time.Duration) {
+	p.latencyMu.Lock()
+	defer p.latencyMu.Unlock()
+
+	p.latency.Count++
+	p.latency.TotalSeconds += d.Seconds()
+	if s := d.Seconds(); s > p.latency.MaxSeconds {
+		p.latency.MaxSeconds = s
+	}
+	bucket := "+Inf"
+	for _, b := range latencyBuckets {
+		if d <= b {
+			bucket = b.String()
+			break
+		}
+	}
+	p.latency.Buckets[bucket]++
+}
+
+// Stats - метрики консьюмера
+type Stats struct {
+	// сообщения, которые прочитаны и ждут свободного обработчика
+	Queued int64 `json:"queued"`
+	// сообщения, которые обрабатываются прямо сейчас
+	Busy      int64        `json:"busy"`
+	Processed int64        `json:"processed"`
+	Failed    int64        `json:"failed"`
+	Latency   LatencyStats `json:"latency"`
+}
+
+// LatencyStats - время обработки сообщений, корзины гистограммы подписаны верхней границей
+type LatencyStats struct {
+	Count        int64            `json:"count"`
+	TotalSeconds float64          `json:"total_seconds"`
+	MaxSeconds   float64          `json:"max_seconds"`
+	Buckets      map[string]int64 `json:"buckets"`
+}
+
+func (p *pool) stats() Stats {
+	p.latencyMu.Lock()
+	latency := p.latency
+	latency.Buckets = make(map[string]int64, len(p.latency.Buckets))
+	for k, v := range p.latency.Buckets {
+		latency.Buckets[k] = v
+	}
+	p.latencyMu.Unlock()
+
+	return Stats{
+		Queued:    p.queued.Load(),
+		Busy:      p.busy.Load(),
+		Processed: p.processed.Load(),
+		Failed:    p.failed.Load(),
+		Latency:   latency,
+	}
+}
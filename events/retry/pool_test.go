@@ -0,0 +1,135 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestPoolOffsets(t *testing.T) {
+	// step - прочитать сообщение (track) или закончить его обработку (complete). Задание называется name,
+	// чтобы закончить задание, прочитанное до перебалансировки, после повторного чтения того же смещения
+	type step struct {
+		complete  bool
+		name      string
+		topic     string
+		partition int
+		offset    int64
+	}
+	track := func(name, topic string, partition int, offset int64) step {
+		return step{name: name, topic: topic, partition: partition, offset: offset}
+	}
+	complete := func(name string) step {
+		return step{complete: true, name: name}
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+		want  []string
+	}{
+		{
+			name: "по порядку",
+			steps: []step{
+				track("a", "orders", 0, 0), track("b", "orders", 0, 1), track("c", "orders", 0, 2),
+				complete("a"), complete("b"), complete("c"),
+			},
+			want: []string{"orders/0/0", "orders/0/1", "orders/0/2"},
+		},
+		{
+			// поздние сообщения готовы раньше, смещение ждет первое
+			name: "в обратном порядке",
+			steps: []step{
+				track("a", "orders", 0, 0), track("b", "orders", 0, 1), track("c", "orders", 0, 2),
+				complete("c"), complete("b"), complete("a"),
+			},
+			want: []string{"orders/0/2"},
+		},
+		{
+			name: "дыра в середине",
+			steps: []step{
+				track("a", "orders", 0, 10), track("b", "orders", 0, 11), track("c", "orders", 0, 12),
+				complete("b"), complete("a"), complete("c"),
+			},
+			want: []string{"orders/0/11", "orders/0/12"},
+		},
+		{
+			name: "разделы независимы",
+			steps: []step{
+				track("a", "orders", 0, 0), track("b", "orders", 1, 0), track("c", "orders", 0, 1),
+				complete("b"), complete("c"), complete("a"),
+			},
+			want: []string{"orders/1/0", "orders/0/1"},
+		},
+		{
+			name: "топики независимы",
+			steps: []step{
+				track("a", "orders", 0, 5), track("b", "orders.retry.1", 0, 5),
+				complete("b"), complete("a"),
+			},
+			want: []string{"orders.retry.1/0/5", "orders/0/5"},
+		},
+		{
+			// после перебалансировки раздел читается заново с зафиксированного смещения. Задания, прочитанные
+			// до перебалансировки, не должны сдвигать смещение заново прочитанного раздела
+			name: "старое задание после перебалансировки",
+			steps: []step{
+				track("a", "orders", 0, 0), track("b", "orders", 0, 1),
+				track("a2", "orders", 0, 0),
+				complete("b"), complete("a"),
+				complete("a2"),
+			},
+			want: []string{"orders/0/0"},
+		},
+		{
+			name: "перебалансировка после частичной фиксации",
+			steps: []step{
+				track("a", "orders", 0, 5), track("b", "orders", 0, 6), track("c", "orders", 0, 7),
+				complete("a"),
+				track("b2", "orders", 0, 6), track("c2", "orders", 0, 7),
+				complete("c"), complete("b"),
+				complete("c2"), complete("b2"),
+			},
+			want: []string{"orders/0/5", "orders/0/7"},
+		},
+		{
+			name: "старое задание не мешает заново прочитанным",
+			steps: []step{
+				track("a", "orders", 0, 0), track("b", "orders", 0, 1),
+				track("a2", "orders", 0, 0), track("b2", "orders", 0, 1),
+				complete("a2"), complete("a"), complete("b2"), complete("b"),
+			},
+			want: []string{"orders/0/0", "orders/0/1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPool(&Consumer{}, 1, 1)
+			var got []string
+			p.commit = func(_ context.Context, _ *kafka.Reader, m kafka.Message) error {
+				got = append(got, fmt.Sprintf("%s/%d/%d", m.Topic, m.Partition, m.Offset))
+				return nil
+			}
+
+			jobs := make(map[string]job)
+			for _, s := range tt.steps {
+				if s.complete {
+					j, ok := jobs[s.name]
+					if !ok {
+						t.Fatalf("задание %s не прочитано", s.name)
+					}
+					p.complete(context.Background(), j)
+					continue
+				}
+				m := kafka.Message{Topic: s.topic, Partition: s.partition, Offset: s.offset}
+				jobs[s.name] = job{m: m, offsets: p.track(m)}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("зафиксированы смещения %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
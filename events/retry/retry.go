@@ -0,0 +1,360 @@
+// Package retry - конвейер повторной обработки сообщений Kafka. Сообщение, которое не удалось
+// обработать, перекладывается в топик отложенных повторов <topic>.retry.N, а после исчерпания
+// попыток - в топик недоставленных сообщений <topic>.dlq с метаданными ошибки в заголовках.
+// Смещение исходного сообщения фиксируется только после успешной обработки или перекладывания,
+// фиксации копятся и отправляются в брокер пачкой раз в CommitInterval
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// заголовки с метаданными повторов
+const (
+	HeaderAttempt           = "x-retry-attempt"
+	HeaderRetryAfter        = "x-retry-after"
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+	HeaderConsumerGroup     = "x-consumer-group"
+	HeaderError             = "x-error"
+	HeaderFailedAt          = "x-failed-at"
+)
+
+// RetryTopic возвращает имя топика n-го отложенного повтора
+func RetryTopic(topic string, n int) string {
+	return fmt.Sprintf("%s.retry.%d", topic, n)
+}
+
+// DLQTopic возвращает имя топика недоставленных сообщений
+func DLQTopic(topic string) string {
+	return topic + ".dlq"
+}
+
+// Handler обрабатывает одно сообщение. Ошибка отправляет сообщение на повтор,
+// ошибка, обернутая в Permanent, - сразу в DLQ
+type Handler func(ctx context.Context, m kafka.Message) error
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent помечает ошибку, которую повтор не исправит, например битое сообщение
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// IsPermanent сообщает, помечена ли ошибка через Permanent
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// Config - настройки консьюмера с повторами
+type Config struct {
+	Brokers []string
+	Topic   string
+	GroupID string
+	// задержки перед повторами, для каждой создается свой топик <topic>.retry.N
+	Delays []time.Duration
+	// общее число попыток обработки, включая первую
+	MaxAttempts int
+	// как часто отправлять в брокер накопленные смещения, 0 - фиксировать каждое сообщение сразу
+	CommitInterval time.Duration
+	// число параллельных обработчиков, по умолчанию 1
+	Workers int
+	// сколько прочитанных сообщений может одновременно ждать обработки или обрабатываться,
+	// по умолчанию равно Workers
+	MaxInFlight int
+	// с какого смещения начинает читать группа, у которой еще нет зафиксированных смещений:
+	// kafka.FirstOffset (по умолчанию) или kafka.LastOffset
+	StartOffset int64
+}
+
+// Consumer читает основной топик и топики повторов и передает сообщения в Handler
+type Consumer struct {
+	cfg     Config
+	handler Handler
+	readers []*kafka.Reader
+	writer  *kafka.Writer
+	pool    *pool
+
+	pauseMu sync.Mutex
+	// закрыт, пока консьюмер не на паузе
+	resumed chan struct{}
+}
+
+// NewConsumer создает консьюмера основного топика и по одному консьюмеру на каждый топик повторов
+func NewConsumer(cfg Config, handler Handler) *Consumer {
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.MaxInFlight < cfg.Workers {
+		cfg.MaxInFlight = cfg.Workers
+	}
+	c := &Consumer{
+		cfg:     cfg,
+		handler: handler,
+		// топик берется из каждого сообщения, ключ сохраняет порядок сообщений одного заказа
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(cfg.Brokers...),
+			Balancer:               &kafka.Hash{},
+			AllowAutoTopicCreation: true,
+		},
+	}
+	c.readers = append(c.readers, kafka.NewReader(kafka.ReaderConfig{
+		Brokers:        cfg.Brokers,
+		GroupID:        cfg.GroupID,
+		Topic:          cfg.Topic,
+		CommitInterval: cfg.CommitInterval,
+		StartOffset:    cfg.StartOffset,
+	}))
+	for n := 1; n <= len(cfg.Delays); n++ {
+		c.readers = append(c.readers, kafka.NewReader(kafka.ReaderConfig{
+			Brokers:        cfg.Brokers,
+			GroupID:        fmt.Sprintf("%s-retry-%d", cfg.GroupID, n),
+			Topic:          RetryTopic(cfg.Topic, n),
+			CommitInterval: cfg.CommitInterval,
+			StartOffset:    cfg.StartOffset,
+		}))
+	}
+	c.pool = newPool(c, cfg.Workers, cfg.MaxInFlight)
+	c.resumed = make(chan struct{})
+	close(c.resumed)
+	return c
+}
+
+// Run читает все топики до отмены ctx. После отмены новые сообщения не читаются, а уже начатые
+// дорабатываются до конца: Run возвращается, когда обработка всех сообщений завершена.
+// Накопленные смещения отправляются в брокер при Close
+func (c *Consumer) Run(ctx context.Context) {
+	c.pool.start(ctx)
+	defer c.pool.stop()
+
+	done := make(chan struct{})
+	for _, r := range c.readers {
+		go func(r *kafka.Reader) {
+			c.consume(ctx, r)
+			done <- struct{}{}
+		}(r)
+	}
+	for range c.readers {
+		<-done
+	}
+}
+
+func (c *Consumer) consume(ctx context.Context, r *kafka.Reader) {
+	for {
+		// на паузе новые сообщения не читаются, уже прочитанные дорабатываются
+		if !c.waitResumed(ctx) {
+			return
+		}
+		m, err := r.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("неудачное чтение из брокера (%s): %v", r.Config().Topic, err)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		// в топике повторов все сообщения ждут одинаковое время, поэтому ожидание первого
+		// не задерживает следующие дольше, чем нужно. Повторы других групп не ждут: они только пропускаются
+		if due, ok := retryAfter(m); ok && !c.foreign(m) {
+			select {
+			case <-time.After(time.Until(due)):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		// смещение фиксируется только после обработки или перекладывания сообщения
+		if !c.pool.dispatch(ctx, r, m) {
+			return
+		}
+	}
+}
+
+// foreign - сообщение из топика повторов, которое переложила другая группа консьюмеров того же топика
+func (c *Consumer) foreign(m kafka.Message) bool {
+	group := Header(m, HeaderConsumerGroup)
+	return group != "" && group != c.cfg.GroupID
+}
+
+// Pause приостанавливает чтение новых сообщений, например когда кухня перегружена
+func (c *Consumer) Pause() {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	select {
+	case <-c.resumed:
+		c.resumed = make(chan struct{})
+	default:
+	}
+}
+
+// Resume возобновляет чтение после Pause
+func (c *Consumer) Resume() {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	select {
+	case <-c.resumed:
+	default:
+		close(c.resumed)
+	}
+}
+
+// Paused сообщает, приостановлено ли чтение
+func (c *Consumer) Paused() bool {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	select {
+	case <-c.resumed:
+		return false
+	default:
+		return true
+	}
+}
+
+func (c *Consumer) waitResumed(ctx context.Context) bool {
+	c.pauseMu.Lock()
+	resumed := c.resumed
+	c.pauseMu.Unlock()
+	select {
+	case <-resumed:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Stats возвращает метрики консьюмера: глубину очереди, число обработанных сообщений и время обработки
+func (c *Consumer) Stats() Stats {
+	return c.pool.stats()
+}
+
+// route перекладывает сообщение в следующий топик повторов или в DLQ.
+// Запись повторяется до успеха, после отмены ctx делается последняя попытка.
+// false - сообщение не переложено и будет прочитано заново после перезапуска
+func (c *Consumer) route(ctx context.Context, m kafka.Message, handleErr error) bool {
+	attempt := Attempt(m)
+	next := c.next(m, attempt, handleErr)
+	if next.Topic == DLQTopic(c.cfg.Topic) {
+		log.Printf("сообщение %s/%d/%d отправлено в %s после %d попыток: %v",
+			m.Topic, m.Partition, m.Offset, next.Topic, attempt, handleErr)
+	} else {
+		log.Printf("ошибка обработки сообщения %s/%d/%d (попытка %d), повтор через %s: %v",
+			m.Topic, m.Partition, m.Offset, attempt, next.Topic, handleErr)
+	}
+
+	for {
+		err := c.writer.WriteMessages(context.WithoutCancel(ctx), next)
+		if err == nil {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		log.Printf("не удалось отправить сообщение в %s: %v", next.Topic, err)
+		select {
+		case <-time.After(1 * time.Second):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// next готовит копию сообщения для следующей попытки или для DLQ
+func (c *Consumer) next(m kafka.Message, attempt int, handleErr error) kafka.Message {
+	now := time.Now().UTC()
+	out := kafka.Message{Key: m.Key, Value: m.Value}
+
+	// исходные координаты сохраняю с первого сбоя, чтобы DLQ указывала на исходное сообщение
+	origin := map[string]string{
+		HeaderOriginalTopic:     m.Topic,
+		HeaderOriginalPartition: strconv.Itoa(m.Partition),
+		HeaderOriginalOffset:    strconv.FormatInt(m.Offset, 10),
+	}
+	for _, h := range m.Headers {
+		switch h.Key {
+		case HeaderOriginalTopic, HeaderOriginalPartition, HeaderOriginalOffset:
+			origin[h.Key] = string(h.Value)
+		case HeaderAttempt, HeaderRetryAfter, HeaderConsumerGroup, HeaderError, HeaderFailedAt:
+		default:
+			out.Headers = append(out.Headers, h)
+		}
+	}
+	for _, k := range []string{HeaderOriginalTopic, HeaderOriginalPartition, HeaderOriginalOffset} {
+		out.Headers = append(out.Headers, kafka.Header{Key: k, Value: []byte(origin[k])})
+	}
+	out.Headers = append(out.Headers,
+		kafka.Header{Key: HeaderAttempt, Value: []byte(strconv.Itoa(attempt))},
+		kafka.Header{Key: HeaderConsumerGroup, Value: []byte(c.cfg.GroupID)},
+		kafka.Header{Key: HeaderError, Value: []byte(handleErr.Error())},
+		kafka.Header{Key: HeaderFailedAt, Value: []byte(now.Format(time.RFC3339Nano))},
+	)
+
+	if IsPermanent(handleErr) || attempt >= c.cfg.MaxAttempts || len(c.cfg.Delays) == 0 {
+		out.Topic = DLQTopic(c.cfg.Topic)
+		return out
+	}
+
+	// если попыток больше, чем задержек, последние повторы идут через последний топик
+	n := min(attempt, len(c.cfg.Delays))
+	out.Topic = RetryTopic(c.cfg.Topic, n)
+	out.Headers = append(out.Headers, kafka.Header{
+		Key:   HeaderRetryAfter,
+		Value: []byte(now.Add(c.cfg.Delays[n-1]).Format(time.RFC3339Nano)),
+	})
+	return out
+}
+
+// Close отправляет в брокер накопленные смещения и закрывает консьюмеров и продюсера повторов.
+// Вызывается после завершения Run
+func (c *Consumer) Close() error {
+	var errs []error
+	for _, r := range c.readers {
+		errs = append(errs, r.Close())
+	}
+	errs = append(errs, c.writer.Close())
+	return errors.Join(errs...)
+}
+
+// Header возвращает значение заголовка сообщения
+func Header(m kafka.Message, key string) string {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// Attempt возвращает номер попытки обработки сообщения, начиная с 1
+func Attempt(m kafka.Message) int {
+	n, err := strconv.Atoi(Header(m, HeaderAttempt))
+	if err != nil {
+		return 1
+	}
+	return n + 1
+}
+
+func retryAfter(m kafka.Message) (time.Time, bool) {
+	due, err := time.Parse(time.RFC3339Nano, Header(m, HeaderRetryAfter))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return due, true
+}
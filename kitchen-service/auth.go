@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// роли, которым доступен KDS API
+const (
+	RoleKitchen = "kitchen"
+	RoleAdmin   = "admin"
+)
+
+// staffOnly пропускает запрос, только если токен auth-service подписан ключом JWT_SECRET_KEY
+// и выдан сотруднику кухни или администратору. Браузерный WebSocket не умеет передавать
+// заголовки, поэтому без заголовка токен берется из куки access_token
+func staffOnly(secret string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		if token == "" {
+			if cookie, err := r.Cookie("access_token"); err == nil {
+				token = cookie.Value
+			}
+		}
+
+		role, err := tokenRole(secret, strings.TrimPrefix(token, "Bearer "))
+		if err != nil {
+			log.Printf("Ошибка при валидации токена: %v", err)
+			http.Error(w, "Недействительный токен", http.StatusUnauthorized)
+			return
+		}
+		if role != RoleKitchen && role != RoleAdmin {
+			http.Error(w, "Доступ только для сотрудников кухни", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// tokenRole проверяет подпись токена и возвращает роль пользователя
+func tokenRole(secret, tokenString string) (string, error) {
+	if secret == "" {
+		return "", fmt.Errorf("JWT_SECRET_KEY не задан")
+	}
+	if tokenString == "" {
+		return "", fmt.Errorf("токен не предоставлен")
+	}
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("неожиданный метод подписи: %v", token.Header["alg"])
+		}
+		return []byte(secret), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return "", fmt.Errorf("недействительный токен")
+	}
+	role, _ := claims["role"].(string)
+	return role, nil
+}
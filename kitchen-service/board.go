@@ -0,0 +1,494 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	errTicketNotFound = errors.New("талон не найден")
+	errTicketState    = errors.New("действие недоступно в текущем статусе талона")
+	errStationBusy    = errors.New("на станции нет свободного места")
+)
+
+// TicketView - талон в ответах KDS API и в WebSocket-потоке
+type TicketView struct {
+	ID          string    `json:"id"`
+	OrderID     string    `json:"order_id"`
+	Station     string    `json:"station"`
+	Items       []string  `json:"items"`
+	Status      string    `json:"status"`
+	Priority    int       `json:"priority"`
+	PrepSeconds float64   `json:"prep_seconds"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// StationView - загрузка станции
+type StationView struct {
+	Name       string `json:"name"`
+	Capacity   int    `json:"capacity"`
+	MaxTickets int    `json:"max_tickets"`
+	Cooking    int    `json:"cooking"`
+	Waiting    int    `json:"waiting"`
+}
+
+// Load - оценка загрузки кухни
+type Load struct {
+	// заказы на доске
+	Orders int
+	// через сколько освободится самая загруженная станция
+	EstimatedWait time.Duration
+	// станции, на которых талонов не меньше MaxTickets
+	BusyStations []string
+}
+
+type boardTicket struct {
+	Ticket
+	status    string
+	createdAt time.Time
+	updatedAt time.Time
+	startedAt time.Time
+	order     *boardOrder
+}
+
+func (t *boardTicket) view() TicketView {
+	return TicketView{
+		ID:          t.ID,
+		OrderID:     t.OrderID,
+		Station:     t.Station,
+		Items:       t.Items,
+		Status:      t.status,
+		Priority:    t.Priority,
+		PrepSeconds: t.PrepTime.Seconds(),
+		CreatedAt:   t.createdAt,
+		UpdatedAt:   t.updatedAt,
+	}
+}
+
+type boardOrder struct {
+	tickets []*boardTicket
+	// талоны, которые еще не выданы
+	pending int
+	started bool
+	// закрывается, когда выданы все талоны заказа
+	done     chan struct{}
+	progress func(Ticket, string)
+	onStart  func()
+}
+
+// change - смена статуса талона, о которой нужно сообщить после снятия блокировки
+type change struct {
+	ticket  Ticket
+	status  string
+	view    TicketView
+	order   *boardOrder
+	started bool
+}
+
+// Board - доска кухни (KDS): живая очередь талонов по станциям. Повара принимают талон (accept),
+// начинают готовить (start), выдают (bump) и возвращают выданный талон на доработку (recall).
+// Заказ готов, когда выданы все его талоны. В режиме автоматической готовки доска сама проводит
+// талоны по статусам за время приготовления из меню.
+//
+// Очередь упорядочена не по времени поступления, а по срочности: время ожидания талона плюс
+// priorityStep за каждый уровень приоритета заказа. Приоритетный заказ обгоняет обычные,
+// но обычный заказ, прождавший дольше, чем дает приоритет, снова оказывается впереди
+type Board struct {
+	menu         *Menu
+	autoCook     bool
+	priorityStep time.Duration
+
+	mu      sync.Mutex
+	tickets map[string]*boardTicket
+	cooking map[string]int
+	// закрывается и пересоздается, когда на станции меняется занятость или очередь
+	freed       chan struct{}
+	subscribers map[chan TicketView]struct{}
+}
+
+func NewBoard(menu *Menu, autoCook bool, priorityStep time.Duration) *Board {
+	return &Board{
+		menu:         menu,
+		autoCook:     autoCook,
+		priorityStep: priorityStep,
+		tickets:      make(map[string]*boardTicket),
+		cooking:      make(map[string]int),
+		freed:        make(chan struct{}),
+		subscribers:  make(map[chan TicketView]struct{}),
+	}
+}
+
+// Cook выставляет талоны заказа на доску и ждет, пока все они будут выданы. progress вызывается
+// при каждой смене статуса талона, onStart - когда начал готовиться первый талон.
+// Возвращает false, если заказ отменили
+func (b *Board) Cook(tickets []Ticket, cancelled <-chan struct{}, progress func(Ticket, string), onStart func()) bool {
+	order := &boardOrder{pending: len(tickets), done: make(chan struct{}), progress: progress, onStart: onStart}
+	if len(tickets) == 0 {
+		return true
+	}
+
+	now := time.Now()
+	var changes []change
+	b.mu.Lock()
+	for _, t := range tickets {
+		bt := &boardTicket{Ticket: t, status: TicketQueued, createdAt: now, updatedAt: now, order: order}
+		order.tickets = append(order.tickets, bt)
+		b.tickets[t.ID] = bt
+		changes = append(changes, change{ticket: t, status: TicketQueued, view: bt.view(), order: order})
+	}
+	b.mu.Unlock()
+	b.notify(changes...)
+
+	if b.autoCook {
+		for _, t := range tickets {
+			go b.autoCookTicket(t.ID, cancelled)
+		}
+	}
+
+	select {
+	case <-order.done:
+		return true
+	case <-cancelled:
+		b.cancel(order)
+		return false
+	}
+}
+
+// Accept отмечает, что повар увидел талон
+func (b *Board) Accept(id string) (TicketView, error) {
+	return b.transition(id, func(t *boardTicket) error {
+		if t.status != TicketQueued {
+			return errTicketState
+		}
+		t.status = TicketAccepted
+		return nil
+	})
+}
+
+// Start начинает приготовление талона, если на станции есть место
+func (b *Board) Start(id string) (TicketView, error) {
+	return b.transition(id, func(t *boardTicket) error {
+		if t.status != TicketQueued && t.status != TicketAccepted {
+			return errTicketState
+		}
+		return b.startLocked(t)
+	})
+}
+
+// Bump выдает приготовленный талон
+func (b *Board) Bump(id string) (TicketView, error) {
+	return b.transition(id, func(t *boardTicket) error {
+		if t.status != TicketCooking {
+			return errTicketState
+		}
+		b.releaseLocked(t.Station)
+		t.status = TicketDone
+		t.order.pending--
+		return nil
+	})
+}
+
+// Recall возвращает выданный талон на доработку, пока заказ не отправлен целиком
+func (b *Board) Recall(id string) (TicketView, error) {
+	return b.transition(id, func(t *boardTicket) error {
+		if t.status != TicketDone {
+			return errTicketState
+		}
+		if err := b.startLocked(t); err != nil {
+			return err
+		}
+		t.order.pending++
+		return nil
+	})
+}
+
+// Queue возвращает талоны станции (или всех станций, если station пустой), самые срочные первыми
+func (b *Board) Queue(station string) []TicketView {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tickets := make([]*boardTicket, 0, len(b.tickets))
+	for _, t := range b.tickets {
+		if station == "" || t.Station == station {
+			tickets = append(tickets, t)
+		}
+	}
+	b.sortByUrgency(tickets, time.Now())
+
+	views := make([]TicketView, len(tickets))
+	for i, t := range tickets {
+		views[i] = t.view()
+	}
+	return views
+}
+
+// urgency - срочность талона: время ожидания плюс бонус за приоритет заказа
+func (b *Board) urgency(t *boardTicket, now time.Time) time.Duration {
+	return now.Sub(t.createdAt) + time.Duration(t.Priority)*b.priorityStep
+}
+
+func (b *Board) sortByUrgency(tickets []*boardTicket, now time.Time) {
+	sort.Slice(tickets, func(i, j int) bool {
+		ui, uj := b.urgency(tickets[i], now), b.urgency(tickets[j], now)
+		if ui != uj {
+			return ui > uj
+		}
+		return tickets[i].ID < tickets[j].ID
+	})
+}
+
+// nextLocked возвращает самый срочный талон станции из ожидающих начала приготовления.
+// Вызывается под блокировкой
+func (b *Board) nextLocked(station string) *boardTicket {
+	var waiting []*boardTicket
+	for _, t := range b.tickets {
+		if t.Station == station && (t.status == TicketQueued || t.status == TicketAccepted) {
+			waiting = append(waiting, t)
+		}
+	}
+	if len(waiting) == 0 {
+		return nil
+	}
+	b.sortByUrgency(waiting, time.Now())
+	return waiting[0]
+}
+
+// Stations возвращает загрузку станций
+func (b *Board) Stations() []StationView {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	waiting := make(map[string]int)
+	for _, t := range b.tickets {
+		if t.status == TicketQueued || t.status == TicketAccepted {
+			waiting[t.Station]++
+		}
+	}
+	views := make([]StationView, 0, len(b.menu.stations))
+	for name, s := range b.menu.stations {
+		views = append(views, StationView{
+			Name:       name,
+			Capacity:   s.Capacity,
+			MaxTickets: s.MaxTickets,
+			Cooking:    b.cooking[name],
+			Waiting:    waiting[name],
+		})
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+	return views
+}
+
+// Load оценивает загрузку кухни. Каждая станция готовит Capacity талонов одновременно, поэтому
+// освободится через сумму оставшегося времени ее талонов, деленную на Capacity. Для начатых талонов
+// учитывается только оставшееся время по меню
+func (b *Board) Load() Load {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	orders := make(map[*boardOrder]struct{})
+	remaining := make(map[string]time.Duration)
+	tickets := make(map[string]int)
+	for _, t := range b.tickets {
+		if t.status == TicketDone {
+			continue
+		}
+		orders[t.order] = struct{}{}
+		tickets[t.Station]++
+		left := t.PrepTime
+		if t.status == TicketCooking {
+			left = max(t.PrepTime-now.Sub(t.startedAt), 0)
+		}
+		remaining[t.Station] += left
+	}
+
+	load := Load{Orders: len(orders)}
+	for name, s := range b.menu.stations {
+		if wait := remaining[name] / time.Duration(s.Capacity); wait > load.EstimatedWait {
+			load.EstimatedWait = wait
+		}
+		if s.MaxTickets > 0 && tickets[name] >= s.MaxTickets {
+			load.BusyStations = append(load.BusyStations, name)
+		}
+	}
+	sort.Strings(load.BusyStations)
+	return load
+}
+
+// HasStation сообщает, есть ли станция в меню
+func (b *Board) HasStation(name string) bool {
+	_, ok := b.menu.stations[name]
+	return ok
+}
+
+// Subscribe подписывает на изменения талонов. Медленный подписчик пропускает изменения,
+// актуальное состояние всегда можно получить через Queue
+func (b *Board) Subscribe() (<-chan TicketView, func()) {
+	ch := make(chan TicketView, 64)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers, ch)
+		b.mu.Unlock()
+	}
+}
+
+// transition меняет статус талона под блокировкой и рассылает изменение
+func (b *Board) transition(id string, apply func(t *boardTicket) error) (TicketView, error) {
+	b.mu.Lock()
+	t, ok := b.tickets[id]
+	if !ok {
+		b.mu.Unlock()
+		return TicketView{}, errTicketNotFound
+	}
+	wasStarted := t.order.started
+	if err := apply(t); err != nil {
+		b.mu.Unlock()
+		return TicketView{}, err
+	}
+	t.updatedAt = time.Now()
+	view := t.view()
+	c := change{ticket: t.Ticket, status: t.status, view: view, order: t.order, started: !wasStarted && t.order.started}
+
+	// заказ готов: снимаю его талоны с доски
+	completed := t.order.pending == 0
+	if completed {
+		for _, ot := range t.order.tickets {
+			delete(b.tickets, ot.ID)
+		}
+	}
+	b.mu.Unlock()
+
+	b.notify(c)
+	if completed {
+		close(t.order.done)
+	}
+	return view, nil
+}
+
+// startLocked занимает место на станции. Вызывается под блокировкой
+func (b *Board) startLocked(t *boardTicket) error {
+	if b.cooking[t.Station] >= b.menu.stations[t.Station].Capacity {
+		return errStationBusy
+	}
+	b.cooking[t.Station]++
+	t.status = TicketCooking
+	t.startedAt = time.Now()
+	t.order.started = true
+	// следующий по срочности талон станции может занять оставшееся место
+	b.wakeLocked()
+	return nil
+}
+
+// releaseLocked освобождает место на станции. Вызывается под блокировкой
+func (b *Board) releaseLocked(station string) {
+	b.cooking[station]--
+	b.wakeLocked()
+}
+
+// wakeLocked будит ожидающих места на станции. Вызывается под блокировкой
+func (b *Board) wakeLocked() {
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
+
+// cancel снимает с доски талоны отмененного заказа
+func (b *Board) cancel(order *boardOrder) {
+	var changes []change
+	b.mu.Lock()
+	for _, t := range order.tickets {
+		if _, ok := b.tickets[t.ID]; !ok {
+			continue
+		}
+		if t.status == TicketCooking {
+			b.releaseLocked(t.Station)
+		}
+		t.status = TicketCancelled
+		t.updatedAt = time.Now()
+		delete(b.tickets, t.ID)
+		// снятый талон мог быть первым в очереди станции
+		b.wakeLocked()
+		changes = append(changes, change{ticket: t.Ticket, status: t.status, view: t.view(), order: order})
+	}
+	b.mu.Unlock()
+	b.notify(changes...)
+}
+
+// notify сообщает о смене статусов в Kafka и подписчикам доски
+func (b *Board) notify(changes ...change) {
+	for _, c := range changes {
+		if c.started && c.order.onStart != nil {
+			c.order.onStart()
+		}
+		if c.order.progress != nil {
+			c.order.progress(c.ticket, c.status)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		for _, c := range changes {
+			select {
+			case ch <- c.view:
+			default:
+			}
+		}
+	}
+}
+
+// autoCookTicket проводит талон по статусам без участия повара: ждет, пока на станции освободится
+// место и талон станет самым срочным из ожидающих, готовит его время приготовления и выдает.
+// Действия повара через KDS имеют приоритет
+func (b *Board) autoCookTicket(id string, cancelled <-chan struct{}) {
+	for {
+		b.mu.Lock()
+		t, ok := b.tickets[id]
+		if !ok {
+			b.mu.Unlock()
+			return
+		}
+		if t.status == TicketCooking ||
+			(b.cooking[t.Station] < b.menu.stations[t.Station].Capacity && b.nextLocked(t.Station) == t) {
+			b.mu.Unlock()
+			break
+		}
+		freed := b.freed
+		b.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-cancelled:
+			return
+		}
+	}
+
+	// повар мог начать талон сам, тогда ошибка статуса ожидаема
+	if _, err := b.Start(id); err != nil && !errors.Is(err, errTicketState) {
+		go b.autoCookTicket(id, cancelled)
+		return
+	}
+
+	b.mu.Lock()
+	t, ok := b.tickets[id]
+	var prep time.Duration
+	if ok {
+		prep = t.PrepTime
+	}
+	b.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	select {
+	case <-time.After(prep):
+	case <-cancelled:
+		return
+	}
+	b.Bump(id)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Intake - очередь приема заказов перед доской кухни. На доске одновременно не больше capacity заказов,
+// остальные ждут здесь и попадают на доску по срочности, как и талоны на доске: время ожидания с момента
+// заказа плюс priorityStep за каждый уровень приоритета. Срочность всех ожидающих растет одинаково,
+// поэтому порядок задается постоянным ключом - моментом заказа минус бонус за приоритет, и обычный заказ,
+// прождавший дольше, чем дает приоритет, оказывается впереди приоритетного
+type Intake struct {
+	capacity     int
+	priorityStep time.Duration
+
+	mu      sync.Mutex
+	onBoard int
+	seq     uint64
+	waiting intakeHeap
+}
+
+// intakeEntry - заказ, ждущий места на доске
+type intakeEntry struct {
+	// момент заказа минус бонус за приоритет: чем раньше, тем срочнее
+	due time.Time
+	// порядок поступления для заказов с одинаковой срочностью
+	seq   uint64
+	ready chan struct{}
+}
+
+type intakeHeap []*intakeEntry
+
+func (h intakeHeap) Len() int { return len(h) }
+func (h intakeHeap) Less(i, j int) bool {
+	if !h[i].due.Equal(h[j].due) {
+		return h[i].due.Before(h[j].due)
+	}
+	return h[i].seq < h[j].seq
+}
+func (h intakeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *intakeHeap) Push(x any)   { *h = append(*h, x.(*intakeEntry)) }
+func (h *intakeHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// NewIntake создает очередь приема. capacity <= 0 - доска не ограничена и заказы не ждут
+func NewIntake(capacity int, priorityStep time.Duration) *Intake {
+	return &Intake{capacity: capacity, priorityStep: priorityStep}
+}
+
+// Acquire ждет места на доске для заказа, сделанного в момент at. После приготовления или отказа
+// от заказа место освобождается через Release
+func (in *Intake) Acquire(priority int, at time.Time) {
+	in.mu.Lock()
+	if in.capacity <= 0 || (in.onBoard < in.capacity && in.waiting.Len() == 0) {
+		in.onBoard++
+		in.mu.Unlock()
+		return
+	}
+	in.seq++
+	e := &intakeEntry{
+		due:   at.Add(-time.Duration(priority) * in.priorityStep),
+		seq:   in.seq,
+		ready: make(chan struct{}),
+	}
+	heap.Push(&in.waiting, e)
+	in.mu.Unlock()
+
+	<-e.ready
+}
+
+// Release освобождает место на доске и отдает его самому срочному из ожидающих заказов
+func (in *Intake) Release() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.onBoard--
+	for in.waiting.Len() > 0 && in.onBoard < in.capacity {
+		e := heap.Pop(&in.waiting).(*intakeEntry)
+		in.onBoard++
+		close(e.ready)
+	}
+}
+
+// IntakeStats - заполненность доски и очереди приема
+type IntakeStats struct {
+	OnBoard  int `json:"on_board"`
+	Capacity int `json:"capacity"`
+	Waiting  int `json:"waiting"`
+}
+
+// Stats возвращает число заказов на доске и в очереди приема
+func (in *Intake) Stats() IntakeStats {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return IntakeStats{OnBoard: in.onBoard, Capacity: in.capacity, Waiting: in.waiting.Len()}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestIntakeOrder(t *testing.T) {
+	const step = 5 * time.Minute
+	now := time.Now()
+
+	// заказ с приоритетом, сделанный ago назад
+	type waiting struct {
+		priority int
+		ago      time.Duration
+	}
+	tests := []struct {
+		name   string
+		orders []waiting
+		want   []int
+	}{
+		{
+			name:   "без приоритета - по времени заказа",
+			orders: []waiting{{0, time.Minute}, {0, 3 * time.Minute}, {0, 2 * time.Minute}},
+			want:   []int{1, 2, 0},
+		},
+		{
+			name:   "приоритетный обгоняет обычный",
+			orders: []waiting{{0, 2 * time.Minute}, {1, 0}},
+			want:   []int{1, 0},
+		},
+		{
+			// обычный заказ ждет 12 минут, приоритет 2 дает только 10
+			name:   "долгое ожидание перевешивает приоритет",
+			orders: []waiting{{2, 0}, {0, 12 * time.Minute}},
+			want:   []int{1, 0},
+		},
+		{
+			name:   "одинаковая срочность - по порядку поступления",
+			orders: []waiting{{1, 0}, {0, step}},
+			want:   []int{0, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := NewIntake(1, step)
+			// доска занята, все заказы теста ждут в очереди приема
+			in.Acquire(0, now)
+
+			admitted := make(chan int, len(tt.orders))
+			for i, o := range tt.orders {
+				go func() {
+					in.Acquire(o.priority, now.Add(-o.ago))
+					admitted <- i
+				}()
+				waitFor(t, func() bool { return in.Stats().Waiting == i+1 })
+			}
+
+			var got []int
+			for range tt.orders {
+				in.Release()
+				select {
+				case i := <-admitted:
+					got = append(got, i)
+				case <-time.After(time.Second):
+					t.Fatal("заказ не попал на доску после освобождения места")
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("порядок попадания на доску %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntakeCapacity(t *testing.T) {
+	in := NewIntake(2, time.Minute)
+	in.Acquire(0, time.Now())
+	in.Acquire(0, time.Now())
+
+	admitted := make(chan struct{})
+	go func() {
+		in.Acquire(0, time.Now())
+		close(admitted)
+	}()
+	waitFor(t, func() bool { return in.Stats().Waiting == 1 })
+	if got := in.Stats(); got.OnBoard != 2 {
+		t.Fatalf("на доске %d заказов, want 2", got.OnBoard)
+	}
+
+	in.Release()
+	select {
+	case <-admitted:
+	case <-time.After(time.Second):
+		t.Fatal("заказ не попал на доску после освобождения места")
+	}
+	if got := in.Stats(); got.OnBoard != 2 || got.Waiting != 0 {
+		t.Errorf("Stats() = %+v, want 2 на доске и пустую очередь", got)
+	}
+}
+
+func TestIntakeUnlimited(t *testing.T) {
+	in := NewIntake(0, time.Minute)
+	for range 10 {
+		in.Acquire(0, time.Now())
+	}
+	if got := in.Stats(); got.OnBoard != 10 || got.Waiting != 0 {
+		t.Errorf("Stats() = %+v, want 10 на доске без очереди", got)
+	}
+}
+
+// waitFor ждет, пока горутины теста дойдут до нужного состояния
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("не дождался состояния очереди приема")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
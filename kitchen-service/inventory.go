@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/sandrinasava/cafe-services/events"
+)
+
+var errUnknownIngredient = errors.New("ингредиент не найден")
+
+// IngredientView - запас ингредиента для экрана кухни
+type IngredientView struct {
+	Name     string  `json:"name"`
+	Unit     string  `json:"unit"`
+	Stock    float64 `json:"stock"`
+	LowStock float64 `json:"low_stock"`
+	Low      bool    `json:"low"`
+}
+
+// InventoryView - склад кухни и позиции меню, снятые с продажи
+type InventoryView struct {
+	Ingredients []IngredientView `json:"ingredients"`
+	// позиция меню -> ингредиенты, которых для нее не хватает
+	Unavailable map[string][]string `json:"unavailable"`
+}
+
+// Inventory ведет склад ингредиентов в Postgres. Кухня списывает ингредиенты по рецептам, когда
+// берет заказ в работу, предупреждает о заканчивающихся ингредиентах и снимает с продажи позиции,
+// для которых ингредиентов не хватает. Доступность позиций публикуется в kitchen_inventory,
+// по ней order-service отклоняет заказы заранее
+type Inventory struct {
+	db      *sql.DB
+	menu    *Menu
+	recipes map[string]map[string]float64
+	w       *kafka.Writer
+	enc     events.Encoding
+
+	mu sync.Mutex
+	// позиции, снятые с продажи: позиция -> недостающие ингредиенты
+	unavailable map[string][]string
+}
+
+func NewInventory(db *sql.DB, menu *Menu, w *kafka.Writer, enc events.Encoding) *Inventory {
+	return &Inventory{
+		db:          db,
+		menu:        menu,
+		recipes:     menu.Recipes(),
+		w:           w,
+		enc:         enc,
+		unavailable: make(map[string][]string),
+	}
+}
+
+// Init заводит на склад ингредиенты из меню с начальным запасом и публикует доступность всех позиций,
+// чтобы order-service знал актуальное меню после перезапуска кухни. Запас уже известных
+// ингредиентов не меняется, обновляются только единицы измерения и пороги
+func (inv *Inventory) Init(ctx context.Context) error {
+	for _, ing := range inv.menu.Ingredients() {
+		_, err := inv.db.ExecContext(ctx, `
+			INSERT INTO ingredients (name, unit, stock, low_stock)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (name) DO UPDATE SET unit = EXCLUDED.unit, low_stock = EXCLUDED.low_stock`,
+			ing.Name, ing.Unit, ing.InitialStock, ing.LowStock)
+		if err != nil {
+			return fmt.Errorf("не удалось завести ингредиент %s: %w", ing.Name, err)
+		}
+	}
+	return inv.refresh(ctx, true)
+}
+
+// Consume списывает ингредиенты на позиции заказа. Повторная обработка того же заказа
+// ничего не списывает. Запас не уходит в минус: заказ уже принят, кухня его готовит,
+// а нехватка видна по снятым с продажи позициям
+func (inv *Inventory) Consume(ctx context.Context, orderID string, items []string) error {
+	need := inv.menu.Consumption(items)
+
+	tx, err := inv.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO inventory_consumption (order_id) VALUES ($1) ON CONFLICT (order_id) DO NOTHING`, orderID)
+	if err != nil {
+		return fmt.Errorf("не удалось отметить списание по заказу: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return nil
+	}
+
+	// блокирую строки в одном порядке, чтобы параллельные заказы не ловили взаимоблокировку
+	names := make([]string, 0, len(need))
+	for name := range need {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var low []events.StockLow
+	for _, name := range names {
+		var (
+			unit            string
+			before, lowMark float64
+		)
+		err := tx.QueryRowContext(ctx,
+			`SELECT unit, stock, low_stock FROM ingredients WHERE name = $1 FOR UPDATE`, name).
+			Scan(&unit, &before, &lowMark)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("не удалось прочитать запас %s: %w", name, err)
+		}
+		after := max(before-need[name], 0)
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE ingredients SET stock = $2, updated_at = CURRENT_TIMESTAMP WHERE name = $1`, name, after); err != nil {
+			return fmt.Errorf("не удалось списать %s: %w", name, err)
+		}
+		// предупреждаю один раз, когда запас опускается до порога
+		if before > lowMark && after <= lowMark {
+			low = append(low, events.StockLow{Ingredient: name, Unit: unit, Stock: after, Threshold: lowMark})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, alert := range low {
+		alert.At = time.Now()
+		log.Printf("Заканчивается %s: осталось %g %s", alert.Ingredient, alert.Stock, alert.Unit)
+		if err := publish(inv.w, inv.enc, events.TypeStockLow, "", alert.Ingredient, alert); err != nil {
+			log.Printf("ошибка отправки предупреждения о запасе %s в брокер: %v", alert.Ingredient, err)
+		}
+	}
+	if len(need) == 0 {
+		return nil
+	}
+	// склад уже списан, ошибка пересчета доступности не должна приводить к повтору заказа
+	if err := inv.refresh(ctx, false); err != nil {
+		log.Printf("Не удалось обновить доступность позиций меню: %v", err)
+	}
+	return nil
+}
+
+// Restock пополняет запас ингредиента на add или устанавливает его равным set, если он задан,
+// и возвращает позиции в продажу, если ингредиентов снова хватает
+func (inv *Inventory) Restock(ctx context.Context, name string, add float64, set *float64) (IngredientView, error) {
+	query := `UPDATE ingredients SET stock = GREATEST(stock + $2, 0), updated_at = CURRENT_TIMESTAMP WHERE name = $1
+		RETURNING name, unit, stock, low_stock`
+	args := []interface{}{name, add}
+	if set != nil {
+		query = `UPDATE ingredients SET stock = GREATEST($2::numeric, 0), updated_at = CURRENT_TIMESTAMP WHERE name = $1
+			RETURNING name, unit, stock, low_stock`
+		args = []interface{}{name, *set}
+	}
+
+	var ing IngredientView
+	err := inv.db.QueryRowContext(ctx, query, args...).Scan(&ing.Name, &ing.Unit, &ing.Stock, &ing.LowStock)
+	if errors.Is(err, sql.ErrNoRows) {
+		return IngredientView{}, errUnknownIngredient
+	}
+	if err != nil {
+		return IngredientView{}, err
+	}
+	ing.Low = ing.Stock <= ing.LowStock
+
+	if err := inv.refresh(ctx, false); err != nil {
+		log.Printf("Не удалось обновить доступность позиций меню: %v", err)
+	}
+	return ing, nil
+}
+
+// View возвращает запасы склада и позиции, снятые с продажи
+func (inv *Inventory) View(ctx context.Context) (InventoryView, error) {
+	stock, err := inv.stock(ctx)
+	if err != nil {
+		return InventoryView{}, err
+	}
+	view := InventoryView{Ingredients: make([]IngredientView, 0, len(stock)), Unavailable: make(map[string][]string)}
+	for _, ing := range stock {
+		view.Ingredients = append(view.Ingredients, ing)
+	}
+	sort.Slice(view.Ingredients, func(i, j int) bool { return view.Ingredients[i].Name < view.Ingredients[j].Name })
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	for item, missing := range inv.unavailable {
+		view.Unavailable[item] = missing
+	}
+	return view, nil
+}
+
+func (inv *Inventory) stock(ctx context.Context) (map[string]IngredientView, error) {
+	rows, err := inv.db.QueryContext(ctx, `SELECT name, unit, stock, low_stock FROM ingredients`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stock := make(map[string]IngredientView)
+	for rows.Next() {
+		var ing IngredientView
+		if err := rows.Scan(&ing.Name, &ing.Unit, &ing.Stock, &ing.LowStock); err != nil {
+			return nil, err
+		}
+		ing.Low = ing.Stock <= ing.LowStock
+		stock[ing.Name] = ing
+	}
+	return stock, rows.Err()
+}
+
+// refresh пересчитывает доступность позиций по текущим запасам и публикует изменения,
+// all - опубликовать доступность всех позиций с рецептами
+func (inv *Inventory) refresh(ctx context.Context, all bool) error {
+	// под блокировкой, чтобы параллельные пересчеты не публиковали доступность не по порядку
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	stock, err := inv.stock(ctx)
+	if err != nil {
+		return err
+	}
+
+	for item, recipe := range inv.recipes {
+		var missing []string
+		for name, qty := range recipe {
+			if stock[name].Stock < qty {
+				missing = append(missing, name)
+			}
+		}
+		sort.Strings(missing)
+
+		prev, wasUnavailable := inv.unavailable[item]
+		changed := wasUnavailable != (missing != nil) || !slices.Equal(prev, missing)
+		if !all && !changed {
+			continue
+		}
+
+		payload := events.MenuAvailability{
+			Item:               item,
+			Available:          missing == nil,
+			MissingIngredients: missing,
+			At:                 time.Now(),
+		}
+		// ключ - позиция, чтобы изменения одной позиции читались по порядку. Если отправить не удалось,
+		// состояние не запоминается и изменение будет отправлено при следующем пересчете
+		if err := publish(inv.w, inv.enc, events.TypeMenuAvailability, "", item, payload); err != nil {
+			log.Printf("ошибка отправки доступности позиции %s в брокер: %v", item, err)
+			continue
+		}
+
+		if missing != nil {
+			inv.unavailable[item] = missing
+		} else {
+			delete(inv.unavailable, item)
+		}
+		if changed && missing != nil {
+			log.Printf("Позиция %s снята с продажи: не хватает %v", item, missing)
+		} else if changed {
+			log.Printf("Позиция %s снова в продаже", item)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// интервал, с которым в открытый поток отправляется ping, чтобы прокси не закрывали соединение
+const streamHeartbeat = 15 * time.Second
+
+// StationsHandler отдает загрузку станций кухни
+// GET /kds/stations
+func StationsHandler(board *Board) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, board.Stations())
+	}
+}
+
+// QueueHandler отдает живую очередь талонов станции в порядке срочности (ожидание плюс приоритет заказа),
+// без параметра station - талоны всех станций
+// GET /kds/queue?station=grill
+func QueueHandler(board *Board) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		station := r.URL.Query().Get("station")
+		if station != "" && !board.HasStation(station) {
+			http.Error(w, "Неизвестная станция", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, board.Queue(station))
+	}
+}
+
+// TicketActionHandler выполняет действие повара с талоном: accept, start, bump или recall
+// POST /kds/tickets/{id}/{action}
+func TicketActionHandler(board *Board) http.HandlerFunc {
+	actions := map[string]func(string) (TicketView, error){
+		"accept": board.Accept,
+		"start":  board.Start,
+		"bump":   board.Bump,
+		"recall": board.Recall,
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		action, ok := actions[r.PathValue("action")]
+		if !ok {
+			http.Error(w, "Неизвестное действие", http.StatusNotFound)
+			return
+		}
+
+		ticket, err := action(r.PathValue("id"))
+		switch {
+		case errors.Is(err, errTicketNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, errTicketState), errors.Is(err, errStationBusy):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, ticket)
+	}
+}
+
+// BoardStreamHandler отправляет изменения талонов по WebSocket. Первыми сообщениями приходит
+// текущая очередь, затем каждое изменение талона отдельным JSON-сообщением
+// GET /kds/ws?station=grill
+func BoardStreamHandler(ctx context.Context, board *Board) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		station := r.URL.Query().Get("station")
+		if station != "" && !board.HasStation(station) {
+			http.Error(w, "Неизвестная станция", http.StatusBadRequest)
+			return
+		}
+
+		// соединение после upgrade живет дольше таймаутов сервера
+		rc := http.NewResponseController(w)
+		if err := rc.SetReadDeadline(time.Time{}); err != nil {
+			log.Printf("Не удалось снять таймаут чтения для потока: %v", err)
+		}
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			log.Printf("Не удалось снять таймаут записи для потока: %v", err)
+		}
+
+		reqCtx := r.Context()
+		websocket.Server{Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			// подписываюсь до снимка очереди, чтобы не пропустить изменения между ними
+			updates, unsubscribe := board.Subscribe()
+			defer unsubscribe()
+
+			for _, ticket := range board.Queue(station) {
+				if err := websocket.JSON.Send(ws, ticket); err != nil {
+					return
+				}
+			}
+
+			// экран кухни ничего не отправляет, чтение нужно только чтобы заметить закрытие соединения
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				var discard []byte
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+			}()
+
+			heartbeat := time.NewTicker(streamHeartbeat)
+			defer heartbeat.Stop()
+			for {
+				select {
+				case <-reqCtx.Done():
+					return
+				case <-ctx.Done():
+					return
+				case <-closed:
+					return
+				case <-heartbeat.C:
+					ws.PayloadType = websocket.PingFrame
+					_, err := ws.Write(nil)
+					ws.PayloadType = websocket.TextFrame
+					if err != nil {
+						return
+					}
+				case ticket := <-updates:
+					if station != "" && ticket.Station != station {
+						continue
+					}
+					if err := websocket.JSON.Send(ws, ticket); err != nil {
+						return
+					}
+				}
+			}
+		}}.ServeHTTP(w, r)
+	}
+}
+
+// InventoryHandler отдает запасы склада и позиции меню, снятые с продажи
+// GET /kds/inventory
+func InventoryHandler(inv *Inventory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		view, err := inv.View(r.Context())
+		if err != nil {
+			log.Printf("Ошибка чтения склада: %v", err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, view)
+	}
+}
+
+// restockRequest - поставка ингредиента: add прибавляется к запасу, stock задает запас после инвентаризации
+type restockRequest struct {
+	Add   float64  `json:"add"`
+	Stock *float64 `json:"stock"`
+}
+
+// RestockHandler пополняет запас ингредиента
+// POST /kds/inventory/{ingredient}
+func RestockHandler(inv *Inventory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req restockRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Неправильный формат запроса", http.StatusBadRequest)
+			return
+		}
+		if req.Stock == nil && req.Add == 0 {
+			http.Error(w, "Нужно указать add или stock", http.StatusBadRequest)
+			return
+		}
+
+		ing, err := inv.Restock(r.Context(), r.PathValue("ingredient"), req.Add, req.Stock)
+		if errors.Is(err, errUnknownIngredient) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка пополнения склада: %v", err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, ing)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Ошибка записи ответа: %v", err)
+	}
+}
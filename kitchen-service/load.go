@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/events/retry"
+)
+
+// как часто пересчитывается загрузка кухни
+const loadCheckInterval = time.Second
+
+// pace следит за загрузкой кухни: пока какая-то станция перегружена, консьюмер заказов стоит на паузе
+// и заказы копятся в Kafka, а не на доске. О смене состояния kitchen_busy сообщается сразу,
+// в остальное время - раз в interval, чтобы order-service видел актуальную оценку ожидания
+func pace(ctx context.Context, board *Board, orders *retry.Consumer, w *kafka.Writer, enc events.Encoding, interval time.Duration) {
+	ticker := time.NewTicker(loadCheckInterval)
+	defer ticker.Stop()
+
+	var (
+		busy      bool
+		stations  []string
+		published time.Time
+	)
+	for {
+		load := board.Load()
+		nowBusy := len(load.BusyStations) > 0
+		changed := nowBusy != busy || !slices.Equal(stations, load.BusyStations)
+
+		if nowBusy && !busy {
+			orders.Pause()
+			log.Printf("Кухня перегружена (станции %v), прием новых заказов приостановлен", load.BusyStations)
+		} else if !nowBusy && busy {
+			orders.Resume()
+			log.Println("Загрузка кухни снизилась, прием новых заказов возобновлен")
+		}
+		busy, stations = nowBusy, load.BusyStations
+
+		if changed || time.Since(published) >= interval {
+			payload := events.KitchenBusy{
+				Busy:                 busy,
+				Orders:               load.Orders,
+				EstimatedWaitSeconds: int64(load.EstimatedWait.Seconds()),
+				BusyStations:         load.BusyStations,
+				At:                   time.Now(),
+			}
+			// ключ один на все сообщения, чтобы они попадали в один раздел и читались по порядку
+			if err := publish(w, enc, events.TypeKitchenBusy, "", producer, payload); err != nil {
+				log.Printf("ошибка отправки загрузки кухни в брокер: %v", err)
+			} else {
+				published = time.Now()
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -2,23 +2,26 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"database/sql"
+	"expvar"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
 	"time"
 
+	_ "github.com/lib/pq"
 	"github.com/segmentio/kafka-go"
+
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/events/retry"
 )
 
-type Order struct {
-	ID       string   `json:"id"`
-	Customer string   `json:"customer"`
-	Items    []string `json:"items"`
-	Status   string   `json:"status"`
-}
+// producer - имя сервиса в конвертах событий
+const producer = "kitchen-service"
 
 func main() {
 	//получаю конфиги
@@ -27,16 +30,37 @@ func main() {
 		log.Fatalf("Неудачная загрузка конфигураций: %v", err)
 	}
 
+	// сверяю схемы событий с реестром, несовместимые изменения не должны попасть в Kafka
+	if err := events.CheckSchemas(); err != nil {
+		log.Fatalf("Несовместимая схема событий: %v", err)
+	}
+	enc, err := events.ParseEncoding(cfg.Events.Encoding)
+	if err != nil {
+		log.Fatalf("Неудачная загрузка конфигураций: %v", err)
+	}
+
 	kafkaBroker := cfg.Kafka.Broker
 	topicIn := cfg.Kafka.TopicIn
 	topicOut := cfg.Kafka.TopicOut
+	topicEvents := cfg.Kafka.TopicEvents
+	topicStatus := cfg.Kafka.TopicStatus
+	topicTickets := cfg.Kafka.TopicTickets
+	topicLoad := cfg.Kafka.TopicLoad
+	topicStock := cfg.Kafka.TopicStock
 
-	//создаю консьюмера
-	kReader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: strings.Split(kafkaBroker, ","),
-		GroupID: "kitchen-group",
-		Topic:   topicIn,
-	})
+	// меню: на какой станции и сколько готовится каждая позиция
+	menu, err := LoadMenu(cfg.Kitchen.MenuFile)
+	if err != nil {
+		log.Fatalf("Не удалось загрузить меню: %v", err)
+	}
+	// доска кухни (KDS): талоны ведут повара, в режиме автоготовки - сама доска
+	board := NewBoard(menu, cfg.Kitchen.AutoCook, cfg.Kitchen.PriorityStep)
+	if cfg.JWT.SecretKey == "" {
+		log.Println("JWT_SECRET_KEY не задан, KDS API будет отклонять все запросы")
+	}
+
+	// очередь приема: заказы ждут места на доске и попадают на нее по срочности
+	intake := NewIntake(cfg.Kitchen.BoardOrders, cfg.Kitchen.PriorityStep)
 	//создаю продюсера
 	kWriter := kafka.NewWriter(kafka.WriterConfig{
 		Brokers:  []string{kafkaBroker},
@@ -44,84 +68,309 @@ func main() {
 		Balancer: &kafka.LeastBytes{},
 	})
 	defer kWriter.Close()
+	//создаю продюсера статусов заказов
+	statusWriter := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:  []string{kafkaBroker},
+		Topic:    topicStatus,
+		Balancer: &kafka.Hash{},
+	})
+	defer statusWriter.Close()
+	//создаю продюсера событий о ходе приготовления талонов
+	ticketsWriter := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:  []string{kafkaBroker},
+		Topic:    topicTickets,
+		Balancer: &kafka.Hash{},
+	})
+	defer ticketsWriter.Close()
+	//создаю продюсера сигналов о загрузке кухни
+	loadWriter := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:  []string{kafkaBroker},
+		Topic:    topicLoad,
+		Balancer: &kafka.Hash{},
+	})
+	defer loadWriter.Close()
+	//создаю продюсера доступности позиций меню и предупреждений о запасах
+	stockWriter := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:  []string{kafkaBroker},
+		Topic:    topicStock,
+		Balancer: &kafka.Hash{},
+	})
+	defer stockWriter.Close()
+
+	db, err := sql.Open("postgres", cfg.DB.DSN)
+	if err != nil {
+		log.Fatalf("неудачное соединение с бд: %v", err)
+	}
+	defer db.Close()
+
+	queue := NewQueue(db)
+
+	// склад ингредиентов: списание по рецептам и снятие закончившихся позиций с продажи
+	inventory := NewInventory(db, menu, stockWriter, enc)
+	initCtx, initCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := inventory.Init(initCtx); err != nil {
+		log.Fatalf("Не удалось загрузить склад: %v", err)
+	}
+	initCancel()
+
+	//создаю консьюмера заказов, неудачно обработанные сообщения уходят в топики повторов и DLQ
+	ordersConsumer := retry.NewConsumer(retry.Config{
+		Brokers:        strings.Split(kafkaBroker, ","),
+		Topic:          topicIn,
+		GroupID:        "kitchen-group",
+		Delays:         cfg.Retry.Delays,
+		MaxAttempts:    cfg.Retry.MaxAttempts,
+		CommitInterval: cfg.Kafka.CommitInterval,
+		Workers:        cfg.Workers.Count,
+		MaxInFlight:    cfg.Workers.MaxInFlight,
+	}, cookOrder(queue, intake, menu, inventory, board, kWriter, statusWriter, ticketsWriter, enc))
+	//создаю консьюмера событий об отмене и изменении заказов. Заказы готовящихся на экземпляре кухни хранятся
+	//в его памяти, поэтому у каждого экземпляра своя группа: отмену видят все экземпляры, а не один из них.
+	//Новая группа читает топик с конца: события, опубликованные до первого запуска экземпляра, он не увидит,
+	//отмены за это время учитываются по статусу заказа в бд. Имя экземпляра должно переживать перезапуск,
+	//иначе каждый запуск создает новую группу и теряет события, пришедшие, пока экземпляр был остановлен
+	instanceID := cfg.Kitchen.InstanceID
+	if instanceID == "" {
+		log.Fatalf("Не задан KITCHEN_INSTANCE_ID - постоянное имя экземпляра кухни")
+	}
+	eventsConsumer := retry.NewConsumer(retry.Config{
+		Brokers:        strings.Split(kafkaBroker, ","),
+		Topic:          topicEvents,
+		GroupID:        "kitchen-events-group-" + instanceID,
+		Delays:         cfg.Retry.Delays,
+		MaxAttempts:    cfg.Retry.MaxAttempts,
+		CommitInterval: cfg.Kafka.CommitInterval,
+		StartOffset:    kafka.LastOffset,
+	}, applyEvent(queue))
+
+	// метрики консьюмеров доступны на /debug/vars
+	expvar.Publish("orders_consumer", expvar.Func(func() any { return ordersConsumer.Stats() }))
+	expvar.Publish("events_consumer", expvar.Func(func() any { return eventsConsumer.Stats() }))
+	expvar.Publish("intake", expvar.Func(func() any { return intake.Stats() }))
+
+	// контекст открытых потоков KDS: закрывает их при остановке сервера
+	streamsCtx, streamsCancel := context.WithCancel(context.Background())
+	defer streamsCancel()
+
+	// регистрация маршрутов KDS
+	secret := cfg.JWT.SecretKey
+	http.HandleFunc("GET /kds/stations", staffOnly(secret, StationsHandler(board)))
+	http.HandleFunc("GET /kds/queue", staffOnly(secret, QueueHandler(board)))
+	http.HandleFunc("POST /kds/tickets/{id}/{action}", staffOnly(secret, TicketActionHandler(board)))
+	http.HandleFunc("GET /kds/ws", staffOnly(secret, BoardStreamHandler(streamsCtx, board)))
+	http.HandleFunc("GET /kds/inventory", staffOnly(secret, InventoryHandler(inventory)))
+	http.HandleFunc("POST /kds/inventory/{ingredient}", staffOnly(secret, RestockHandler(inventory)))
+
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%s", cfg.Server.Port),
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+	srv.RegisterOnShutdown(streamsCancel)
+	go func() {
+		log.Printf("Сервис кухни слушает на порту %s", cfg.Server.Port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("HTTP server failed: %v", err)
+		}
+	}()
+
 	//создаю канал, читающий сигналы ос
 	stop := make(chan os.Signal, 1)
 	//подписка на оповещение от ос о сигналах SIGINT(нажатие ctrl+c) и SIGTERM(завершение процесса)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
-	//создаю контекст для корректного завершения цикла при сигналах остановки
+	//создаю контекст для остановки чтения новых сообщений
 	readctx, readCancel := context.WithCancel(context.Background())
+	defer readCancel()
+
+	eventsDone := make(chan struct{})
 	go func() {
-		<-stop
-		readCancel()
+		eventsConsumer.Run(readctx)
+		close(eventsDone)
 	}()
-
+	ordersDone := make(chan struct{})
 	go func() {
-		for {
-			// читаю из брокера если ctx не отменен
-			m, err := kReader.ReadMessage(readctx)
-			if err != nil {
-				if readctx.Err() != nil {
-					break
-				}
-				log.Printf("неудачное чтение из брокера: %v", err)
-				time.Sleep(1 * time.Second) //задержка при повторной попытке чтения
-				continue
-			}
-			// достаю данные из сообщения и десериализую
-			var order Order
-			if err := json.Unmarshal(m.Value, &order); err != nil {
-				log.Printf("Failed to unmarshal message: %v", err)
-				continue
-			}
-
-			// Имитация приготовления заказа
-			time.Sleep(2 * time.Second)
-
-			order.Status = "ready"
-
-			//сериализация
-			message, err := json.Marshal(order)
-			if err != nil {
-				log.Printf("неудачная сериализация: %v", err)
-				continue
-			}
-			//отправка сообщения в брокер
-			err = kWriter.WriteMessages(context.Background(), kafka.Message{
-				Key:   []byte(order.ID),
-				Value: message,
-			})
-			if err != nil {
-				log.Printf("ошибка отправки сообщения в брокер: %v", err)
-				continue
-			}
-
-			log.Printf("Заказ %s готов", order.ID)
-		}
+		ordersConsumer.Run(readctx)
+		close(ordersDone)
 	}()
 
-	// Graceful Shutdown
+	// при перегрузке станций приостанавливаю прием заказов и сообщаю об этом order-service
+	go pace(readctx, board, ordersConsumer, loadWriter, enc, cfg.Kitchen.LoadInterval)
 
-	// Контекст с таймаутом для корректного завершения всех операций
-	shutdownctx, shutdowCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Graceful Shutdown
 
-	defer shutdowCancel()
 	// ожидание сигнала
 	<-stop
 
 	log.Println("Остановка Kitchen-service")
 
-	// закрытие консьюмера
-	if err := kReader.Close(); err != nil {
+	// Контекст с таймаутом для корректного завершения всех операций
+	shutdownctx, shutdowCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdowCancel()
+
+	// перестаю читать новые заказы и жду, пока кухня доготовит начатые
+	readCancel()
+	for _, done := range []chan struct{}{ordersDone, eventsDone} {
+		select {
+		case <-done:
+		case <-shutdownctx.Done():
+			log.Println("Не удалось дождаться завершения обработки заказов")
+		}
+	}
+
+	// метрики отдаю до конца обработки, затем останавливаю сервер
+	if err := srv.Shutdown(shutdownctx); err != nil {
+		log.Printf("Не удалось корректно остановить сервер: %v", err)
+	}
+
+	// закрытие консьюмеров, накопленные смещения отправляются в брокер при закрытии
+	if err := ordersConsumer.Close(); err != nil {
 		log.Printf("Не удалось закрыть консьюмера Kafka: %v", err)
 	}
+	if err := eventsConsumer.Close(); err != nil {
+		log.Printf("Не удалось закрыть консьюмера событий Kafka: %v", err)
+	}
 
-	// закрытие консьюмера
+	if err := statusWriter.Close(); err != nil {
+		log.Printf("Не удалось закрыть продюсера статусов Kafka: %v", err)
+	}
+	if err := ticketsWriter.Close(); err != nil {
+		log.Printf("Не удалось закрыть продюсера талонов Kafka: %v", err)
+	}
+	if err := loadWriter.Close(); err != nil {
+		log.Printf("Не удалось закрыть продюсера загрузки кухни Kafka: %v", err)
+	}
+	if err := stockWriter.Close(); err != nil {
+		log.Printf("Не удалось закрыть продюсера склада Kafka: %v", err)
+	}
+
+	// закрытие продюсера
 	if err := kWriter.Close(); err != nil {
 		log.Printf("Не удалось закрыть продюсера Kafka: %v", err)
 	}
-	// Ожидание завершения всех операций
-	<-shutdownctx.Done()
-
 	log.Println("Kitchen-service остановлен")
 }
+
+// applyEvent учитывает отмену или изменение заказа. Битое событие повтор не исправит, оно сразу уходит в DLQ
+func applyEvent(queue *Queue) retry.Handler {
+	return func(ctx context.Context, m kafka.Message) error {
+		env, err := events.Decode(m)
+		if err != nil {
+			return retry.Permanent(err)
+		}
+		var event events.OrderChanged
+		if err := env.DecodePayload(&event); err != nil {
+			return retry.Permanent(fmt.Errorf("неудачная десериализация события: %w", err))
+		}
+		queue.Apply(env.Type, event)
+		log.Printf("Получено событие %s для заказа %s", env.Type, event.OrderID)
+		return nil
+	}
+}
+
+// cookOrder ждет места на доске в очереди приема, делит заказ на талоны станций, выставляет их на доску кухни
+// и отправляет заказ в топик готовых заказов, когда выданы все талоны
+func cookOrder(queue *Queue, intake *Intake, menu *Menu, inventory *Inventory, board *Board, kWriter, statusWriter, ticketsWriter *kafka.Writer, enc events.Encoding) retry.Handler {
+	return func(ctx context.Context, m kafka.Message) error {
+		// достаю данные из сообщения и десериализую
+		env, err := events.Decode(m)
+		if err != nil {
+			return retry.Permanent(err)
+		}
+		var order events.Order
+		if err := env.DecodePayload(&order); err != nil {
+			return retry.Permanent(fmt.Errorf("неудачная десериализация заказа: %w", err))
+		}
+
+		// срочность считается с момента заказа, поэтому заказ из топика повторов не теряет накопленное ожидание
+		orderedAt := env.OccurredAt
+		if orderedAt.IsZero() {
+			orderedAt = time.Now()
+		}
+		intake.Acquire(order.Priority, orderedAt)
+		defer intake.Release()
+
+		// состав мог измениться, пока заказ ждал кухню: готовлю и списываю ингредиенты по новому
+		items, cancelled, ok, err := queue.Start(ctx, order.ID, order.Items)
+		if err != nil {
+			return fmt.Errorf("не удалось проверить статус заказа %s: %w", order.ID, err)
+		}
+		if !ok {
+			log.Printf("Заказ %s отменен до начала приготовления", order.ID)
+			return nil
+		}
+		order.Items = items
+
+		// заказ взят в работу: списываю ингредиенты со склада. При ошибке заказ уходит на повтор
+		if err := inventory.Consume(ctx, order.ID, order.Items); err != nil {
+			queue.Abort(order.ID)
+			return fmt.Errorf("не удалось списать ингредиенты по заказу %s: %w", order.ID, err)
+		}
+
+		tickets := menu.Split(order.ID, order.Items)
+		for i := range tickets {
+			tickets[i].Priority = order.Priority
+		}
+		progress := func(t Ticket, status string) {
+			publishTicket(ticketsWriter, enc, env.CorrelationID, t, status)
+		}
+		// заказ начинают готовить, когда повар берет в работу первый талон
+		onStart := func() {
+			publishStatus(statusWriter, enc, env.CorrelationID, order.ID, "cooking")
+		}
+		if !board.Cook(tickets, cancelled, progress, onStart) {
+			queue.Finish(order.ID)
+			log.Printf("Приготовление заказа %s остановлено: заказ отменен", order.ID)
+			return nil
+		}
+
+		queue.Finish(order.ID)
+		order.Status = "ready"
+
+		//отправка сообщения в брокер
+		if err := publish(kWriter, enc, events.TypeOrderReady, env.CorrelationID, order.ID, order); err != nil {
+			return fmt.Errorf("ошибка отправки сообщения в брокер: %w", err)
+		}
+
+		log.Printf("Заказ %s готов", order.ID)
+		return nil
+	}
+}
+
+// publish отправляет событие в брокер в конверте с тем же correlation ID, что и у исходного заказа
+func publish(w *kafka.Writer, enc events.Encoding, eventType, correlationID, key string, payload interface{}) error {
+	env, err := events.New(eventType, producer, correlationID, payload)
+	if err != nil {
+		return err
+	}
+	m, err := env.Message(key, enc)
+	if err != nil {
+		return err
+	}
+	return w.WriteMessages(context.Background(), m)
+}
+
+// publishStatus сообщает order-service о смене статуса заказа. Ошибка только логируется:
+// статус в order-service - информация для клиента и не должен останавливать кухню
+func publishStatus(w *kafka.Writer, enc events.Encoding, correlationID, orderID, status string) {
+	payload := events.StatusChanged{OrderID: orderID, Status: status, At: time.Now()}
+	if err := publish(w, enc, events.TypeStatusChanged, correlationID, orderID, payload); err != nil {
+		log.Printf("ошибка отправки статуса заказа %s в брокер: %v", orderID, err)
+	}
+}
+
+// publishTicket сообщает о смене статуса талона станции. Ошибка только логируется, как и для статусов заказа
+func publishTicket(w *kafka.Writer, enc events.Encoding, correlationID string, t Ticket, status string) {
+	payload := events.TicketProgress{
+		TicketID: t.ID,
+		OrderID:  t.OrderID,
+		Station:  t.Station,
+		Items:    t.Items,
+		Status:   status,
+		At:       time.Now(),
+	}
+	if err := publish(w, enc, events.TypeTicketProgress, correlationID, t.OrderID, payload); err != nil {
+		log.Printf("ошибка отправки статуса талона %s в брокер: %v", t.ID, err)
+	}
+}
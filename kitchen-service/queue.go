@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/sandrinasava/cafe-services/events"
+)
+
+// сколько хранить событие для заказа, который так и не дошел до кухни
+const eventTTL = time.Hour
+
+// статус отмененного заказа в бд order-service
+const orderCancelled = "cancelled"
+
+type pendingEvent struct {
+	cancelled bool
+	items     []string
+	at        time.Time
+}
+
+// Queue хранит отмены и изменения заказов, пришедшие раньше, чем кухня закончила их готовить.
+// События и сами заказы читаются из разных топиков, поэтому отмена может прийти как до,
+// так и во время приготовления. Экземпляр кухни видит только события, пришедшие после его запуска,
+// поэтому перед приготовлением статус и состав заказа сверяются с бд order-service
+type Queue struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	pending map[string]*pendingEvent
+	cooking map[string]chan struct{}
+}
+
+func NewQueue(db *sql.DB) *Queue {
+	return &Queue{
+		db:      db,
+		pending: make(map[string]*pendingEvent),
+		cooking: make(map[string]chan struct{}),
+	}
+}
+
+// Apply учитывает событие об изменении заказа. Изменение состава заказа, который уже на доске, пропускается:
+// взяв заказ, кухня прочитала состав из бд, а order-service после этого состав не меняет
+func (q *Queue) Apply(eventType string, event events.OrderChanged) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.prune()
+
+	_, onBoard := q.cooking[event.OrderID]
+	if eventType == events.TypeOrderUpdated && onBoard {
+		return
+	}
+
+	p, ok := q.pending[event.OrderID]
+	if !ok {
+		p = &pendingEvent{}
+		q.pending[event.OrderID] = p
+	}
+	p.at = time.Now()
+
+	switch eventType {
+	case events.TypeOrderCancelled:
+		p.cancelled = true
+		// прерываю приготовление, если заказ уже на плите
+		if onBoard {
+			close(q.cooking[event.OrderID])
+			delete(q.cooking, event.OrderID)
+		}
+	case events.TypeOrderUpdated:
+		p.items = event.Items
+	}
+}
+
+// Start отмечает начало приготовления заказа. Возвращает актуальный состав заказа и канал, который
+// закрывается при отмене заказа. Заказ помечается в бд взятым кухней: с этого момента order-service
+// не принимает изменения состава, поэтому прочитанный вместе с отметкой состав окончательный.
+// Если заказа в бд нет, состав берется из изменения, пришедшего раньше заказа, или items.
+// false - заказ уже отменен, в том числе пока экземпляр кухни был остановлен
+func (q *Queue) Start(ctx context.Context, id string, items []string) ([]string, <-chan struct{}, bool, error) {
+	var (
+		status  string
+		current []string
+	)
+	err := q.db.QueryRowContext(ctx,
+		`UPDATE orders SET kitchen_taken_at = COALESCE(kitchen_taken_at, CURRENT_TIMESTAMP)
+		WHERE order_UUID = $1
+		RETURNING status, items`, id).Scan(&status, pq.Array(&current))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, false, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	p, ok := q.pending[id]
+	if (ok && p.cancelled) || status == orderCancelled {
+		delete(q.pending, id)
+		return nil, nil, false, nil
+	}
+	if current != nil {
+		items = current
+	} else if ok && p.items != nil {
+		items = p.items
+	}
+	done := make(chan struct{})
+	q.cooking[id] = done
+	return items, done, true, nil
+}
+
+// Abort снимает заказ с приготовления, не трогая события по нему: заказ будет обработан повторно
+func (q *Queue) Abort(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.cooking, id)
+}
+
+// Finish снимает заказ с приготовления и забывает события по нему
+func (q *Queue) Finish(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.cooking, id)
+	delete(q.pending, id)
+}
+
+// prune удаляет устаревшие события. Вызывается под блокировкой
+func (q *Queue) prune() {
+	for id, p := range q.pending {
+		if time.Since(p.at) > eventTTL {
+			delete(q.pending, id)
+		}
+	}
+}
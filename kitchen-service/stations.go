@@ -0,0 +1,236 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// меню по умолчанию, если KITCHEN_MENU_FILE не задан
+//
+//go:embed menu.json
+var defaultMenu []byte
+
+// статусы талона станции
+const (
+	TicketQueued    = "queued"
+	TicketAccepted  = "accepted"
+	TicketCooking   = "cooking"
+	TicketDone      = "done"
+	TicketCancelled = "cancelled"
+)
+
+// Station - станция кухни: гриль, холодный цех, напитки или десерты.
+// Одновременно станция готовит не больше Capacity талонов, время приготовления
+// используется в режиме автоматической готовки
+type Station struct {
+	Name     string
+	Capacity int
+	// время приготовления позиции, которой нет в меню
+	PrepTime time.Duration
+	// сколько талонов может висеть на станции, при превышении кухня перестает брать новые заказы.
+	// 0 - без ограничения
+	MaxTickets int
+}
+
+type menuItem struct {
+	station  string
+	prepTime time.Duration
+	// сколько каждого ингредиента уходит на одну порцию
+	recipe map[string]float64
+}
+
+// Ingredient - ингредиент на складе кухни
+type Ingredient struct {
+	Name string
+	Unit string
+	// при таком запасе кухня предупреждает, что ингредиент пора пополнить
+	LowStock float64
+	// запас, с которым ингредиент заводится на склад при первом запуске
+	InitialStock float64
+}
+
+// Menu связывает позиции меню со станциями, временем приготовления и рецептами
+type Menu struct {
+	stations       map[string]*Station
+	items          map[string]menuItem
+	ingredients    map[string]*Ingredient
+	defaultStation string
+}
+
+// duration - время в формате time.ParseDuration ("2s", "1m30s")
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+type menuFile struct {
+	// станция для позиций, которых нет в меню
+	DefaultStation string `json:"default_station"`
+	Stations       map[string]struct {
+		Capacity   int      `json:"capacity"`
+		PrepTime   duration `json:"prep_time"`
+		MaxTickets int      `json:"max_tickets"`
+	} `json:"stations"`
+	Ingredients map[string]struct {
+		Unit         string  `json:"unit"`
+		LowStock     float64 `json:"low_stock"`
+		InitialStock float64 `json:"initial_stock"`
+	} `json:"ingredients"`
+	Items map[string]struct {
+		Station  string             `json:"station"`
+		PrepTime duration           `json:"prep_time"`
+		Recipe   map[string]float64 `json:"recipe"`
+	} `json:"items"`
+}
+
+// LoadMenu читает меню из файла path, пустой path - меню по умолчанию
+func LoadMenu(path string) (*Menu, error) {
+	data := defaultMenu
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var f menuFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("неправильный формат меню: %w", err)
+	}
+
+	m := &Menu{
+		stations:       make(map[string]*Station),
+		items:          make(map[string]menuItem),
+		ingredients:    make(map[string]*Ingredient),
+		defaultStation: f.DefaultStation,
+	}
+	for name, s := range f.Stations {
+		if s.Capacity < 1 {
+			return nil, fmt.Errorf("у станции %s должна быть хотя бы одна рабочая позиция", name)
+		}
+		m.stations[name] = &Station{
+			Name:       name,
+			Capacity:   s.Capacity,
+			PrepTime:   time.Duration(s.PrepTime),
+			MaxTickets: s.MaxTickets,
+		}
+	}
+	if _, ok := m.stations[m.defaultStation]; !ok {
+		return nil, fmt.Errorf("станция по умолчанию %q не описана в меню", m.defaultStation)
+	}
+	for name, ing := range f.Ingredients {
+		m.ingredients[name] = &Ingredient{
+			Name:         name,
+			Unit:         ing.Unit,
+			LowStock:     ing.LowStock,
+			InitialStock: ing.InitialStock,
+		}
+	}
+	for name, item := range f.Items {
+		if _, ok := m.stations[item.Station]; !ok {
+			return nil, fmt.Errorf("позиция %s ссылается на неизвестную станцию %s", name, item.Station)
+		}
+		for ing, qty := range item.Recipe {
+			if _, ok := m.ingredients[ing]; !ok {
+				return nil, fmt.Errorf("в рецепте позиции %s неизвестный ингредиент %s", name, ing)
+			}
+			if qty <= 0 {
+				return nil, fmt.Errorf("в рецепте позиции %s неположительное количество ингредиента %s", name, ing)
+			}
+		}
+		m.items[normalizeItem(name)] = menuItem{
+			station:  item.Station,
+			prepTime: time.Duration(item.PrepTime),
+			recipe:   item.Recipe,
+		}
+	}
+	return m, nil
+}
+
+// Ingredients возвращает ингредиенты склада, отсортированные по имени
+func (m *Menu) Ingredients() []Ingredient {
+	ingredients := make([]Ingredient, 0, len(m.ingredients))
+	for _, ing := range m.ingredients {
+		ingredients = append(ingredients, *ing)
+	}
+	sort.Slice(ingredients, func(i, j int) bool { return ingredients[i].Name < ingredients[j].Name })
+	return ingredients
+}
+
+// Recipes возвращает рецепты позиций меню. Позиции без рецепта не расходуют склад и не заканчиваются
+func (m *Menu) Recipes() map[string]map[string]float64 {
+	recipes := make(map[string]map[string]float64)
+	for name, item := range m.items {
+		if len(item.recipe) > 0 {
+			recipes[name] = item.recipe
+		}
+	}
+	return recipes
+}
+
+// Consumption считает, сколько каждого ингредиента уходит на позиции заказа
+func (m *Menu) Consumption(items []string) map[string]float64 {
+	need := make(map[string]float64)
+	for _, name := range items {
+		for ing, qty := range m.items[normalizeItem(name)].recipe {
+			need[ing] += qty
+		}
+	}
+	return need
+}
+
+func normalizeItem(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// Ticket - талон станции: позиции заказа, которые готовятся на одной станции
+type Ticket struct {
+	ID       string
+	OrderID  string
+	Station  string
+	Items    []string
+	PrepTime time.Duration
+	// приоритет заказа, см. events.Priority*
+	Priority int
+}
+
+// Split делит заказ на талоны по станциям. Станция готовит позиции талона одну за другой,
+// поэтому время талона - сумма времени его позиций
+func (m *Menu) Split(orderID string, items []string) []Ticket {
+	byStation := make(map[string]*Ticket)
+	for _, name := range items {
+		item, ok := m.items[normalizeItem(name)]
+		if !ok {
+			item = menuItem{station: m.defaultStation, prepTime: m.stations[m.defaultStation].PrepTime}
+		}
+		t, ok := byStation[item.station]
+		if !ok {
+			t = &Ticket{ID: orderID + "-" + item.station, OrderID: orderID, Station: item.station}
+			byStation[item.station] = t
+		}
+		t.Items = append(t.Items, name)
+		t.PrepTime += item.prepTime
+	}
+
+	tickets := make([]Ticket, 0, len(byStation))
+	for _, t := range byStation {
+		tickets = append(tickets, *t)
+	}
+	sort.Slice(tickets, func(i, j int) bool { return tickets[i].Station < tickets[j].Station })
+	return tickets
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// PreferencesHandler возвращает настройки уведомлений пользователя, без сохраненных настроек - настройки по умолчанию
+// GET /notifications/preferences
+func PreferencesHandler(db *sql.DB, def Preferences) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := LoadPreferences(r.Context(), db, userFrom(r.Context()), def)
+		if err != nil {
+			log.Printf("Ошибка получения настроек уведомлений: %v", err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	}
+}
+
+// UpdatePreferencesHandler сохраняет настройки уведомлений пользователя. Поля, которых нет в запросе,
+// остаются прежними
+// PUT /notifications/preferences {"language": "en", "email": true, "sms": true, "phone": "+79990000000", "statuses": ["ready", "delivered"]}
+func UpdatePreferencesHandler(db *sql.DB, templates *Templates, def Preferences) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := userFrom(r.Context())
+		p, err := LoadPreferences(r.Context(), db, userID, def)
+		if err != nil {
+			log.Printf("Ошибка получения настроек уведомлений: %v", err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := p.Validate(templates); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		p, err = SavePreferences(r.Context(), db, userID, p)
+		if err != nil {
+			log.Printf("Ошибка сохранения настроек уведомлений: %v", err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	}
+}
+
+// HealthHandler - процесс жив и отвечает на запросы
+// GET /healthz
+func HealthHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Ошибка записи ответа: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+// userKey - ключ идентификатора пользователя auth-service в контексте запроса
+type userKey struct{}
+
+// userOnly пропускает запрос, только если токен auth-service подписан ключом JWT_SECRET_KEY,
+// и кладет в контекст запроса идентификатор пользователя
+func userOnly(secret string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		userID, err := tokenUser(secret, token)
+		if err != nil {
+			log.Printf("Ошибка при валидации токена: %v", err)
+			http.Error(w, "Недействительный токен", http.StatusUnauthorized)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), userKey{}, userID)))
+	}
+}
+
+// userFrom возвращает идентификатор пользователя, которого пропустил userOnly
+func userFrom(ctx context.Context) uuid.UUID {
+	id, _ := ctx.Value(userKey{}).(uuid.UUID)
+	return id
+}
+
+// tokenUser проверяет подпись токена и возвращает идентификатор пользователя
+func tokenUser(secret, tokenString string) (uuid.UUID, error) {
+	if secret == "" {
+		return uuid.Nil, fmt.Errorf("JWT_SECRET_KEY не задан")
+	}
+	if tokenString == "" {
+		return uuid.Nil, fmt.Errorf("токен не предоставлен")
+	}
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("неожиданный метод подписи: %v", token.Header["alg"])
+		}
+		return []byte(secret), nil
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return uuid.Nil, fmt.Errorf("недействительный токен")
+	}
+	sub, _ := claims["sub"].(string)
+	userID, err := uuid.Parse(sub)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("в токене нет идентификатора пользователя")
+	}
+	return userID, nil
+}
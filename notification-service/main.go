@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/events/retry"
+)
+
+func main() {
+	//получаю конфиги
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Неудачная загрузка конфигураций: %v", err)
+	}
+	// сверяю схемы событий с реестром, чтобы читать события той же версии, что публикуют сервисы
+	if err := events.CheckSchemas(); err != nil {
+		log.Fatalf("Несовместимая схема событий: %v", err)
+	}
+	loc, err := time.LoadLocation(cfg.Notify.Timezone)
+	if err != nil {
+		log.Fatalf("Неудачная загрузка конфигураций: %v", err)
+	}
+	templates, err := NewTemplates(loc)
+	if err != nil {
+		log.Fatalf("Неудачная загрузка шаблонов уведомлений: %v", err)
+	}
+	defaults := DefaultPreferences(cfg.Notify.Language, cfg.Notify.Statuses)
+	if err := defaults.Validate(templates); err != nil {
+		log.Fatalf("Неудачная загрузка конфигураций: %v", err)
+	}
+	notifiers, err := newNotifiers(cfg)
+	if err != nil {
+		log.Fatalf("Неудачная загрузка конфигураций: %v", err)
+	}
+
+	db, err := sql.Open("postgres", cfg.DB.DSN)
+	if err != nil {
+		log.Fatalf("неудачное соединение с бд: %v", err)
+	}
+	defer db.Close()
+
+	notifications := NewNotifications(db, templates, notifiers, defaults)
+
+	//создаю консьюмеров готовых заказов и статусов, неудачно обработанные сообщения уходят в топики повторов и DLQ
+	var consumers []*retry.Consumer
+	for topic, group := range map[string]string{
+		cfg.Kafka.TopicReady:  "notification-ready-group",
+		cfg.Kafka.TopicStatus: "notification-status-group",
+	} {
+		consumers = append(consumers, retry.NewConsumer(retry.Config{
+			Brokers:        strings.Split(cfg.Kafka.Broker, ","),
+			Topic:          topic,
+			GroupID:        group,
+			Delays:         cfg.Retry.Delays,
+			MaxAttempts:    cfg.Retry.MaxAttempts,
+			CommitInterval: cfg.Kafka.CommitInterval,
+		}, notifyStatus(notifications)))
+	}
+
+	// регистрация маршрутов настроек уведомлений
+	secret := cfg.JWT.SecretKey
+	http.HandleFunc("GET /healthz", HealthHandler())
+	http.HandleFunc("GET /notifications/preferences", userOnly(secret, PreferencesHandler(db, defaults)))
+	http.HandleFunc("PUT /notifications/preferences", userOnly(secret, UpdatePreferencesHandler(db, templates, defaults)))
+
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%s", cfg.Server.Port),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+	go func() {
+		log.Printf("Сервис уведомлений слушает на порту %s", cfg.Server.Port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("HTTP server failed: %v", err)
+		}
+	}()
+
+	//создаю канал, читающий сигналы ос
+	stop := make(chan os.Signal, 1)
+	//подписка на оповещение от ос о сигналах SIGINT(нажатие ctrl+c) и SIGTERM(завершение процесса)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	//создаю контекст для остановки чтения новых сообщений
+	readctx, readCancel := context.WithCancel(context.Background())
+	defer readCancel()
+
+	consumed := make(chan struct{}, len(consumers))
+	for _, consumer := range consumers {
+		go func(consumer *retry.Consumer) {
+			consumer.Run(readctx)
+			consumed <- struct{}{}
+		}(consumer)
+	}
+
+	// Graceful Shutdown
+
+	// ожидание сигнала
+	<-stop
+
+	log.Println("Остановка Notification-service")
+
+	// Контекст с таймаутом для корректного завершения всех операций
+	shutdownctx, shutdowCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdowCancel()
+
+	// перестаю читать новые события и дожидаюсь отправки начатых уведомлений
+	readCancel()
+	for range consumers {
+		select {
+		case <-consumed:
+		case <-shutdownctx.Done():
+			log.Println("Не удалось дождаться отправки уведомлений")
+		}
+	}
+
+	if err := srv.Shutdown(shutdownctx); err != nil {
+		log.Printf("Не удалось корректно остановить сервер: %v", err)
+	}
+
+	// закрытие консьюмеров, накопленные смещения отправляются в брокер при закрытии
+	for _, consumer := range consumers {
+		if err := consumer.Close(); err != nil {
+			log.Printf("Не удалось закрыть консьюмера Kafka: %v", err)
+		}
+	}
+
+	// закрытие бд
+	if err := db.Close(); err != nil {
+		log.Printf("Не удалось закрыть соединение с базой данных: %v", err)
+	}
+	log.Println("Notification-service остановлен")
+}
+
+// newNotifiers создает отправителей настроенных каналов. С NOTIFY_FILE все каналы пишут в файл
+func newNotifiers(cfg *Config) (map[string]Notifier, error) {
+	if cfg.Notify.File != "" {
+		file := NewFileNotifier(cfg.Notify.File)
+		return map[string]Notifier{ChannelEmail: file, ChannelSMS: file, ChannelPush: file}, nil
+	}
+
+	notifiers := make(map[string]Notifier)
+	if cfg.SMTP.Addr != "" {
+		email, err := NewEmailNotifier(cfg.SMTP.Addr, cfg.SMTP.User, cfg.SMTP.Password, cfg.SMTP.From, cfg.Notify.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		notifiers[ChannelEmail] = email
+	}
+	if cfg.SMS.URL != "" {
+		notifiers[ChannelSMS] = NewSMSNotifier(cfg.SMS.URL, cfg.SMS.APIKey, cfg.SMS.Secret, cfg.Notify.Timeout)
+	}
+	if cfg.Push.URL != "" {
+		notifiers[ChannelPush] = NewPushNotifier(cfg.Push.URL, cfg.Push.APIKey, cfg.Push.Secret, cfg.Notify.Timeout)
+	}
+	if len(notifiers) == 0 {
+		log.Println("Ни один канал уведомлений не настроен, уведомления не отправляются")
+	}
+	return notifiers, nil
+}
+
+// notifyStatus сообщает клиенту о статусе заказа: о готовности из ready_orders и об остальных статусах
+// из order_status. Прочие события пропускаются
+func notifyStatus(notifications *Notifications) retry.Handler {
+	return func(ctx context.Context, m kafka.Message) error {
+		env, err := events.Decode(m)
+		if err != nil {
+			return retry.Permanent(err)
+		}
+		var orderID, status string
+		switch env.Type {
+		case events.TypeOrderReady:
+			var order events.Order
+			if err := env.DecodePayload(&order); err != nil {
+				return retry.Permanent(fmt.Errorf("неудачная десериализация сообщения: %w", err))
+			}
+			orderID, status = order.ID, StatusReady
+		case events.TypeStatusChanged:
+			var changed events.StatusChanged
+			if err := env.DecodePayload(&changed); err != nil {
+				return retry.Permanent(fmt.Errorf("неудачная десериализация сообщения: %w", err))
+			}
+			orderID, status = changed.OrderID, changed.Status
+		default:
+			return nil
+		}
+		id, err := uuid.Parse(orderID)
+		if err != nil {
+			return retry.Permanent(err)
+		}
+		return notifications.Notify(ctx, env.ID, id, status)
+	}
+}
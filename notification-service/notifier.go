@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+// Message - уведомление клиенту по одному каналу
+type Message struct {
+	// событие о статусе заказа, по которому отправляется уведомление
+	EventID string
+	Channel string
+	// адрес получателя в канале: почта, телефон или push-токен устройства
+	To      string
+	UserID  uuid.UUID
+	OrderID uuid.UUID
+	Status  string
+	Subject string
+	Body    string
+}
+
+// Notifier отправляет уведомления по каналу: письмом, через вебхук SMS-шлюза или push-сервиса, в файл
+type Notifier interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// Notifications рассылает клиенту уведомление о статусе заказа по каналам, которые он выбрал
+type Notifications struct {
+	db        *sql.DB
+	templates *Templates
+	// отправитель каждого канала. Каналы, для которых он не настроен, пропускаются
+	notifiers map[string]Notifier
+	defaults  Preferences
+}
+
+func NewNotifications(db *sql.DB, templates *Templates, notifiers map[string]Notifier, defaults Preferences) *Notifications {
+	return &Notifications{db: db, templates: templates, notifiers: notifiers, defaults: defaults}
+}
+
+// Notify сообщает клиенту о статусе заказа из события eventID. По каждому каналу уведомление о событии
+// отправляется один раз: отправка отмечается в notification_log, а при ошибке отметка снимается
+// и событие обрабатывается повторно только для неотправленных каналов
+func (n *Notifications) Notify(ctx context.Context, eventID string, orderID uuid.UUID, status string) error {
+	r, ok, err := LoadRecipient(ctx, n.db, orderID, n.defaults)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		log.Printf("Заказ %s не найден, уведомление о статусе %s не отправлено", orderID, status)
+		return nil
+	}
+	if !r.Wants(status) || !n.templates.Has(r.Language, status) {
+		return nil
+	}
+	subject, body, err := n.templates.Render(r, orderID, status)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for channel, to := range r.Channels() {
+		notifier, ok := n.notifiers[channel]
+		if !ok {
+			continue
+		}
+		m := Message{
+			EventID: eventID, Channel: channel, To: to,
+			UserID: r.UserID, OrderID: orderID, Status: status,
+			Subject: subject, Body: body,
+		}
+		if err := n.send(ctx, notifier, m); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", channel, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// send отправляет уведомление, если оно еще не отправлялось по этому каналу
+func (n *Notifications) send(ctx context.Context, notifier Notifier, m Message) error {
+	res, err := n.db.ExecContext(ctx, `
+		INSERT INTO notification_log (event_id, channel, order_UUID, user_UUID, status)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (event_id, channel) DO NOTHING`,
+		m.EventID, m.Channel, m.OrderID, m.UserID, m.Status)
+	if err != nil {
+		return err
+	}
+	if inserted, err := res.RowsAffected(); err != nil || inserted == 0 {
+		return err
+	}
+
+	if err := notifier.Send(ctx, m); err != nil {
+		if _, delErr := n.db.ExecContext(ctx,
+			`DELETE FROM notification_log WHERE event_id = $1 AND channel = $2`, m.EventID, m.Channel); delErr != nil {
+			log.Printf("Не удалось снять отметку об уведомлении %s/%s: %v", m.EventID, m.Channel, delErr)
+		}
+		return err
+	}
+	log.Printf("Клиенту %s отправлено уведомление (%s) о заказе %s: %s", m.UserID, m.Channel, m.OrderID, m.Status)
+	return nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// заголовок с подписью тела запроса к вебхуку: hex(HMAC-SHA256(secret, тело запроса))
+const signatureHeader = "X-Signature"
+
+// EmailNotifier отправляет письма через SMTP-сервер. Если сервер поддерживает STARTTLS, соединение шифруется
+type EmailNotifier struct {
+	addr     string
+	user     string
+	password string
+	from     *mail.Address
+	timeout  time.Duration
+}
+
+func NewEmailNotifier(addr, user, password, from string, timeout time.Duration) (*EmailNotifier, error) {
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("некорректный SMTP_FROM: %w", err)
+	}
+	return &EmailNotifier{addr: addr, user: user, password: password, from: sender, timeout: timeout}, nil
+}
+
+func (e *EmailNotifier) Send(ctx context.Context, m Message) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", e.addr)
+	if err != nil {
+		return fmt.Errorf("почтовый сервер недоступен: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	host, _, _ := net.SplitHostPort(e.addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if e.user != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.user, e.password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(e.from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(m.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(e.compose(m)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// compose собирает письмо в UTF-8, тема кодируется по RFC 2047
+func (e *EmailNotifier) compose(m Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", e.from.String())
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(m.Body)
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// WebhookNotifier передает уведомление внешнему сервису POST-запросом с JSON: SMS-шлюзу {"to", "text"},
+// push-сервису {"token", "title", "body", "data": {"order_id", "status"}}. Ответ 2xx - уведомление принято
+type WebhookNotifier struct {
+	url     string
+	apiKey  string
+	secret  []byte
+	client  *http.Client
+	payload func(m Message) interface{}
+}
+
+func NewSMSNotifier(url, apiKey, secret string, timeout time.Duration) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		apiKey: apiKey,
+		secret: []byte(secret),
+		client: &http.Client{Timeout: timeout},
+		payload: func(m Message) interface{} {
+			return map[string]string{"to": m.To, "text": m.Body}
+		},
+	}
+}
+
+func NewPushNotifier(url, apiKey, secret string, timeout time.Duration) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		apiKey: apiKey,
+		secret: []byte(secret),
+		client: &http.Client{Timeout: timeout},
+		payload: func(m Message) interface{} {
+			return map[string]interface{}{
+				"token": m.To,
+				"title": m.Subject,
+				"body":  m.Body,
+				"data":  map[string]string{"order_id": m.OrderID.String(), "status": m.Status},
+			}
+		},
+	}
+}
+
+func (w *WebhookNotifier) Send(ctx context.Context, m Message) error {
+	body, err := json.Marshal(w.payload(m))
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	// повтор того же уведомления сервис может распознать по ключу
+	r.Header.Set("Idempotency-Key", m.EventID+"/"+m.Channel)
+	if w.apiKey != "" {
+		r.Header.Set("Authorization", "Bearer "+w.apiKey)
+	}
+	if len(w.secret) > 0 {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(body)
+		r.Header.Set(signatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(r)
+	if err != nil {
+		return fmt.Errorf("вебхук %s недоступен: %w", m.Channel, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("вебхук %s ответил %d: %s", m.Channel, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// FileNotifier дописывает уведомления всех каналов в файл по строке JSON - для локальной проверки без почты и шлюзов
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (f *FileNotifier) Send(ctx context.Context, m Message) error {
+	line, err := json.Marshal(struct {
+		At      time.Time `json:"at"`
+		Channel string    `json:"channel"`
+		To      string    `json:"to"`
+		OrderID string    `json:"order_id"`
+		Status  string    `json:"status"`
+		Subject string    `json:"subject,omitempty"`
+		Body    string    `json:"body"`
+	}{time.Now(), m.Channel, m.To, m.OrderID.String(), m.Status, m.Subject, m.Body})
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Каналы уведомлений
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+	ChannelPush  = "push"
+)
+
+var (
+	errLanguage  = errors.New("неизвестный язык: ожидается ru или en")
+	errStatus    = errors.New("о таком статусе заказа уведомления не отправляются")
+	errNoPhone   = errors.New("для SMS нужен номер телефона")
+	errPhone     = errors.New("некорректный номер телефона: ожидается от 10 до 15 цифр, можно с +")
+	errNoPushKey = errors.New("для push-уведомлений нужен push_token устройства")
+)
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9]{10,15}$`)
+
+// Preferences - настройки уведомлений клиента: язык, каналы и статусы заказа, о которых сообщать
+type Preferences struct {
+	Language  string     `json:"language"`
+	Email     bool       `json:"email"`
+	SMS       bool       `json:"sms"`
+	Push      bool       `json:"push"`
+	Phone     string     `json:"phone,omitempty"`
+	PushToken string     `json:"push_token,omitempty"`
+	Statuses  []string   `json:"statuses"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// DefaultPreferences - настройки клиента, который их не менял: письма о статусах из NOTIFY_DEFAULT_STATUSES
+func DefaultPreferences(language string, statuses []string) Preferences {
+	return Preferences{Language: language, Email: true, Statuses: statuses}
+}
+
+// Validate проверяет настройки: язык и статусы должны быть из шаблонов, для SMS и push нужны адреса
+func (p Preferences) Validate(t *Templates) error {
+	if !t.HasLanguage(p.Language) {
+		return fmt.Errorf("%w: %q", errLanguage, p.Language)
+	}
+	for _, status := range p.Statuses {
+		if !t.Has(p.Language, status) {
+			return fmt.Errorf("%w: %q", errStatus, status)
+		}
+	}
+	if p.SMS && p.Phone == "" {
+		return errNoPhone
+	}
+	if p.Phone != "" && !phonePattern.MatchString(p.Phone) {
+		return errPhone
+	}
+	if p.Push && p.PushToken == "" {
+		return errNoPushKey
+	}
+	return nil
+}
+
+// Recipient - кому и куда отправлять уведомление о заказе
+type Recipient struct {
+	UserID uuid.UUID
+	Name   string
+	// адрес почты из учетной записи
+	EmailAddr string
+	Pickup    bool
+	ETA       *time.Time
+	Preferences
+}
+
+// Channels возвращает включенные каналы и адрес получателя в каждом
+func (r Recipient) Channels() map[string]string {
+	to := make(map[string]string)
+	if r.Email && r.EmailAddr != "" {
+		to[ChannelEmail] = r.EmailAddr
+	}
+	if r.SMS && r.Phone != "" {
+		to[ChannelSMS] = r.Phone
+	}
+	if r.Push && r.PushToken != "" {
+		to[ChannelPush] = r.PushToken
+	}
+	return to
+}
+
+// Wants - хочет ли клиент знать о статусе заказа
+func (r Recipient) Wants(status string) bool {
+	return slices.Contains(r.Statuses, status)
+}
+
+// LoadPreferences возвращает настройки уведомлений пользователя, для пользователя без настроек - def
+func LoadPreferences(ctx context.Context, db *sql.DB, userID uuid.UUID, def Preferences) (Preferences, error) {
+	p := def
+	var (
+		phone, token sql.NullString
+		statuses     []string
+	)
+	err := db.QueryRowContext(ctx, `
+		SELECT language, email, sms, push, phone, push_token, statuses, updated_at
+		FROM notification_preferences WHERE user_UUID = $1`,
+		userID).Scan(&p.Language, &p.Email, &p.SMS, &p.Push, &phone, &token, pq.Array(&statuses), &p.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return def, nil
+	}
+	if err != nil {
+		return Preferences{}, err
+	}
+	p.Phone, p.PushToken = phone.String, token.String
+	if statuses != nil {
+		p.Statuses = statuses
+	}
+	return p, nil
+}
+
+// SavePreferences сохраняет настройки уведомлений пользователя
+func SavePreferences(ctx context.Context, db *sql.DB, userID uuid.UUID, p Preferences) (Preferences, error) {
+	if p.Statuses == nil {
+		p.Statuses = []string{}
+	}
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO notification_preferences (user_UUID, language, email, sms, push, phone, push_token, statuses)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), $8)
+		ON CONFLICT (user_UUID) DO UPDATE SET language = EXCLUDED.language, email = EXCLUDED.email,
+			sms = EXCLUDED.sms, push = EXCLUDED.push, phone = EXCLUDED.phone, push_token = EXCLUDED.push_token,
+			statuses = EXCLUDED.statuses, updated_at = now()
+		RETURNING updated_at`,
+		userID, p.Language, p.Email, p.SMS, p.Push, p.Phone, p.PushToken, pq.Array(p.Statuses)).Scan(&p.UpdatedAt)
+	return p, err
+}
+
+// LoadRecipient возвращает клиента, сделавшего заказ, с его настройками уведомлений. false - заказа нет
+func LoadRecipient(ctx context.Context, db *sql.DB, orderID uuid.UUID, def Preferences) (Recipient, bool, error) {
+	var (
+		r         Recipient
+		orderType string
+	)
+	err := db.QueryRowContext(ctx, `
+		SELECT o.user_UUID, u.username, u.email, o.order_type, o.eta
+		FROM orders o
+		JOIN users u ON u.user_UUID = o.user_UUID
+		WHERE o.order_UUID = $1`,
+		orderID).Scan(&r.UserID, &r.Name, &r.EmailAddr, &orderType, &r.ETA)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Recipient{}, false, nil
+	}
+	if err != nil {
+		return Recipient{}, false, err
+	}
+	r.Pickup = orderType == "pickup"
+	if r.Preferences, err = LoadPreferences(ctx, db, r.UserID, def); err != nil {
+		return Recipient{}, false, err
+	}
+	return r, true, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Статусы заказа, о которых сообщается клиенту
+const (
+	StatusCooking        = "cooking"
+	StatusReady          = "ready"
+	StatusDelivering     = "delivering"
+	StatusDelivered      = "delivered"
+	StatusFailedDelivery = "failed_delivery"
+	StatusReturned       = "returned"
+)
+
+// messageTemplate - тема и текст уведомления. Тема нужна только письму, SMS и push берут текст
+type messageTemplate struct {
+	Subject string
+	Body    string
+}
+
+// шаблоны уведомлений по языку и статусу заказа. В шаблонах доступны поля templateData
+var messageTemplates = map[string]map[string]messageTemplate{
+	"ru": {
+		StatusCooking: {
+			Subject: "Заказ {{.Order}} готовится",
+			Body:    "{{.Name}}, ваш заказ {{.Order}} уже готовится.",
+		},
+		StatusReady: {
+			Subject: "Заказ {{.Order}} готов",
+			Body: "{{.Name}}, ваш заказ {{.Order}} готов. " +
+				"{{if .Pickup}}Его можно забрать в ресторане.{{else}}Скоро его заберет курьер.{{end}}",
+		},
+		StatusDelivering: {
+			Subject: "Заказ {{.Order}} в пути",
+			Body:    "{{.Name}}, курьер везет ваш заказ {{.Order}}.{{if .ETA}} Он приедет примерно в {{.ETA}}.{{end}}",
+		},
+		StatusDelivered: {
+			Subject: "Заказ {{.Order}} {{if .Pickup}}выдан{{else}}доставлен{{end}}",
+			Body:    "{{.Name}}, заказ {{.Order}} {{if .Pickup}}выдан{{else}}доставлен{{end}}. Приятного аппетита!",
+		},
+		StatusFailedDelivery: {
+			Subject: "Не удалось доставить заказ {{.Order}}",
+			Body:    "{{.Name}}, курьеру не удалось передать вам заказ {{.Order}}. Мы свяжемся с вами.",
+		},
+		StatusReturned: {
+			Subject: "Заказ {{.Order}} вернулся в ресторан",
+			Body: "{{.Name}}, заказ {{.Order}} вернулся в ресторан. " +
+				"Если доставку можно повторить, курьер привезет его снова, иначе мы вернем деньги или начислим бонусы.",
+		},
+	},
+	"en": {
+		StatusCooking: {
+			Subject: "Order {{.Order}} is being prepared",
+			Body:    "{{.Name}}, your order {{.Order}} is being prepared.",
+		},
+		StatusReady: {
+			Subject: "Order {{.Order}} is ready",
+			Body: "{{.Name}}, your order {{.Order}} is ready. " +
+				"{{if .Pickup}}You can pick it up at the restaurant.{{else}}A courier will pick it up shortly.{{end}}",
+		},
+		StatusDelivering: {
+			Subject: "Order {{.Order}} is on its way",
+			Body:    "{{.Name}}, a courier is bringing your order {{.Order}}.{{if .ETA}} Expected arrival around {{.ETA}}.{{end}}",
+		},
+		StatusDelivered: {
+			Subject: "Order {{.Order}} {{if .Pickup}}collected{{else}}delivered{{end}}",
+			Body:    "{{.Name}}, your order {{.Order}} has been {{if .Pickup}}collected{{else}}delivered{{end}}. Enjoy your meal!",
+		},
+		StatusFailedDelivery: {
+			Subject: "We couldn't deliver order {{.Order}}",
+			Body:    "{{.Name}}, the courier couldn't hand over your order {{.Order}}. We will contact you.",
+		},
+		StatusReturned: {
+			Subject: "Order {{.Order}} returned to the restaurant",
+			Body: "{{.Name}}, your order {{.Order}} has returned to the restaurant. " +
+				"If delivery can be retried, a courier will bring it again; otherwise you will get a refund or credit.",
+		},
+	},
+}
+
+// templateData - данные для шаблона уведомления
+type templateData struct {
+	Name string
+	// короткий номер заказа - первые 8 символов идентификатора
+	Order  string
+	Pickup bool
+	// время прибытия курьера в часовом поясе NOTIFY_TIMEZONE, пусто - оценки нет
+	ETA string
+}
+
+type parsedTemplate struct {
+	subject, body *template.Template
+}
+
+// Templates - разобранные шаблоны уведомлений
+type Templates struct {
+	templates map[string]map[string]parsedTemplate
+	loc       *time.Location
+}
+
+// NewTemplates разбирает шаблоны, чтобы ошибка в шаблоне обнаружилась при запуске, а не при отправке
+func NewTemplates(loc *time.Location) (*Templates, error) {
+	t := &Templates{templates: make(map[string]map[string]parsedTemplate), loc: loc}
+	for lang, byStatus := range messageTemplates {
+		t.templates[lang] = make(map[string]parsedTemplate)
+		for status, mt := range byStatus {
+			subject, err := template.New(lang + "/" + status).Parse(mt.Subject)
+			if err != nil {
+				return nil, fmt.Errorf("шаблон %s/%s: %w", lang, status, err)
+			}
+			body, err := template.New(lang + "/" + status).Parse(mt.Body)
+			if err != nil {
+				return nil, fmt.Errorf("шаблон %s/%s: %w", lang, status, err)
+			}
+			t.templates[lang][status] = parsedTemplate{subject: subject, body: body}
+		}
+	}
+	return t, nil
+}
+
+// HasLanguage - есть ли шаблоны на языке lang
+func (t *Templates) HasLanguage(lang string) bool {
+	_, ok := t.templates[lang]
+	return ok
+}
+
+// Has - есть ли шаблон уведомления о статусе на языке lang
+func (t *Templates) Has(lang, status string) bool {
+	_, ok := t.templates[lang][status]
+	return ok
+}
+
+// Render возвращает тему и текст уведомления о статусе заказа на языке получателя
+func (t *Templates) Render(r Recipient, orderID uuid.UUID, status string) (subject, body string, err error) {
+	pt, ok := t.templates[r.Language][status]
+	if !ok {
+		return "", "", fmt.Errorf("нет шаблона уведомления %s/%s", r.Language, status)
+	}
+	data := templateData{Name: r.Name, Order: orderID.String()[:8], Pickup: r.Pickup}
+	if r.ETA != nil {
+		data.ETA = r.ETA.In(t.loc).Format("15:04")
+	}
+
+	var buf bytes.Buffer
+	if err := pt.subject.Execute(&buf, data); err != nil {
+		return "", "", err
+	}
+	subject = buf.String()
+	buf.Reset()
+	if err := pt.body.Execute(&buf, data); err != nil {
+		return "", "", err
+	}
+	return subject, buf.String(), nil
+}
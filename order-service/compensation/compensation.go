@@ -0,0 +1,118 @@
+// Package compensation выплачивает клиентам компенсации за заказы, которые так и не удалось доставить.
+// Служба доставки просит компенсацию событием compensation_requested: refund - вернуть деньги за заказ
+// вместе с доставкой, credit - начислить стоимость еды бонусами на счет клиента
+package compensation
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/events/retry"
+)
+
+// Consumer читает запросы компенсаций из топика delivery_events. Остальные события топика пропускаются.
+// Смещение фиксируется только после сохранения компенсации: при ошибке запрос уходит в топики повторов, затем в DLQ
+type Consumer struct {
+	db       *sql.DB
+	consumer *retry.Consumer
+}
+
+// NewConsumer создает консьюмера запросов компенсаций. Группа консьюмеров задается в пакете
+func NewConsumer(db *sql.DB, cfg retry.Config) *Consumer {
+	c := &Consumer{db: db}
+	cfg.GroupID = "order-compensation-group"
+	c.consumer = retry.NewConsumer(cfg, c.handle)
+	return c
+}
+
+// Run читает топик до отмены ctx
+func (c *Consumer) Run(ctx context.Context) {
+	c.consumer.Run(ctx)
+}
+
+// handle сохраняет компенсацию из запроса. Битый запрос повтор не исправит, он сразу уходит в DLQ
+func (c *Consumer) handle(ctx context.Context, m kafka.Message) error {
+	env, err := events.Decode(m)
+	if err != nil {
+		return retry.Permanent(err)
+	}
+	if env.Type != events.TypeCompensationRequested {
+		return nil
+	}
+	var req events.CompensationRequested
+	if err := env.DecodePayload(&req); err != nil {
+		return retry.Permanent(fmt.Errorf("неудачная десериализация запроса компенсации: %w", err))
+	}
+	if err := c.apply(ctx, req); err != nil {
+		return fmt.Errorf("ошибка компенсации заказа %s: %w", req.OrderID, err)
+	}
+	return nil
+}
+
+// apply сохраняет компенсацию и для credit пополняет бонусный счет клиента.
+// Повторный запрос по тому же заказу ничего не меняет
+func (c *Consumer) apply(ctx context.Context, req events.CompensationRequested) error {
+	orderID, err := uuid.Parse(req.OrderID)
+	if err != nil {
+		return retry.Permanent(err)
+	}
+	if req.Kind != events.CompensationRefund && req.Kind != events.CompensationCredit {
+		return retry.Permanent(fmt.Errorf("неизвестный вид компенсации %q", req.Kind))
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// при возврате денег возвращается и стоимость доставки, бонусами - только стоимость еды
+	var (
+		userID uuid.UUID
+		amount int64
+	)
+	err = tx.QueryRowContext(ctx, `
+		WITH o AS (
+			SELECT order_UUID, user_UUID, subtotal, delivery_fee FROM orders WHERE order_UUID = $1
+		), c AS (
+			INSERT INTO order_compensations (order_UUID, kind, reason, amount)
+			SELECT order_UUID, $2::text, $3::text, CASE WHEN $2::text = 'refund' THEN subtotal + delivery_fee ELSE subtotal END FROM o
+			ON CONFLICT (order_UUID) DO NOTHING
+			RETURNING amount
+		)
+		SELECT o.user_UUID, c.amount FROM o, c`,
+		orderID, req.Kind, req.Reason).Scan(&userID, &amount)
+	if errors.Is(err, sql.ErrNoRows) {
+		// заказ уже компенсирован или его нет
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if req.Kind == events.CompensationCredit && amount > 0 {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO customer_credits (user_UUID, balance) VALUES ($1, $2)
+			ON CONFLICT (user_UUID) DO UPDATE SET balance = customer_credits.balance + EXCLUDED.balance`,
+			userID, amount); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Заказ %s не доставлен (%s), компенсация %s: %d", orderID, req.Reason, req.Kind, amount)
+	return nil
+}
+
+// Close закрывает консьюмера, накопленные смещения отправляются в брокер
+func (c *Consumer) Close() error {
+	return c.consumer.Close()
+}
@@ -0,0 +1,99 @@
+// Package delivery хранит последнюю известную загрузку курьеров (courier_load) и оценивает по ней,
+// вместе с загрузкой кухни, когда заказ приедет к клиенту. Консьюмер читает загрузку из Kafka
+// и сохраняет ее в Redis, откуда ее читают все экземпляры order-service
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/sandrinasava/cafe-services/events"
+)
+
+const (
+	loadKey = "courier_load"
+	// служба доставки публикует загрузку не реже раза в DISPATCH_INTERVAL, более старые данные не учитываются
+	loadTTL = time.Minute
+)
+
+// Current возвращает последнюю загрузку курьеров. false - служба доставки давно не сообщала о загрузке
+func Current(ctx context.Context, rdb *redis.Client) (events.CourierLoad, bool, error) {
+	data, err := rdb.Get(ctx, loadKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return events.CourierLoad{}, false, nil
+	}
+	if err != nil {
+		return events.CourierLoad{}, false, err
+	}
+	var load events.CourierLoad
+	if err := json.Unmarshal(data, &load); err != nil {
+		return events.CourierLoad{}, false, err
+	}
+	return load, true, nil
+}
+
+// Consumer читает загрузку курьеров из топика delivery_events. Остальные события топика пропускаются
+type Consumer struct {
+	rdb    *redis.Client
+	reader *kafka.Reader
+}
+
+func NewConsumer(rdb *redis.Client, brokers []string, topic string) *Consumer {
+	return &Consumer{
+		rdb: rdb,
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: brokers,
+			GroupID: "order-courier-load-group",
+			Topic:   topic,
+		}),
+	}
+}
+
+// Run читает топик до отмены ctx
+func (c *Consumer) Run(ctx context.Context) {
+	for {
+		m, err := c.reader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("неудачное чтение загрузки курьеров из брокера: %v", err)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		env, err := events.Decode(m)
+		if err != nil {
+			log.Printf("неудачная десериализация загрузки курьеров: %v", err)
+			continue
+		}
+		if env.Type != events.TypeCourierLoad {
+			continue
+		}
+		var load events.CourierLoad
+		if err := env.DecodePayload(&load); err != nil {
+			log.Printf("неудачная десериализация загрузки курьеров: %v", err)
+			continue
+		}
+		if time.Since(load.At) > loadTTL {
+			continue
+		}
+
+		data, err := json.Marshal(load)
+		if err != nil {
+			continue
+		}
+		if err := c.rdb.Set(ctx, loadKey, data, loadTTL-time.Since(load.At)).Err(); err != nil {
+			log.Printf("Ошибка сохранения загрузки курьеров: %v", err)
+		}
+	}
+}
+
+func (c *Consumer) Close() error {
+	return c.reader.Close()
+}
@@ -0,0 +1,90 @@
+package delivery
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/sandrinasava/cafe-services/events/eta"
+	"github.com/sandrinasava/cafe-services/order-service/kitchen"
+	"github.com/sandrinasava/cafe-services/order-service/models"
+)
+
+// Estimator оценивает, когда заказ приедет к клиенту, а самовывоз - когда будет готов.
+// Оценка пересчитывается на каждом статусе заказа: пройденные этапы из нее выпадают
+type Estimator struct {
+	rdb        *redis.Client
+	model      eta.Model
+	restaurant eta.Point
+	prepTimes  map[string]time.Duration
+	// время приготовления позиции, которой нет в prepTimes
+	defaultPrep time.Duration
+}
+
+func NewEstimator(rdb *redis.Client, model eta.Model, restaurant eta.Point, prepTimes map[string]time.Duration, defaultPrep time.Duration) *Estimator {
+	return &Estimator{
+		rdb:         rdb,
+		model:       model,
+		restaurant:  restaurant,
+		prepTimes:   prepTimes,
+		defaultPrep: defaultPrep,
+	}
+}
+
+// Estimate возвращает оценку для заказа в статусе order.Status. false - оценки нет:
+// заказ уже доставлен или отменен, у доставки нет адреса, самовывоз уже готов
+func (e *Estimator) Estimate(ctx context.Context, order models.Order) (time.Time, bool) {
+	now := time.Now()
+	var est eta.Estimate
+
+	switch order.Status {
+	case models.StatusScheduled:
+		// предзаказ будет готов к назначенному времени
+		if order.ScheduledFor == nil {
+			return time.Time{}, false
+		}
+		now = *order.ScheduledFor
+	case models.StatusReceived:
+		if load, ok, err := kitchen.Current(ctx, e.rdb); err != nil {
+			log.Printf("Ошибка чтения загрузки кухни: %v", err)
+		} else if ok {
+			est.Kitchen = kitchen.EstimatedWait(load)
+		}
+		est.Prep = eta.Prep(order.Items, e.prepTimes, e.defaultPrep)
+	case models.StatusCooking:
+		est.Prep = eta.Prep(order.Items, e.prepTimes, e.defaultPrep)
+	case models.StatusReady, models.StatusDelivering:
+	default:
+		return time.Time{}, false
+	}
+
+	if order.Type == models.OrderTypePickup {
+		if order.Status == models.StatusReady || order.Status == models.StatusDelivering {
+			return time.Time{}, false
+		}
+		return est.Arrival(now), true
+	}
+	if order.Address == nil {
+		return time.Time{}, false
+	}
+
+	// курьер, который освободится раньше, чем заказ будет готов, ждать не заставит
+	if order.Status != models.StatusDelivering && order.Status != models.StatusScheduled {
+		if load, ok, err := Current(ctx, e.rdb); err != nil {
+			log.Printf("Ошибка чтения загрузки курьеров: %v", err)
+		} else if ok {
+			wait := time.Duration(load.EstimatedWaitSeconds) * time.Second
+			if cooking := est.Kitchen + est.Prep; wait > cooking {
+				est.Courier = wait - cooking
+			}
+		}
+	}
+
+	departure := now.Add(est.Kitchen + est.Prep + est.Courier)
+	dest := eta.Point{Lat: order.Address.Lat, Lon: order.Address.Lon}
+	est.Travel = e.model.Travel(e.restaurant, dest, departure)
+	est.Handover = e.model.Handover
+	return est.Arrival(now), true
+}
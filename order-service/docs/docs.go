@@ -15,6 +15,96 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/admin/orders": {
+            "get": {
+                "description": "Обработчик для поиска по всем заказам. Параметр q выполняет полнотекстовый поиск по товарам, имени клиента и статусу. Доступен только пользователям с ролью admin",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Поиск заказов администратором",
+                "operationId": "admin-orders-handler",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Поисковый запрос",
+                        "name": "q",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID клиента",
+                        "name": "customer",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Статус заказа",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Начало периода (RFC3339 или YYYY-MM-DD)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Конец периода, не включительно (RFC3339 или YYYY-MM-DD)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Размер страницы (по умолчанию 20, максимум 100)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Курсор следующей страницы из предыдущего ответа",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Страница заказов",
+                        "schema": {
+                            "$ref": "#/definitions/models.OrderPage"
+                        }
+                    },
+                    "400": {
+                        "description": "Неправильный запрос",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Недействительный токен",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Недостаточно прав",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
         "/login": {
             "post": {
                 "description": "Обработчик для авторизации пользователя по имени пользователя и паролю. Возвращает токен доступа при успешной аутентификации",
@@ -67,7 +157,7 @@ const docTemplate = `{
         },
         "/order": {
             "post": {
-                "description": "Обработчик для создания нового заказа",
+                "description": "Обработчик для создания нового заказа. Если указан scheduled_for, заказ оформляется как предзаказ:\nон уйдет на кухню заранее, чтобы быть готовым к этому времени, а в каждый 15-минутный слот принимается ограниченное число предзаказов.\nЗаказ с доставкой (type=delivery, по умолчанию) должен содержать адрес с координатами внутри зоны доставки; стоимость доставки зависит от зоны.\nСамовывоз (type=pickup) не передается в службу доставки",
                 "consumes": [
                     "application/json"
                 ],
@@ -94,6 +184,42 @@ const docTemplate = `{
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    {
+                        "description": "Время, к которому приготовить предзаказ (RFC 3339)",
+                        "name": "scheduled_for",
+                        "in": "body",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    {
+                        "description": "delivery (по умолчанию) или pickup",
+                        "name": "type",
+                        "in": "body",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    {
+                        "description": "Адрес доставки, обязателен для type=delivery",
+                        "name": "address",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/models.Address"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Идентификатор для сквозной трассировки событий заказа",
+                        "name": "X-Correlation-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Ключ идемпотентности: повтор запроса с тем же ключом вернет исходный ответ",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -101,10 +227,20 @@ const docTemplate = `{
                         "description": "Заказ успешно создан",
                         "schema": {
                             "type": "string"
+                        },
+                        "headers": {
+                            "X-Estimated-Arrival-At": {
+                                "type": "string",
+                                "description": "Ориентировочное время доставки, для самовывоза - время готовности (RFC 3339)"
+                            },
+                            "X-Estimated-Ready-At": {
+                                "type": "string",
+                                "description": "Ориентировочное время готовности по текущей загрузке кухни (RFC 3339)"
+                            }
                         }
                     },
                     "400": {
-                        "description": "Неправильное тело запроса",
+                        "description": "Неправильное тело запроса, недопустимое время предзаказа или нет адреса доставки",
                         "schema": {
                             "type": "object",
                             "additionalProperties": true
@@ -117,6 +253,13 @@ const docTemplate = `{
                             "additionalProperties": true
                         }
                     },
+                    "403": {
+                        "description": "Заказ оформляется от имени другого клиента",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
                     "405": {
                         "description": "Метод не доступен",
                         "schema": {
@@ -124,6 +267,76 @@ const docTemplate = `{
                             "additionalProperties": true
                         }
                     },
+                    "409": {
+                        "description": "Запрос с этим ключом идемпотентности еще обрабатывается или слот предзаказа занят",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "422": {
+                        "description": "Ключ идемпотентности уже использован с другим телом запроса, позиции закончились, адрес вне зоны доставки или сумма меньше минимальной для зоны",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "503": {
+                        "description": "Кухня перегружена, заказ можно повторить после Retry-After секунд, или ключ идемпотентности нельзя проверить",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/order/slots": {
+            "get": {
+                "description": "Обработчик для получения 15-минутных слотов, на которые можно оформить предзаказ, и числа свободных мест в них.\nПо умолчанию отдаются слоты на ближайшие сутки, начиная с самого раннего допустимого времени",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Слоты предзаказов",
+                "operationId": "slots-handler",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Начало интервала (RFC 3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Конец интервала (RFC 3339)",
+                        "name": "to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Слоты предзаказов",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.ScheduleSlot"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Неправильный интервал",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
                     "500": {
                         "description": "Внутренняя ошибка сервера",
                         "schema": {
@@ -136,7 +349,7 @@ const docTemplate = `{
         },
         "/order/status": {
             "get": {
-                "description": "Обработчик для получения статуса заказа по ID",
+                "description": "Обработчик для получения статуса заказа по ID. Пока заказ не доставлен, eta - оценка времени доставки\n(для самовывоза - времени готовности), а eta_text - та же оценка для клиента, например \"приедет через ~12 мин\"",
                 "consumes": [
                     "application/json"
                 ],
@@ -186,31 +399,41 @@ const docTemplate = `{
                 }
             }
         },
-        "/register": {
-            "post": {
-                "description": "Обработчик для регистрации нового пользователя",
+        "/order/{id}": {
+            "patch": {
+                "description": "Обработчик для изменения состава заказа клиентом. Изменить можно только заказ, который кухня еще не взяла в работу. Сумма заказа и стоимость доставки пересчитываются",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Регистрация нового пользователя",
-                "operationId": "regist-handler",
+                "summary": "Изменение заказа",
+                "operationId": "update-handler",
                 "parameters": [
                     {
-                        "description": "Учетные данные пользователя",
-                        "name": "credentials",
+                        "type": "string",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Новый состав заказа",
+                        "name": "update",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.Credentials"
+                            "$ref": "#/definitions/models.OrderUpdate"
                         }
                     }
                 ],
                 "responses": {
-                    "303": {
-                        "description": "Перенаправление на страницу логина"
+                    "200": {
+                        "description": "Измененный заказ",
+                        "schema": {
+                            "$ref": "#/definitions/models.Order"
+                        }
                     },
                     "400": {
                         "description": "Неправильное тело запроса",
@@ -219,8 +442,29 @@ const docTemplate = `{
                             "additionalProperties": true
                         }
                     },
-                    "405": {
-                        "description": "Неправильный метод запроса",
+                    "401": {
+                        "description": "Недействительный токен",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Заказ не найден",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "409": {
+                        "description": "Заказ в текущем статусе нельзя изменить или кухня уже взяла его в работу",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "422": {
+                        "description": "Позиции закончились или сумма меньше минимальной для зоны доставки",
                         "schema": {
                             "type": "object",
                             "additionalProperties": true
@@ -235,20 +479,698 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "models.Credentials": {
-            "description": "Структура данных, содержащая учетные данные пользователя",
-            "type": "object",
-            "properties": {
-                "email": {
-                    "type": "string"
-                },
-                "password": {
-                    "type": "string"
-                },
-                "username": {
+        },
+        "/order/{id}/cancel": {
+            "post": {
+                "description": "Обработчик для отмены заказа клиентом. Отменить можно только заказ, который еще не начали готовить",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Отмена заказа",
+                "operationId": "cancel-handler",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Отмененный заказ",
+                        "schema": {
+                            "$ref": "#/definitions/models.Order"
+                        }
+                    },
+                    "400": {
+                        "description": "Неправильный ID заказа",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Недействительный токен",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Заказ не найден",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "409": {
+                        "description": "Заказ в текущем статусе нельзя отменить",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/order/{id}/collected": {
+            "post": {
+                "description": "Обработчик, которым сотрудник отмечает, что клиент забрал готовый заказ на самовывоз. Заказ получает статус delivered,\nстатус публикуется в order_status. Доступен пользователям с ролью kitchen или admin",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Выдача заказа на самовывоз",
+                "operationId": "collect-handler",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Выданный заказ",
+                        "schema": {
+                            "$ref": "#/definitions/models.Order"
+                        }
+                    },
+                    "400": {
+                        "description": "Неправильный ID заказа",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Недействительный токен",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Недостаточно прав",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Заказ не найден",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "409": {
+                        "description": "Заказ не на самовывоз или еще не готов",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/order/{id}/events": {
+            "get": {
+                "description": "Обработчик, отправляющий смену статусов заказа в формате Server-Sent Events. Первым событием приходит текущий статус. Клиент может подписаться только на свой заказ",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "summary": "Поток статусов заказа (SSE)",
+                "operationId": "events-handler",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Поток событий status",
+                        "schema": {
+                            "$ref": "#/definitions/models.StatusEvent"
+                        }
+                    },
+                    "400": {
+                        "description": "Неправильный ID заказа",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Недействительный токен",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Заказ не найден",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/order/{id}/tracking": {
+            "get": {
+                "description": "Обработчик, возвращающий статус доставки заказа, курьера и его последние координаты. Данные отдает delivery-service, клиент видит только свой заказ",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Где сейчас заказ",
+                "operationId": "tracking-handler",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Статус доставки и координаты курьера",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Неправильный ID заказа",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Недействительный токен",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Заказ не найден или еще не передан в доставку",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "502": {
+                        "description": "Служба доставки недоступна",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/order/{id}/tracking/stream": {
+            "get": {
+                "description": "Обработчик, отправляющий координаты курьера, который везет заказ, в формате Server-Sent Events. Первым событием tracking приходит текущее состояние доставки, затем событие location на каждое обновление координат. Когда заказ доставлен, поток закрывается",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "summary": "Поток координат курьера (SSE)",
+                "operationId": "tracking-stream-handler",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Поток событий tracking и location",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Неправильный ID заказа",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Недействительный токен",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Заказ не найден или еще не передан в доставку",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "502": {
+                        "description": "Служба доставки недоступна",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/order/{id}/ws": {
+            "get": {
+                "description": "Обработчик, отправляющий смену статусов заказа JSON-сообщениями по WebSocket. Первым сообщением приходит текущий статус. Клиент может подписаться только на свой заказ",
+                "summary": "Поток статусов заказа (WebSocket)",
+                "operationId": "websocket-handler",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "101": {
+                        "description": "Переключение на WebSocket",
+                        "schema": {
+                            "$ref": "#/definitions/models.StatusEvent"
+                        }
+                    },
+                    "400": {
+                        "description": "Неправильный ID заказа",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Недействительный токен",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Заказ не найден",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/orders": {
+            "get": {
+                "description": "Обработчик для получения заказов текущего клиента, от новых к старым, с постраничной выдачей по курсору",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "История заказов клиента",
+                "operationId": "history-handler",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Статус заказа",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Начало периода (RFC3339 или YYYY-MM-DD)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Конец периода, не включительно (RFC3339 или YYYY-MM-DD)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Размер страницы (по умолчанию 20, максимум 100)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Курсор следующей страницы из предыдущего ответа",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Страница заказов",
+                        "schema": {
+                            "$ref": "#/definitions/models.OrderPage"
+                        }
+                    },
+                    "400": {
+                        "description": "Неправильный запрос",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Недействительный токен",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/register": {
+            "post": {
+                "description": "Обработчик для регистрации нового пользователя",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Регистрация нового пользователя",
+                "operationId": "regist-handler",
+                "parameters": [
+                    {
+                        "description": "Учетные данные пользователя",
+                        "name": "credentials",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.Credentials"
+                        }
+                    }
+                ],
+                "responses": {
+                    "303": {
+                        "description": "Перенаправление на страницу логина"
+                    },
+                    "400": {
+                        "description": "Неправильное тело запроса",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "405": {
+                        "description": "Неправильный метод запроса",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "models.Address": {
+            "description": "Адрес доставки с координатами точки на карте",
+            "type": "object",
+            "properties": {
+                "comment": {
+                    "description": "подъезд, этаж, код домофона",
+                    "type": "string"
+                },
+                "lat": {
+                    "type": "number"
+                },
+                "lon": {
+                    "type": "number"
+                },
+                "text": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.Credentials": {
+            "description": "Структура данных, содержащая учетные данные пользователя",
+            "type": "object",
+            "properties": {
+                "email": {
+                    "type": "string"
+                },
+                "password": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.Order": {
+            "description": "Заказ, содержащий информацию о клиенте, товарах и статусе",
+            "type": "object",
+            "properties": {
+                "address": {
+                    "description": "адрес доставки, для самовывоза не нужен",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.Address"
+                        }
+                    ]
+                },
+                "customer": {
+                    "type": "string"
+                },
+                "delivery_fee": {
+                    "type": "integer"
+                },
+                "eta": {
+                    "description": "когда заказ приедет к клиенту, а самовывоз - когда будет готов. Пересчитывается на каждом статусе",
+                    "type": "string"
+                },
+                "eta_text": {
+                    "description": "оценка для клиента: \"приедет через ~12 мин\"",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "priority": {
+                    "description": "приоритет на кухне, назначается order-service по правилам",
+                    "type": "integer"
+                },
+                "scheduled_for": {
+                    "description": "время, к которому нужно приготовить предзаказ. Пусто - заказ готовится сразу",
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "subtotal": {
+                    "type": "integer"
+                },
+                "type": {
+                    "description": "delivery (по умолчанию) или pickup - самовывоз",
+                    "type": "string"
+                },
+                "zone": {
+                    "description": "зона доставки и суммы в копейках считает order-service",
+                    "type": "string"
+                }
+            }
+        },
+        "models.OrderListItem": {
+            "description": "Заказ с датой создания и именем клиента (имя заполняется только в админском списке)",
+            "type": "object",
+            "properties": {
+                "address": {
+                    "description": "адрес доставки, для самовывоза не нужен",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.Address"
+                        }
+                    ]
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "customer": {
+                    "type": "string"
+                },
+                "delivery_fee": {
+                    "type": "integer"
+                },
+                "eta": {
+                    "description": "когда заказ приедет к клиенту, а самовывоз - когда будет готов. Пересчитывается на каждом статусе",
+                    "type": "string"
+                },
+                "eta_text": {
+                    "description": "оценка для клиента: \"приедет через ~12 мин\"",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "priority": {
+                    "description": "приоритет на кухне, назначается order-service по правилам",
+                    "type": "integer"
+                },
+                "scheduled_for": {
+                    "description": "время, к которому нужно приготовить предзаказ. Пусто - заказ готовится сразу",
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "subtotal": {
+                    "type": "integer"
+                },
+                "type": {
+                    "description": "delivery (по умолчанию) или pickup - самовывоз",
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                },
+                "zone": {
+                    "description": "зона доставки и суммы в копейках считает order-service",
+                    "type": "string"
+                }
+            }
+        },
+        "models.OrderPage": {
+            "description": "Страница заказов и курсор для получения следующей страницы",
+            "type": "object",
+            "properties": {
+                "next_cursor": {
+                    "type": "string"
+                },
+                "orders": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.OrderListItem"
+                    }
+                }
+            }
+        },
+        "models.OrderUpdate": {
+            "description": "Новый состав заказа",
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.ScheduleSlot": {
+            "description": "15-минутный слот и число свободных мест в нем",
+            "type": "object",
+            "properties": {
+                "available": {
+                    "type": "integer"
+                },
+                "capacity": {
+                    "type": "integer"
+                },
+                "end": {
+                    "type": "string"
+                },
+                "start": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.StatusEvent": {
+            "description": "Новый статус заказа и время его изменения",
+            "type": "object",
+            "properties": {
+                "at": {
+                    "type": "string"
+                },
+                "eta": {
+                    "description": "новая оценка времени доставки",
+                    "type": "string"
+                },
+                "order_id": {
+                    "type": "string"
+                },
+                "status": {
                     "type": "string"
                 }
             }
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/sandrinasava/cafe-services/order-service/models"
+)
+
+// AdminOrdersHandler godoc
+// @Summary Поиск заказов администратором
+// @Description Обработчик для поиска по всем заказам. Параметр q выполняет полнотекстовый поиск по товарам, имени клиента и статусу. Доступен только пользователям с ролью admin
+// @ID admin-orders-handler
+// @Produce json
+// @Param q query string false "Поисковый запрос"
+// @Param customer query string false "ID клиента"
+// @Param status query string false "Статус заказа"
+// @Param from query string false "Начало периода (RFC3339 или YYYY-MM-DD)"
+// @Param to query string false "Конец периода, не включительно (RFC3339 или YYYY-MM-DD)"
+// @Param limit query int false "Размер страницы (по умолчанию 20, максимум 100)"
+// @Param cursor query string false "Курсор следующей страницы из предыдущего ответа"
+// @Success 200 {object} models.OrderPage "Страница заказов"
+// @Failure 400 {object} map[string]interface{} "Неправильный запрос"
+// @Failure 401 {object} map[string]interface{} "Недействительный токен"
+// @Failure 403 {object} map[string]interface{} "Недостаточно прав"
+// @Failure 500 {object} map[string]interface{} "Внутренняя ошибка сервера"
+// @Router /admin/orders [get]
+func AdminOrdersHandler(db *sql.DB, authClient *models.AuthClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := authenticate(r, authClient)
+		if err != nil {
+			log.Printf("Ошибка при валидации токена: %v", err)
+			http.Error(w, "Недействительный токен", http.StatusUnauthorized)
+			return
+		}
+		if claims.Role != models.RoleAdmin {
+			http.Error(w, "Недостаточно прав", http.StatusForbidden)
+			return
+		}
+
+		q := r.URL.Query()
+		filter, err := parseOrderFilter(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.Search = q.Get("q")
+		if v := q.Get("customer"); v != "" {
+			if filter.Customer, err = uuid.Parse(v); err != nil {
+				http.Error(w, "Неправильный ID клиента", http.StatusBadRequest)
+				return
+			}
+		}
+
+		page, err := listOrders(r.Context(), db, filter)
+		if err != nil {
+			log.Printf("Ошибка поиска заказов: %v", err)
+			http.Error(w, "Ошибка при получении заказов", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/order-service/models"
+	"github.com/sandrinasava/cafe-services/order-service/schedule"
+	"github.com/sandrinasava/cafe-services/order-service/status"
+)
+
+// CancelHandler godoc
+// @Summary Отмена заказа
+// @Description Обработчик для отмены заказа клиентом. Отменить можно только заказ, который еще не начали готовить
+// @ID cancel-handler
+// @Produce json
+// @Param id path string true "Order ID"
+// @Success 200 {object} models.Order "Отмененный заказ"
+// @Failure 400 {object} map[string]interface{} "Неправильный ID заказа"
+// @Failure 401 {object} map[string]interface{} "Недействительный токен"
+// @Failure 404 {object} map[string]interface{} "Заказ не найден"
+// @Failure 409 {object} map[string]interface{} "Заказ в текущем статусе нельзя отменить"
+// @Failure 500 {object} map[string]interface{} "Внутренняя ошибка сервера"
+// @Router /order/{id}/cancel [post]
+func CancelHandler(rdb *redis.Client, db *sql.DB, authClient *models.AuthClient, topic string, enc events.Encoding) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Неправильный ID заказа", http.StatusBadRequest)
+			return
+		}
+
+		customer, err := customerID(r, authClient)
+		if err != nil {
+			log.Printf("Ошибка при валидации токена: %v", err)
+			http.Error(w, "Недействительный токен", http.StatusUnauthorized)
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "Ошибка при отмене заказа", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// меняю статус только если заказ принадлежит клиенту и находится в допустимом статусе
+		var order models.Order
+		err = tx.QueryRowContext(r.Context(),
+			`UPDATE orders SET status = $1
+			WHERE order_UUID = $2 AND user_UUID = $3 AND status = ANY($4)
+			RETURNING order_UUID, user_UUID, items, status, scheduled_for, priority,
+				order_type, address, COALESCE(delivery_zone, ''), subtotal, delivery_fee`,
+			models.StatusCancelled, orderID, customer, pq.Array(models.CancellableStatuses)).Scan(
+			&order.ID, &order.Customer, pq.Array(&order.Items), &order.Status, &order.ScheduledFor, &order.Priority,
+			&order.Type, &order.Address, &order.Zone, &order.Subtotal, &order.DeliveryFee)
+		if errors.Is(err, sql.ErrNoRows) {
+			orderConflict(w, r, db, orderID, customer, "Заказ в текущем статусе нельзя отменить")
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка отмены заказа %s: %v", orderID, err)
+			http.Error(w, "Ошибка при отмене заказа", http.StatusInternalServerError)
+			return
+		}
+
+		// отмененный предзаказ освобождает место в слоте
+		if order.ScheduledFor != nil {
+			if err := schedule.Release(r.Context(), tx, schedule.SlotStart(*order.ScheduledFor)); err != nil {
+				log.Printf("Ошибка отмены заказа %s: %v", orderID, err)
+				http.Error(w, "Ошибка при отмене заказа", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		payload := events.OrderChanged{OrderID: order.ID.String()}
+		if err := commitOrderEvent(r, tx, rdb, topic, enc, events.TypeOrderCancelled, payload); err != nil {
+			log.Printf("Ошибка отмены заказа %s: %v", orderID, err)
+			http.Error(w, "Ошибка при отмене заказа", http.StatusInternalServerError)
+			return
+		}
+
+		// сообщаю подписчикам потока статусов об отмене
+		statusEvent := models.StatusEvent{OrderID: order.ID, Status: order.Status, At: time.Now()}
+		if err := status.Notify(r.Context(), rdb, statusEvent); err != nil {
+			log.Printf("Ошибка рассылки статуса заказа %s: %v", order.ID, err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(order)
+	}
+}
+
+// orderConflict отвечает 404, если заказа клиента нет, и 409, если заказ есть, но его статус не позволяет операцию
+func orderConflict(w http.ResponseWriter, r *http.Request, db *sql.DB, orderID, customer uuid.UUID, msg string) {
+	var exists bool
+	err := db.QueryRowContext(r.Context(),
+		"SELECT EXISTS(SELECT 1 FROM orders WHERE order_UUID = $1 AND user_UUID = $2)", orderID, customer).Scan(&exists)
+	if err != nil {
+		http.Error(w, "Ошибка при обработке заказа", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Заказ не найден", http.StatusNotFound)
+		return
+	}
+	http.Error(w, msg, http.StatusConflict)
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/order-service/models"
+	"github.com/sandrinasava/cafe-services/order-service/outbox"
+	"github.com/sandrinasava/cafe-services/order-service/status"
+)
+
+// CollectHandler godoc
+// @Summary Выдача заказа на самовывоз
+// @Description Обработчик, которым сотрудник отмечает, что клиент забрал готовый заказ на самовывоз. Заказ получает статус delivered,
+// @Description статус публикуется в order_status. Доступен пользователям с ролью kitchen или admin
+// @ID collect-handler
+// @Produce json
+// @Param id path string true "Order ID"
+// @Success 200 {object} models.Order "Выданный заказ"
+// @Failure 400 {object} map[string]interface{} "Неправильный ID заказа"
+// @Failure 401 {object} map[string]interface{} "Недействительный токен"
+// @Failure 403 {object} map[string]interface{} "Недостаточно прав"
+// @Failure 404 {object} map[string]interface{} "Заказ не найден"
+// @Failure 409 {object} map[string]interface{} "Заказ не на самовывоз или еще не готов"
+// @Failure 500 {object} map[string]interface{} "Внутренняя ошибка сервера"
+// @Router /order/{id}/collected [post]
+func CollectHandler(rdb *redis.Client, db *sql.DB, authClient *models.AuthClient, topic string, enc events.Encoding) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Неправильный ID заказа", http.StatusBadRequest)
+			return
+		}
+
+		claims, err := authenticate(r, authClient)
+		if err != nil {
+			log.Printf("Ошибка при валидации токена: %v", err)
+			http.Error(w, "Недействительный токен", http.StatusUnauthorized)
+			return
+		}
+		if claims.Role != models.RoleKitchen && claims.Role != models.RoleAdmin {
+			http.Error(w, "Недостаточно прав", http.StatusForbidden)
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "Ошибка при выдаче заказа", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// выдать можно только готовый заказ на самовывоз: заказы с доставкой завершает delivery-service
+		var order models.Order
+		err = tx.QueryRowContext(r.Context(),
+			`UPDATE orders SET status = $1
+			WHERE order_UUID = $2 AND order_type = $3 AND status = $4
+			RETURNING order_UUID, user_UUID, items, status, scheduled_for, priority,
+				order_type, subtotal, delivery_fee`,
+			models.StatusDelivered, orderID, models.OrderTypePickup, models.StatusReady).Scan(
+			&order.ID, &order.Customer, pq.Array(&order.Items), &order.Status, &order.ScheduledFor, &order.Priority,
+			&order.Type, &order.Subtotal, &order.DeliveryFee)
+		if errors.Is(err, sql.ErrNoRows) {
+			var exists bool
+			if err := db.QueryRowContext(r.Context(),
+				"SELECT EXISTS(SELECT 1 FROM orders WHERE order_UUID = $1)", orderID).Scan(&exists); err != nil {
+				http.Error(w, "Ошибка при выдаче заказа", http.StatusInternalServerError)
+				return
+			}
+			if !exists {
+				http.Error(w, "Заказ не найден", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Заказ не на самовывоз или еще не готов", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка выдачи заказа %s: %v", orderID, err)
+			http.Error(w, "Ошибка при выдаче заказа", http.StatusInternalServerError)
+			return
+		}
+
+		// статус уходит в order_status, как от кухни и доставки: по нему клиент получит уведомление
+		now := time.Now()
+		changed := events.StatusChanged{OrderID: order.ID.String(), Status: order.Status, At: now}
+		event, err := newEvent(r, events.TypeStatusChanged, changed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := outbox.Enqueue(r.Context(), tx, topic, changed.OrderID, event, enc); err != nil {
+			log.Printf("Ошибка выдачи заказа %s: %v", orderID, err)
+			http.Error(w, "Ошибка при выдаче заказа", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Ошибка выдачи заказа %s: %v", orderID, err)
+			http.Error(w, "Ошибка при выдаче заказа", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Заказ %s выдан клиенту (сотрудник %s)", order.ID, claims.UserID)
+
+		if err := rdb.Del(r.Context(), order.ID.String()).Err(); err != nil {
+			log.Printf("Ошибка удаления заказа из кеша: %v", err)
+		}
+		statusEvent := models.StatusEvent{OrderID: order.ID, Status: order.Status, At: now}
+		if err := status.Notify(r.Context(), rdb, statusEvent); err != nil {
+			log.Printf("Ошибка рассылки статуса заказа %s: %v", order.ID, err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(order)
+	}
+}
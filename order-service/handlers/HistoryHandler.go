@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/sandrinasava/cafe-services/order-service/models"
+)
+
+// HistoryHandler godoc
+// @Summary История заказов клиента
+// @Description Обработчик для получения заказов текущего клиента, от новых к старым, с постраничной выдачей по курсору
+// @ID history-handler
+// @Produce json
+// @Param status query string false "Статус заказа"
+// @Param from query string false "Начало периода (RFC3339 или YYYY-MM-DD)"
+// @Param to query string false "Конец периода, не включительно (RFC3339 или YYYY-MM-DD)"
+// @Param limit query int false "Размер страницы (по умолчанию 20, максимум 100)"
+// @Param cursor query string false "Курсор следующей страницы из предыдущего ответа"
+// @Success 200 {object} models.OrderPage "Страница заказов"
+// @Failure 400 {object} map[string]interface{} "Неправильный запрос"
+// @Failure 401 {object} map[string]interface{} "Недействительный токен"
+// @Failure 500 {object} map[string]interface{} "Внутренняя ошибка сервера"
+// @Router /orders [get]
+func HistoryHandler(db *sql.DB, authClient *models.AuthClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		customer, err := customerID(r, authClient)
+		if err != nil {
+			log.Printf("Ошибка при валидации токена: %v", err)
+			http.Error(w, "Недействительный токен", http.StatusUnauthorized)
+			return
+		}
+
+		filter, err := parseOrderFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.Customer = customer
+
+		page, err := listOrders(r.Context(), db, filter)
+		if err != nil {
+			log.Printf("Ошибка получения истории заказов: %v", err)
+			http.Error(w, "Ошибка при получении заказов", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
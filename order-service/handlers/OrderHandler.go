@@ -1,35 +1,60 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
-	"github.com/segmentio/kafka-go"
+	"github.com/lib/pq"
 
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/order-service/delivery"
+	"github.com/sandrinasava/cafe-services/order-service/kitchen"
 	"github.com/sandrinasava/cafe-services/order-service/models"
+	"github.com/sandrinasava/cafe-services/order-service/outbox"
+	"github.com/sandrinasava/cafe-services/order-service/priority"
+	"github.com/sandrinasava/cafe-services/order-service/schedule"
+	"github.com/sandrinasava/cafe-services/order-service/zones"
 )
 
 // OrderHandler godoc
 // @Summary Создание нового заказа
-// @Description Обработчик для создания нового заказа
+// @Description Обработчик для создания нового заказа. Если указан scheduled_for, заказ оформляется как предзаказ:
+// @Description он уйдет на кухню заранее, чтобы быть готовым к этому времени, а в каждый 15-минутный слот принимается ограниченное число предзаказов.
+// @Description Заказ с доставкой (type=delivery, по умолчанию) должен содержать адрес с координатами внутри зоны доставки; стоимость доставки зависит от зоны.
+// @Description Самовывоз (type=pickup) не передается в службу доставки
 // @ID order-handler
 // @Accept json
 // @Produce json
 // @Param customer body string true "Customer Name"
 // @Param items body string true "Items"
+// @Param scheduled_for body string false "Время, к которому приготовить предзаказ (RFC 3339)"
+// @Param type body string false "delivery (по умолчанию) или pickup"
+// @Param address body models.Address false "Адрес доставки, обязателен для type=delivery"
+// @Param X-Correlation-ID header string false "Идентификатор для сквозной трассировки событий заказа"
+// @Param Idempotency-Key header string false "Ключ идемпотентности: повтор запроса с тем же ключом вернет исходный ответ"
 // @Success 201 {string} string "Заказ успешно создан"
-// @Failure 400 {object} map[string]interface{} "Неправильное тело запроса"
+// @Header 201 {string} X-Estimated-Ready-At "Ориентировочное время готовности по текущей загрузке кухни (RFC 3339)"
+// @Header 201 {string} X-Estimated-Arrival-At "Ориентировочное время доставки, для самовывоза - время готовности (RFC 3339)"
+// @Failure 400 {object} map[string]interface{} "Неправильное тело запроса, недопустимое время предзаказа или нет адреса доставки"
 // @Failure 401 {object} map[string]interface{} "Недействительный токен"
+// @Failure 403 {object} map[string]interface{} "Заказ оформляется от имени другого клиента"
 // @Failure 405 {object} map[string]interface{} "Метод не доступен"
+// @Failure 409 {object} map[string]interface{} "Запрос с этим ключом идемпотентности еще обрабатывается или слот предзаказа занят"
+// @Failure 422 {object} map[string]interface{} "Ключ идемпотентности уже использован с другим телом запроса, позиции закончились, адрес вне зоны доставки или сумма меньше минимальной для зоны"
 // @Failure 500 {object} map[string]interface{} "Внутренняя ошибка сервера"
+// @Failure 503 {object} map[string]interface{} "Кухня перегружена, заказ можно повторить после Retry-After секунд, или ключ идемпотентности нельзя проверить"
 // @Router /order [post]
-func OrderHandler(rdb *redis.Client, db *sql.DB, authClient *models.AuthClient, kWriter *kafka.Writer) http.HandlerFunc {
+func OrderHandler(rdb *redis.Client, db *sql.DB, authClient *models.AuthClient, topic string, enc events.Encoding, maxKitchenWait time.Duration, preorders schedule.Policy, rules priority.Rules, zs *zones.Zones, estimator *delivery.Estimator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Метод не доступен", http.StatusMethodNotAllowed)
@@ -37,12 +62,17 @@ func OrderHandler(rdb *redis.Client, db *sql.DB, authClient *models.AuthClient,
 		}
 
 		// достаю данные из сообщения и десериализую
-		var order models.Order
-		if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		defer r.Body.Close()
+		var order models.Order
+		if err := json.Unmarshal(body, &order); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
 		// проверяю наличие идентификатора клиента
 		if order.Customer == uuid.Nil {
@@ -59,20 +89,105 @@ func OrderHandler(rdb *redis.Client, db *sql.DB, authClient *models.AuthClient,
 		}
 
 		// валидация токена
-		valid, err := authClient.ValidateToken(r.Context(), token)
+		claims, err := authClient.Authenticate(r.Context(), token)
 		if err != nil {
 			log.Printf("Ошибка при валидации токена: %v", err)
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
-		if !valid {
-			log.Println("Недействительный токен")
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+		// заказ, ключ идемпотентности и приоритет относятся к клиенту из токена
+		if order.Customer != claims.UserID {
+			http.Error(w, "Заказ оформляется от имени другого клиента", http.StatusForbidden)
 			return
 		}
 
+		// повтор запроса с тем же Idempotency-Key получает исходный ответ вместо нового заказа. Ключ проверяется
+		// до проверок, зависящих от текущего состояния (время предзаказа, зоны, склад): иначе повтор
+		// успешного запроса мог бы получить ошибку, хотя заказ уже создан
+		if key := r.Header.Get("Idempotency-Key"); key != "" {
+			store := idempotencyStore{rdb: rdb, db: db}
+			hash := requestHash(body)
+			saved, err := store.begin(r.Context(), order.Customer, key, hash)
+			switch {
+			case errors.Is(err, errIdempotencyMismatch):
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			case errors.Is(err, errIdempotencyInProgress):
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			case errors.Is(err, errIdempotencyUnavailable):
+				log.Printf("Ошибка проверки ключа идемпотентности: %v", err)
+				http.Error(w, errIdempotencyUnavailable.Error(), http.StatusServiceUnavailable)
+				return
+			case err != nil:
+				log.Printf("Ошибка проверки ключа идемпотентности: %v", err)
+				http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+				return
+			case saved != nil:
+				saved.replay(w)
+				return
+			}
+
+			rec := &responseRecorder{ResponseWriter: w}
+			defer func() {
+				// context.WithoutCancel: ответ нужно сохранить, даже если клиент уже отключился
+				store.complete(context.WithoutCancel(r.Context()), order.Customer, key, rec.record(hash))
+			}()
+			w = rec
+		}
+
+		if order.ScheduledFor != nil {
+			if err := preorders.Check(*order.ScheduledFor); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// сумму и стоимость доставки считает сервер, значения из запроса не учитываются
+		if err := priceOrder(zs, &order); err != nil {
+			priceError(w, err)
+			return
+		}
+
+		// позиции, которые закончились на кухне, отклоняю сразу. Ответ не сохраняется по ключу идемпотентности:
+		// после пополнения склада тот же запрос должен пройти
+		if !checkAvailable(w, r, rdb, order.Items) {
+			return
+		}
+
+		// оцениваю время готовности по загрузке кухни и временно не принимаю заказы, если кухня не успеет.
+		// Предзаказ будет готов к назначенному времени, текущая загрузка кухни на него не влияет
+		var readyAt time.Time
+		if order.ScheduledFor != nil {
+			readyAt = *order.ScheduledFor
+		} else if load, ok, err := kitchen.Current(r.Context(), rdb); err != nil {
+			log.Printf("Ошибка чтения загрузки кухни: %v", err)
+		} else if ok {
+			wait := kitchen.EstimatedWait(load)
+			if maxKitchenWait > 0 && wait > maxKitchenWait {
+				w.Header().Set("Retry-After", strconv.Itoa(int((wait-maxKitchenWait).Seconds())+1))
+				http.Error(w, "Кухня перегружена, попробуйте оформить заказ позже", http.StatusServiceUnavailable)
+				return
+			}
+			readyAt = time.Now().Add(wait)
+		}
+
+		// приоритет на кухне назначается только по правилам, значение из запроса не учитывается.
+		// Если правила проверить не удалось, заказ готовится в обычном порядке
+		order.Priority, err = rules.Classify(r.Context(), db, claims)
+		if err != nil {
+			log.Printf("Ошибка определения приоритета заказа: %v", err)
+		}
+
 		order.ID = uuid.New()
-		order.Status = "received"
+		order.Status = models.StatusReceived
+		if order.ScheduledFor != nil {
+			order.Status = models.StatusScheduled
+		}
+		// оценка времени доставки по загрузке кухни и курьеров, расстоянию до клиента и времени приготовления
+		if eta, ok := estimator.Estimate(r.Context(), order); ok {
+			order.ETA = &eta
+		}
 		// сериализация
 		message, err := json.Marshal(order)
 		if err != nil {
@@ -80,16 +195,55 @@ func OrderHandler(rdb *redis.Client, db *sql.DB, authClient *models.AuthClient,
 			return
 		}
 
-		// отправляю заказ в Kafka
-		err = kWriter.WriteMessages(r.Context(), kafka.Message{
-			Key:   []byte(order.ID.String()),
-			Value: message,
-		})
+		// сохраняю заказ и сообщение для кухни в одной транзакции, в Kafka его отправит outbox.Relay
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "Ошибка при сохранении заказа в базу данных", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
 
+		_, err = tx.ExecContext(r.Context(),
+			`INSERT INTO orders (order_UUID, user_UUID, items, status, scheduled_for, correlation_id, priority,
+				order_type, address, delivery_zone, subtotal, delivery_fee, eta)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, $9, NULLIF($10, ''), $11, $12, $13)`,
+			order.ID, order.Customer, pq.Array(order.Items), order.Status, order.ScheduledFor,
+			r.Header.Get("X-Correlation-ID"), order.Priority,
+			order.Type, order.Address, order.Zone, order.Subtotal, order.DeliveryFee, order.ETA)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Ошибка при сохранении заказа в базу данных", http.StatusInternalServerError)
 			return
 		}
+		if order.ScheduledFor != nil {
+			// предзаказ занимает место в слоте, на кухню его отправит schedule.Scheduler
+			err := schedule.Book(r.Context(), tx, schedule.SlotStart(*order.ScheduledFor), preorders.SlotCapacity)
+			if errors.Is(err, schedule.ErrSlotFull) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Ошибка при сохранении заказа в базу данных", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			event, err := newEvent(r, events.TypeOrderCreated, order.Event())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if err := outbox.Enqueue(r.Context(), tx, topic, order.ID.String(), event, enc); err != nil {
+				http.Error(w, "Ошибка при сохранении заказа в базу данных", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Ошибка при сохранении заказа в базу данных", http.StatusInternalServerError)
+			return
+		}
+
+		if order.Priority != events.PriorityNormal {
+			log.Printf("Заказ %s получил приоритет %s", order.ID, priority.Name(order.Priority))
+		}
 
 		// сохраняю заказ в кэше Redis на 1 час
 		err = rdb.Set(r.Context(), order.ID.String(), string(message), 1*time.Hour).Err()
@@ -97,14 +251,12 @@ func OrderHandler(rdb *redis.Client, db *sql.DB, authClient *models.AuthClient,
 			log.Printf("Ошибка кеширования сообщения: %v", err)
 		}
 
-		// Сохранение заказа в PostgreSQL
-		_, err = db.ExecContext(r.Context(), "INSERT INTO orders (order_UUID, username, items, status) VALUES ($1, $2, $3, $4)",
-			order.ID, order.Customer, order.Items, order.Status)
-		if err != nil {
-			http.Error(w, "Ошибка при сохранении заказа в базу данных", http.StatusInternalServerError)
-			return
+		if !readyAt.IsZero() {
+			w.Header().Set("X-Estimated-Ready-At", readyAt.UTC().Format(time.RFC3339))
+		}
+		if order.ETA != nil {
+			w.Header().Set("X-Estimated-Arrival-At", order.ETA.UTC().Format(time.RFC3339))
 		}
-
 		w.WriteHeader(http.StatusCreated)
 		fmt.Fprintf(w, "Сообщение отправлено: %s\n", order.ID)
 	}
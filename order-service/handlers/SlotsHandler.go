@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sandrinasava/cafe-services/order-service/schedule"
+)
+
+// SlotsHandler godoc
+// @Summary Слоты предзаказов
+// @Description Обработчик для получения 15-минутных слотов, на которые можно оформить предзаказ, и числа свободных мест в них.
+// @Description По умолчанию отдаются слоты на ближайшие сутки, начиная с самого раннего допустимого времени
+// @ID slots-handler
+// @Produce json
+// @Param from query string false "Начало интервала (RFC 3339)"
+// @Param to query string false "Конец интервала (RFC 3339)"
+// @Success 200 {array} models.ScheduleSlot "Слоты предзаказов"
+// @Failure 400 {object} map[string]interface{} "Неправильный интервал"
+// @Failure 500 {object} map[string]interface{} "Внутренняя ошибка сервера"
+// @Router /order/slots [get]
+func SlotsHandler(db *sql.DB, preorders schedule.Policy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		earliest, latest := now.Add(preorders.PrepTime), now.Add(preorders.MaxAhead)
+
+		from, to := earliest, earliest.Add(24*time.Hour)
+		var err error
+		if v := r.URL.Query().Get("from"); v != "" {
+			if from, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, "Неправильный формат from", http.StatusBadRequest)
+				return
+			}
+			to = from.Add(24 * time.Hour)
+		}
+		if v := r.URL.Query().Get("to"); v != "" {
+			if to, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, "Неправильный формат to", http.StatusBadRequest)
+				return
+			}
+		}
+		// слоты вне допустимого окна не отдаю: оформить на них предзаказ все равно нельзя
+		if from.Before(earliest) {
+			from = earliest
+		}
+		if to.After(latest) {
+			to = latest
+		}
+		if !from.Before(to) {
+			http.Error(w, "Неправильный интервал", http.StatusBadRequest)
+			return
+		}
+
+		slots, err := schedule.Slots(r.Context(), db, from, to, preorders.SlotCapacity)
+		if err != nil {
+			log.Printf("Ошибка чтения слотов предзаказов: %v", err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(slots)
+	}
+}
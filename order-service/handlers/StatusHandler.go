@@ -4,15 +4,18 @@ import (
 	"database/sql"
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/lib/pq"
 
 	"github.com/sandrinasava/cafe-services/order-service/models"
 )
 
 // StatusHandler godoc
 // @Summary Получение статуса заказа
-// @Description Обработчик для получения статуса заказа по ID
+// @Description Обработчик для получения статуса заказа по ID. Пока заказ не доставлен, eta - оценка времени доставки
+// @Description (для самовывоза - времени готовности), а eta_text - та же оценка для клиента, например "приедет через ~12 мин"
 // @ID status-handler
 // @Accept json
 // @Produce json
@@ -40,8 +43,11 @@ func StatusHandler(rdb *redis.Client, db *sql.DB) http.HandlerFunc {
 			}
 		} else if err == redis.Nil {
 			// Поиск заказа в базе данных
-			err = db.QueryRowContext(r.Context(), "SELECT order_UUID, username, items, status FROM orders WHERE id=$1", orderID).Scan(
-				&order.ID, &order.Customer, &order.Items, &order.Status)
+			err = db.QueryRowContext(r.Context(), `SELECT order_UUID, user_UUID, items, status, scheduled_for, priority,
+				order_type, address, COALESCE(delivery_zone, ''), subtotal, delivery_fee, eta
+				FROM orders WHERE order_UUID=$1`, orderID).Scan(
+				&order.ID, &order.Customer, pq.Array(&order.Items), &order.Status, &order.ScheduledFor, &order.Priority,
+				&order.Type, &order.Address, &order.Zone, &order.Subtotal, &order.DeliveryFee, &order.ETA)
 			if err != nil {
 				http.Error(w, "Заказ не найден", http.StatusNotFound)
 				return
@@ -51,10 +57,8 @@ func StatusHandler(rdb *redis.Client, db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		err = json.Unmarshal([]byte(cachedOrder), &order)
-		if err != nil {
-			http.Error(w, "Ошибка при обработке заказа", http.StatusInternalServerError)
-			return
+		if order.ETA != nil {
+			order.ETAText = models.ETAText(order.Type, *order.ETA, time.Now())
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"golang.org/x/net/websocket"
+
+	"github.com/sandrinasava/cafe-services/order-service/models"
+	"github.com/sandrinasava/cafe-services/order-service/status"
+)
+
+// интервал, с которым в открытый поток отправляется keep-alive, чтобы прокси не закрывали соединение
+const streamHeartbeat = 15 * time.Second
+
+// EventsHandler godoc
+// @Summary Поток статусов заказа (SSE)
+// @Description Обработчик, отправляющий смену статусов заказа в формате Server-Sent Events. Первым событием приходит текущий статус. Клиент может подписаться только на свой заказ
+// @ID events-handler
+// @Produce text/event-stream
+// @Param id path string true "Order ID"
+// @Success 200 {object} models.StatusEvent "Поток событий status"
+// @Failure 400 {object} map[string]interface{} "Неправильный ID заказа"
+// @Failure 401 {object} map[string]interface{} "Недействительный токен"
+// @Failure 404 {object} map[string]interface{} "Заказ не найден"
+// @Failure 500 {object} map[string]interface{} "Внутренняя ошибка сервера"
+// @Router /order/{id}/events [get]
+func EventsHandler(ctx context.Context, rdb *redis.Client, db *sql.DB, authClient *models.AuthClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID, ok := authorizeStream(w, r, db, authClient)
+		if !ok {
+			return
+		}
+
+		sub, current, err := subscribeStatus(r.Context(), rdb, db, orderID)
+		if err != nil {
+			log.Printf("Ошибка подписки на статусы заказа %s: %v", orderID, err)
+			http.Error(w, "Ошибка при обработке заказа", http.StatusInternalServerError)
+			return
+		}
+		defer sub.Close()
+
+		rc := http.NewResponseController(w)
+		// поток живет дольше WriteTimeout сервера
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			log.Printf("Не удалось снять таймаут записи для потока: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+
+		send := func(event models.StatusEvent) error {
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "event: status\ndata: %s\n\n", data); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+
+		if err := send(current); err != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+		messages := sub.Channel()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ctx.Done():
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var event models.StatusEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+					log.Printf("неудачная десериализация статуса заказа: %v", err)
+					continue
+				}
+				if err := send(event); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// WebSocketHandler godoc
+// @Summary Поток статусов заказа (WebSocket)
+// @Description Обработчик, отправляющий смену статусов заказа JSON-сообщениями по WebSocket. Первым сообщением приходит текущий статус. Клиент может подписаться только на свой заказ
+// @ID websocket-handler
+// @Param id path string true "Order ID"
+// @Success 101 {object} models.StatusEvent "Переключение на WebSocket"
+// @Failure 400 {object} map[string]interface{} "Неправильный ID заказа"
+// @Failure 401 {object} map[string]interface{} "Недействительный токен"
+// @Failure 404 {object} map[string]interface{} "Заказ не найден"
+// @Failure 500 {object} map[string]interface{} "Внутренняя ошибка сервера"
+// @Router /order/{id}/ws [get]
+func WebSocketHandler(ctx context.Context, rdb *redis.Client, db *sql.DB, authClient *models.AuthClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID, ok := authorizeStream(w, r, db, authClient)
+		if !ok {
+			return
+		}
+
+		// подписка до upgrade, чтобы ошибку можно было вернуть обычным HTTP-ответом
+		reqCtx := r.Context()
+		sub, current, err := subscribeStatus(reqCtx, rdb, db, orderID)
+		if err != nil {
+			log.Printf("Ошибка подписки на статусы заказа %s: %v", orderID, err)
+			http.Error(w, "Ошибка при обработке заказа", http.StatusInternalServerError)
+			return
+		}
+		defer sub.Close()
+
+		// соединение после upgrade живет дольше таймаутов сервера
+		rc := http.NewResponseController(w)
+		if err := rc.SetReadDeadline(time.Time{}); err != nil {
+			log.Printf("Не удалось снять таймаут чтения для потока: %v", err)
+		}
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			log.Printf("Не удалось снять таймаут записи для потока: %v", err)
+		}
+
+		websocket.Server{Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			if err := websocket.JSON.Send(ws, current); err != nil {
+				return
+			}
+
+			// клиент ничего не отправляет, чтение нужно только чтобы заметить закрытие соединения
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				var discard []byte
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+			}()
+
+			heartbeat := time.NewTicker(streamHeartbeat)
+			defer heartbeat.Stop()
+			messages := sub.Channel()
+			for {
+				select {
+				case <-reqCtx.Done():
+					return
+				case <-ctx.Done():
+					return
+				case <-closed:
+					return
+				case <-heartbeat.C:
+					ws.PayloadType = websocket.PingFrame
+					_, err := ws.Write(nil)
+					ws.PayloadType = websocket.TextFrame
+					if err != nil {
+						return
+					}
+				case msg, ok := <-messages:
+					if !ok {
+						return
+					}
+					var event models.StatusEvent
+					if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+						log.Printf("неудачная десериализация статуса заказа: %v", err)
+						continue
+					}
+					if err := websocket.JSON.Send(ws, event); err != nil {
+						return
+					}
+				}
+			}
+		}}.ServeHTTP(w, r)
+	}
+}
+
+// authorizeStream проверяет, что пользователь может следить за заказом, и возвращает ID заказа.
+// Клиент видит только свои заказы, администратор - любые
+func authorizeStream(w http.ResponseWriter, r *http.Request, db *sql.DB, authClient *models.AuthClient) (uuid.UUID, bool) {
+	orderID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Неправильный ID заказа", http.StatusBadRequest)
+		return uuid.Nil, false
+	}
+
+	claims, err := authenticate(r, authClient)
+	if err != nil {
+		log.Printf("Ошибка при валидации токена: %v", err)
+		http.Error(w, "Недействительный токен", http.StatusUnauthorized)
+		return uuid.Nil, false
+	}
+
+	var owner uuid.UUID
+	err = db.QueryRowContext(r.Context(), "SELECT user_UUID FROM orders WHERE order_UUID = $1", orderID).Scan(&owner)
+	// чужой заказ выглядит для клиента так же, как несуществующий
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && owner != claims.UserID && claims.Role != models.RoleAdmin) {
+		http.Error(w, "Заказ не найден", http.StatusNotFound)
+		return uuid.Nil, false
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при обработке заказа", http.StatusInternalServerError)
+		return uuid.Nil, false
+	}
+	return orderID, true
+}
+
+// subscribeStatus подписывается на статусы заказа и только после подтверждения подписки читает текущий статус.
+// Статус, сменившийся между чтением и подпиской, иначе не попал бы ни в первое событие, ни в канал
+func subscribeStatus(ctx context.Context, rdb *redis.Client, db *sql.DB, orderID uuid.UUID) (*redis.PubSub, models.StatusEvent, error) {
+	sub := rdb.Subscribe(ctx, status.Channel(orderID))
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, models.StatusEvent{}, err
+	}
+
+	current := models.StatusEvent{OrderID: orderID, At: time.Now()}
+	if err := db.QueryRowContext(ctx, "SELECT status FROM orders WHERE order_UUID = $1", orderID).Scan(&current.Status); err != nil {
+		sub.Close()
+		return nil, models.StatusEvent{}, err
+	}
+	return sub, current, nil
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sandrinasava/cafe-services/order-service/models"
+)
+
+// TrackingHandler godoc
+// @Summary Где сейчас заказ
+// @Description Обработчик, возвращающий статус доставки заказа, курьера и его последние координаты. Данные отдает delivery-service, клиент видит только свой заказ
+// @ID tracking-handler
+// @Produce json
+// @Param id path string true "Order ID"
+// @Success 200 {object} map[string]interface{} "Статус доставки и координаты курьера"
+// @Failure 400 {object} map[string]interface{} "Неправильный ID заказа"
+// @Failure 401 {object} map[string]interface{} "Недействительный токен"
+// @Failure 404 {object} map[string]interface{} "Заказ не найден или еще не передан в доставку"
+// @Failure 502 {object} map[string]interface{} "Служба доставки недоступна"
+// @Router /order/{id}/tracking [get]
+func TrackingHandler(db *sql.DB, authClient *models.AuthClient, deliveryURL string) http.HandlerFunc {
+	proxy := deliveryProxy(deliveryURL, "/tracking", 0)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authorizeStream(w, r, db, authClient); !ok {
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}
+}
+
+// TrackingStreamHandler godoc
+// @Summary Поток координат курьера (SSE)
+// @Description Обработчик, отправляющий координаты курьера, который везет заказ, в формате Server-Sent Events. Первым событием tracking приходит текущее состояние доставки, затем событие location на каждое обновление координат. Когда заказ доставлен, поток закрывается
+// @ID tracking-stream-handler
+// @Produce text/event-stream
+// @Param id path string true "Order ID"
+// @Success 200 {object} map[string]interface{} "Поток событий tracking и location"
+// @Failure 400 {object} map[string]interface{} "Неправильный ID заказа"
+// @Failure 401 {object} map[string]interface{} "Недействительный токен"
+// @Failure 404 {object} map[string]interface{} "Заказ не найден или еще не передан в доставку"
+// @Failure 502 {object} map[string]interface{} "Служба доставки недоступна"
+// @Router /order/{id}/tracking/stream [get]
+func TrackingStreamHandler(ctx context.Context, db *sql.DB, authClient *models.AuthClient, deliveryURL string) http.HandlerFunc {
+	// события отправляются клиенту сразу, без буферизации
+	proxy := deliveryProxy(deliveryURL, "/tracking/stream", -1)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authorizeStream(w, r, db, authClient); !ok {
+			return
+		}
+
+		// поток живет дольше WriteTimeout сервера
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+			log.Printf("Не удалось снять таймаут записи для потока: %v", err)
+		}
+		// при остановке сервера поток закрывается, иначе Shutdown будет ждать его до таймаута
+		reqCtx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		stop := context.AfterFunc(ctx, cancel)
+		defer stop()
+
+		proxy.ServeHTTP(w, r.WithContext(reqCtx))
+	}
+}
+
+// deliveryProxy проксирует запрос по заказу /order/{id}... во внутренний маршрут
+// delivery-service /deliveries/{id}<suffix>. Токен клиента в delivery-service не передается
+func deliveryProxy(deliveryURL, suffix string, flushInterval time.Duration) *httputil.ReverseProxy {
+	target, err := url.Parse(deliveryURL)
+	if err != nil {
+		log.Fatalf("Некорректный адрес delivery-service %q: %v", deliveryURL, err)
+	}
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.Out.URL.Path = strings.TrimSuffix(target.Path, "/") + "/deliveries/" + url.PathEscape(pr.In.PathValue("id")) + suffix
+			pr.Out.URL.RawPath = ""
+			pr.Out.URL.RawQuery = ""
+			pr.Out.Header.Del("Authorization")
+			pr.Out.Header.Del("Cookie")
+		},
+		FlushInterval: flushInterval,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("Ошибка запроса к delivery-service: %v", err)
+			http.Error(w, "Служба доставки недоступна", http.StatusBadGateway)
+		},
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/order-service/models"
+	"github.com/sandrinasava/cafe-services/order-service/zones"
+)
+
+// UpdateHandler godoc
+// @Summary Изменение заказа
+// @Description Обработчик для изменения состава заказа клиентом. Изменить можно только заказ, который кухня еще не взяла в работу. Сумма заказа и стоимость доставки пересчитываются
+// @ID update-handler
+// @Accept json
+// @Produce json
+// @Param id path string true "Order ID"
+// @Param update body models.OrderUpdate true "Новый состав заказа"
+// @Success 200 {object} models.Order "Измененный заказ"
+// @Failure 400 {object} map[string]interface{} "Неправильное тело запроса"
+// @Failure 401 {object} map[string]interface{} "Недействительный токен"
+// @Failure 404 {object} map[string]interface{} "Заказ не найден"
+// @Failure 409 {object} map[string]interface{} "Заказ в текущем статусе нельзя изменить или кухня уже взяла его в работу"
+// @Failure 422 {object} map[string]interface{} "Позиции закончились или сумма меньше минимальной для зоны доставки"
+// @Failure 500 {object} map[string]interface{} "Внутренняя ошибка сервера"
+// @Router /order/{id} [patch]
+func UpdateHandler(rdb *redis.Client, db *sql.DB, authClient *models.AuthClient, topic string, enc events.Encoding, zs *zones.Zones) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Неправильный ID заказа", http.StatusBadRequest)
+			return
+		}
+
+		var update models.OrderUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "Неправильное тело запроса", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		if len(update.Items) == 0 {
+			http.Error(w, "Заказ должен содержать хотя бы одну позицию", http.StatusBadRequest)
+			return
+		}
+
+		customer, err := customerID(r, authClient)
+		if err != nil {
+			log.Printf("Ошибка при валидации токена: %v", err)
+			http.Error(w, "Недействительный токен", http.StatusUnauthorized)
+			return
+		}
+
+		if !checkAvailable(w, r, rdb, update.Items) {
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "Ошибка при изменении заказа", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var order models.Order
+		err = tx.QueryRowContext(r.Context(),
+			`UPDATE orders SET items = $1
+			WHERE order_UUID = $2 AND user_UUID = $3 AND status = ANY($4) AND kitchen_taken_at IS NULL
+			RETURNING order_UUID, user_UUID, items, status, scheduled_for, priority,
+				order_type, address, COALESCE(delivery_zone, ''), subtotal, delivery_fee`,
+			pq.Array(update.Items), orderID, customer, pq.Array(models.ModifiableStatuses)).Scan(
+			&order.ID, &order.Customer, pq.Array(&order.Items), &order.Status, &order.ScheduledFor, &order.Priority,
+			&order.Type, &order.Address, &order.Zone, &order.Subtotal, &order.DeliveryFee)
+		if errors.Is(err, sql.ErrNoRows) {
+			orderConflict(w, r, db, orderID, customer, "Заказ в текущем статусе нельзя изменить или кухня уже взяла его в работу")
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка изменения заказа %s: %v", orderID, err)
+			http.Error(w, "Ошибка при изменении заказа", http.StatusInternalServerError)
+			return
+		}
+
+		// новый состав меняет сумму заказа: она снова должна пройти минимум зоны, а доставка может стать бесплатной.
+		// Если зоны с тех пор изменились и адрес в них больше не попадает или заказ оформлен до появления адресов,
+		// доставка остается по прежней цене
+		zone, fee := order.Zone, order.DeliveryFee
+		if err := priceOrder(zs, &order); errors.Is(err, zones.ErrOutsideZones) || errors.Is(err, errNoAddress) {
+			order.Zone, order.DeliveryFee = zone, fee
+		} else if err != nil {
+			priceError(w, err)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(),
+			`UPDATE orders SET subtotal = $2, delivery_fee = $3 WHERE order_UUID = $1`,
+			order.ID, order.Subtotal, order.DeliveryFee); err != nil {
+			log.Printf("Ошибка изменения заказа %s: %v", orderID, err)
+			http.Error(w, "Ошибка при изменении заказа", http.StatusInternalServerError)
+			return
+		}
+
+		payload := events.OrderChanged{OrderID: order.ID.String(), Items: order.Items}
+		if err := commitOrderEvent(r, tx, rdb, topic, enc, events.TypeOrderUpdated, payload); err != nil {
+			log.Printf("Ошибка изменения заказа %s: %v", orderID, err)
+			http.Error(w, "Ошибка при изменении заказа", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(order)
+	}
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/sandrinasava/cafe-services/order-service/models"
+)
+
+// authenticate проверяет токен из заголовка Authorization и возвращает данные пользователя.
+// Браузерные EventSource и WebSocket не умеют передавать заголовки, поэтому без заголовка
+// токен берется из куки access_token, которую выставляет AuthZHandler
+func authenticate(r *http.Request, authClient *models.AuthClient) (models.Claims, error) {
+	token := r.Header.Get("Authorization")
+	if token == "" {
+		if cookie, err := r.Cookie("access_token"); err == nil {
+			token = cookie.Value
+		}
+	}
+	if token == "" {
+		return models.Claims{}, fmt.Errorf("токен не предоставлен")
+	}
+	return authClient.Authenticate(r.Context(), token)
+}
+
+// customerID проверяет токен из заголовка Authorization и возвращает идентификатор клиента
+func customerID(r *http.Request, authClient *models.AuthClient) (uuid.UUID, error) {
+	claims, err := authenticate(r, authClient)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return claims.UserID, nil
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/order-service/outbox"
+)
+
+// producer - имя сервиса в конвертах событий
+const producer = "order-service"
+
+// newEvent создает конверт события. Correlation ID берется из заголовка X-Correlation-ID,
+// чтобы клиент или прокси могли проследить заказ через все сервисы
+func newEvent(r *http.Request, eventType string, payload interface{}) (events.Envelope, error) {
+	return events.New(eventType, producer, r.Header.Get("X-Correlation-ID"), payload)
+}
+
+// commitOrderEvent сохраняет событие об изменении заказа в outbox, фиксирует транзакцию
+// и сбрасывает заказ из кэша Redis
+func commitOrderEvent(r *http.Request, tx *sql.Tx, rdb *redis.Client, topic string, enc events.Encoding,
+	eventType string, payload events.OrderChanged) error {
+	event, err := newEvent(r, eventType, payload)
+	if err != nil {
+		return err
+	}
+	if err := outbox.Enqueue(r.Context(), tx, topic, payload.OrderID, event, enc); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// удаляю устаревшую копию заказа из кэша, следующее чтение возьмет ее из бд
+	if err := rdb.Del(r.Context(), payload.OrderID).Err(); err != nil {
+		log.Printf("Ошибка удаления заказа из кеша: %v", err)
+	}
+	return nil
+}
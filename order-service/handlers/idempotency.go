@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	// сколько хранится ответ на запрос с Idempotency-Key
+	idempotencyTTL = 24 * time.Hour
+	// сколько держится резерв ключа за запросом, который еще обрабатывается. Если сервис упал посреди запроса,
+	// ключ освободится сам, не дожидаясь idempotencyTTL
+	idempotencyPendingTTL = 30 * time.Second
+)
+
+var (
+	errIdempotencyMismatch    = errors.New("ключ идемпотентности уже использован с другим телом запроса")
+	errIdempotencyInProgress  = errors.New("запрос с этим ключом идемпотентности еще обрабатывается")
+	errIdempotencyUnavailable = errors.New("не удалось проверить ключ идемпотентности, повторите запрос позже")
+)
+
+// idempotencyRecord - сохраненный ответ на запрос с Idempotency-Key.
+// StatusCode == 0 означает, что запрос еще обрабатывается
+type idempotencyRecord struct {
+	RequestHash string `json:"request_hash"`
+	StatusCode  int    `json:"status_code"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        string `json:"body,omitempty"`
+}
+
+// idempotencyStore хранит ответы в Redis для быстрого доступа и в Postgres, чтобы они пережили вытеснение из кэша
+type idempotencyStore struct {
+	rdb *redis.Client
+	db  *sql.DB
+}
+
+func requestHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func idempotencyRedisKey(customer uuid.UUID, key string) string {
+	return "idempotency:" + customer.String() + ":" + key
+}
+
+// begin резервирует ключ за текущим запросом. Если на запрос с этим ключом уже есть ответ, возвращает его для повтора
+func (s idempotencyStore) begin(ctx context.Context, customer uuid.UUID, key, hash string) (*idempotencyRecord, error) {
+	rkey := idempotencyRedisKey(customer, key)
+	pending, _ := json.Marshal(idempotencyRecord{RequestHash: hash})
+
+	reserved, err := s.rdb.SetNX(ctx, rkey, pending, idempotencyPendingTTL).Result()
+	if err != nil {
+		// без резерва два одновременных повтора создали бы два заказа
+		return nil, fmt.Errorf("%w: %v", errIdempotencyUnavailable, err)
+	}
+	if !reserved {
+		var rec idempotencyRecord
+		if cached, err := s.rdb.Get(ctx, rkey).Result(); err == nil {
+			if err := json.Unmarshal([]byte(cached), &rec); err == nil && rec.StatusCode != 0 {
+				return checkIdempotencyRecord(&rec, hash)
+			}
+		}
+		// в Redis резерв: запрос еще обрабатывается или его ответ сохранился только в бд
+		saved, err := s.load(ctx, customer, key)
+		if err != nil {
+			return nil, err
+		}
+		if saved != nil {
+			s.cache(ctx, rkey, saved)
+			return checkIdempotencyRecord(saved, hash)
+		}
+		if rec.RequestHash != "" && rec.RequestHash != hash {
+			return nil, errIdempotencyMismatch
+		}
+		return nil, errIdempotencyInProgress
+	}
+
+	rec, err := s.load(ctx, customer, key)
+	if err != nil {
+		s.abort(ctx, customer, key)
+		return nil, err
+	}
+	if rec == nil {
+		return nil, nil
+	}
+	// ответ был вытеснен из Redis, возвращаю его в кэш
+	s.cache(ctx, rkey, rec)
+	return checkIdempotencyRecord(rec, hash)
+}
+
+// cache сохраняет готовый ответ в Redis
+func (s idempotencyStore) cache(ctx context.Context, rkey string, rec *idempotencyRecord) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	if err := s.rdb.Set(ctx, rkey, data, idempotencyTTL).Err(); err != nil {
+		log.Printf("Ошибка сохранения ответа для ключа идемпотентности в Redis: %v", err)
+	}
+}
+
+func checkIdempotencyRecord(rec *idempotencyRecord, hash string) (*idempotencyRecord, error) {
+	if rec.RequestHash != hash {
+		return nil, errIdempotencyMismatch
+	}
+	if rec.StatusCode == 0 {
+		return nil, errIdempotencyInProgress
+	}
+	return rec, nil
+}
+
+func (s idempotencyStore) load(ctx context.Context, customer uuid.UUID, key string) (*idempotencyRecord, error) {
+	var rec idempotencyRecord
+	err := s.db.QueryRowContext(ctx,
+		`SELECT request_hash, status_code, content_type, response FROM idempotency_keys
+		WHERE user_UUID = $1 AND idempotency_key = $2 AND created_at > $3`,
+		customer, key, time.Now().Add(-idempotencyTTL)).Scan(&rec.RequestHash, &rec.StatusCode, &rec.ContentType, &rec.Body)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// complete сохраняет успешный ответ. Ошибки не сохраняются: они зависят от текущего состояния (склад, слоты,
+// загрузка кухни) или от сбоя, поэтому повтор с тем же ключом проверяется заново
+func (s idempotencyStore) complete(ctx context.Context, customer uuid.UUID, key string, rec idempotencyRecord) {
+	if rec.StatusCode < http.StatusOK || rec.StatusCode >= http.StatusMultipleChoices {
+		s.abort(ctx, customer, key)
+		return
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO idempotency_keys (user_UUID, idempotency_key, request_hash, status_code, content_type, response)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_UUID, idempotency_key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status_code = EXCLUDED.status_code,
+			content_type = EXCLUDED.content_type, response = EXCLUDED.response, created_at = CURRENT_TIMESTAMP`,
+		customer, key, rec.RequestHash, rec.StatusCode, rec.ContentType, rec.Body)
+	if err != nil {
+		log.Printf("Ошибка сохранения ответа для ключа идемпотентности в бд: %v", err)
+	}
+	s.cache(ctx, idempotencyRedisKey(customer, key), &rec)
+}
+
+// abort снимает резерв с ключа
+func (s idempotencyStore) abort(ctx context.Context, customer uuid.UUID, key string) {
+	if err := s.rdb.Del(ctx, idempotencyRedisKey(customer, key)).Err(); err != nil {
+		log.Printf("Ошибка удаления ключа идемпотентности из Redis: %v", err)
+	}
+}
+
+// replay повторяет сохраненный ответ
+func (rec *idempotencyRecord) replay(w http.ResponseWriter) {
+	if rec.ContentType != "" {
+		w.Header().Set("Content-Type", rec.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(rec.StatusCode)
+	w.Write([]byte(rec.Body))
+}
+
+// responseRecorder запоминает статус и тело ответа, чтобы сохранить их для повторов
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+func (rr *responseRecorder) record(hash string) idempotencyRecord {
+	return idempotencyRecord{
+		RequestHash: hash,
+		StatusCode:  rr.status,
+		ContentType: rr.Header().Get("Content-Type"),
+		Body:        rr.body.String(),
+	}
+}
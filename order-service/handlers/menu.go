@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/sandrinasava/cafe-services/order-service/kitchen"
+)
+
+// checkAvailable отвечает 422, если в заказе есть позиции, которые кухня сняла с продажи.
+// Если Redis недоступен, заказ пропускается: кухня все равно приготовит то, на что хватит ингредиентов
+func checkAvailable(w http.ResponseWriter, r *http.Request, rdb *redis.Client, items []string) bool {
+	unavailable, err := kitchen.Unavailable(r.Context(), rdb, items)
+	if err != nil {
+		log.Printf("Ошибка чтения доступности меню: %v", err)
+		return true
+	}
+	if len(unavailable) > 0 {
+		http.Error(w, fmt.Sprintf("Позиции закончились: %s", strings.Join(unavailable, ", ")), http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/sandrinasava/cafe-services/order-service/models"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// orderFilter описывает условия выборки списка заказов
+type orderFilter struct {
+	Customer uuid.UUID // uuid.Nil - заказы всех клиентов
+	Status   string
+	From     time.Time
+	To       time.Time
+	Search   string // полнотекстовый поиск по товарам, имени клиента и статусу
+	Limit    int
+	Cursor   *pageCursor
+}
+
+// pageCursor указывает на последний заказ предыдущей страницы.
+// Заказы сортируются по (created_at, order_UUID) по убыванию, поэтому курсор стабилен при добавлении новых заказов
+type pageCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+func (c pageCursor) encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("неправильный курсор")
+	}
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, fmt.Errorf("неправильный курсор")
+	}
+	var c pageCursor
+	if c.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return nil, fmt.Errorf("неправильный курсор")
+	}
+	if c.ID, err = uuid.Parse(id); err != nil {
+		return nil, fmt.Errorf("неправильный курсор")
+	}
+	return &c, nil
+}
+
+// parseOrderFilter разбирает общие для списков заказов параметры запроса: status, from, to, limit, cursor
+func parseOrderFilter(q url.Values) (orderFilter, error) {
+	f := orderFilter{Status: q.Get("status"), Limit: defaultPageSize}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return f, fmt.Errorf("неправильный limit")
+		}
+		f.Limit = min(limit, maxPageSize)
+	}
+
+	var err error
+	if v := q.Get("from"); v != "" {
+		if f.From, err = parseDate(v); err != nil {
+			return f, fmt.Errorf("неправильная дата from")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if f.To, err = parseDate(v); err != nil {
+			return f, fmt.Errorf("неправильная дата to")
+		}
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		if f.Cursor, err = decodeCursor(v); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// parseDate принимает дату в формате RFC3339 или YYYY-MM-DD
+func parseDate(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, v)
+}
+
+// listOrders возвращает страницу заказов, подходящих под фильтр
+func listOrders(ctx context.Context, db *sql.DB, f orderFilter) (models.OrderPage, error) {
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.Customer != uuid.Nil {
+		where = append(where, "o.user_UUID = "+arg(f.Customer))
+	}
+	if f.Status != "" {
+		where = append(where, "o.status = "+arg(f.Status))
+	}
+	if !f.From.IsZero() {
+		where = append(where, "o.created_at >= "+arg(f.From))
+	}
+	if !f.To.IsZero() {
+		where = append(where, "o.created_at < "+arg(f.To))
+	}
+	if f.Search != "" {
+		where = append(where, "o.search @@ websearch_to_tsquery('simple', "+arg(f.Search)+")")
+	}
+	if f.Cursor != nil {
+		where = append(where, fmt.Sprintf("(o.created_at, o.order_UUID) < (%s, %s)", arg(f.Cursor.CreatedAt), arg(f.Cursor.ID)))
+	}
+
+	query := `SELECT o.order_UUID, o.user_UUID, u.username, o.items, o.status, o.scheduled_for, o.priority,
+			o.order_type, o.address, COALESCE(o.delivery_zone, ''), o.subtotal, o.delivery_fee, o.created_at
+		FROM orders o JOIN users u ON u.user_UUID = o.user_UUID`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// запрашиваю на одну запись больше, чтобы понять, есть ли следующая страница
+	query += " ORDER BY o.created_at DESC, o.order_UUID DESC LIMIT " + arg(f.Limit+1)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return models.OrderPage{}, err
+	}
+	defer rows.Close()
+
+	page := models.OrderPage{Orders: []models.OrderListItem{}}
+	for rows.Next() {
+		var o models.OrderListItem
+		if err := rows.Scan(&o.ID, &o.Customer, &o.Username, pq.Array(&o.Items), &o.Status, &o.ScheduledFor, &o.Priority,
+			&o.Type, &o.Address, &o.Zone, &o.Subtotal, &o.DeliveryFee, &o.CreatedAt); err != nil {
+			return models.OrderPage{}, err
+		}
+		page.Orders = append(page.Orders, o)
+	}
+	if err := rows.Err(); err != nil {
+		return models.OrderPage{}, err
+	}
+
+	if len(page.Orders) > f.Limit {
+		page.Orders = page.Orders[:f.Limit]
+		last := page.Orders[f.Limit-1]
+		page.NextCursor = pageCursor{CreatedAt: last.CreatedAt, ID: last.ID}.encode()
+	}
+	return page, nil
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/sandrinasava/cafe-services/order-service/models"
+	"github.com/sandrinasava/cafe-services/order-service/zones"
+)
+
+var (
+	errOrderType = errors.New("тип заказа должен быть delivery или pickup")
+	errNoAddress = errors.New("для доставки нужен адрес с координатами")
+)
+
+// priceOrder считает сумму заказа и стоимость доставки. Для доставки адрес должен попасть в зону доставки,
+// а сумма позиций - быть не меньше минимальной для зоны. Самовывоз адреса не хранит и за доставку не платит
+func priceOrder(zs *zones.Zones, order *models.Order) error {
+	if order.Type == "" {
+		order.Type = models.OrderTypeDelivery
+	}
+	order.Subtotal = zs.Subtotal(order.Items)
+
+	switch order.Type {
+	case models.OrderTypePickup:
+		order.Address, order.Zone, order.DeliveryFee = nil, "", 0
+		return nil
+	case models.OrderTypeDelivery:
+	default:
+		return errOrderType
+	}
+
+	a := order.Address
+	if a == nil || a.Text == "" || (a.Lat == 0 && a.Lon == 0) ||
+		a.Lat < -90 || a.Lat > 90 || a.Lon < -180 || a.Lon > 180 {
+		return errNoAddress
+	}
+	zone, fee, err := zs.Quote(zones.Point{Lat: a.Lat, Lon: a.Lon}, order.Items)
+	if err != nil {
+		return err
+	}
+	order.Zone, order.DeliveryFee = zone.Name, fee
+	return nil
+}
+
+// priceError отвечает на ошибку priceOrder
+func priceError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, zones.ErrOutsideZones) || errors.Is(err, zones.ErrMinOrder) {
+		status = http.StatusUnprocessableEntity
+	}
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,102 @@
+// Package kitchen хранит последнее известное состояние кухни: загрузку (kitchen_busy) и позиции меню,
+// снятые с продажи (menu_availability). Консьюмеры читают их из Kafka и сохраняют в Redis,
+// откуда их читают все экземпляры order-service
+package kitchen
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/sandrinasava/cafe-services/events"
+)
+
+const (
+	loadKey = "kitchen_load"
+	// кухня публикует загрузку не реже раза в KITCHEN_LOAD_INTERVAL, более старые данные не учитываются
+	loadTTL = time.Minute
+)
+
+// Current возвращает последнюю загрузку кухни. false - кухня давно не сообщала о загрузке
+func Current(ctx context.Context, rdb *redis.Client) (events.KitchenBusy, bool, error) {
+	data, err := rdb.Get(ctx, loadKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return events.KitchenBusy{}, false, nil
+	}
+	if err != nil {
+		return events.KitchenBusy{}, false, err
+	}
+	var load events.KitchenBusy
+	if err := json.Unmarshal(data, &load); err != nil {
+		return events.KitchenBusy{}, false, err
+	}
+	return load, true, nil
+}
+
+// EstimatedWait возвращает, через сколько кухня освободится
+func EstimatedWait(load events.KitchenBusy) time.Duration {
+	return time.Duration(load.EstimatedWaitSeconds) * time.Second
+}
+
+// Consumer читает загрузку кухни из топика kitchen_load
+type Consumer struct {
+	rdb    *redis.Client
+	reader *kafka.Reader
+}
+
+func NewConsumer(rdb *redis.Client, brokers []string, topic string) *Consumer {
+	return &Consumer{
+		rdb: rdb,
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: brokers,
+			GroupID: "order-kitchen-load-group",
+			Topic:   topic,
+		}),
+	}
+}
+
+// Run читает топик до отмены ctx
+func (c *Consumer) Run(ctx context.Context) {
+	for {
+		m, err := c.reader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("неудачное чтение загрузки кухни из брокера: %v", err)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		env, err := events.Decode(m)
+		if err != nil {
+			log.Printf("неудачная десериализация загрузки кухни: %v", err)
+			continue
+		}
+		var load events.KitchenBusy
+		if err := env.DecodePayload(&load); err != nil {
+			log.Printf("неудачная десериализация загрузки кухни: %v", err)
+			continue
+		}
+		// устаревший сигнал, прочитанный после простоя, не должен блокировать прием заказов
+		if time.Since(load.At) > loadTTL {
+			continue
+		}
+
+		data, err := json.Marshal(load)
+		if err != nil {
+			continue
+		}
+		if err := c.rdb.Set(ctx, loadKey, data, loadTTL-time.Since(load.At)).Err(); err != nil {
+			log.Printf("Ошибка сохранения загрузки кухни: %v", err)
+		}
+	}
+}
+
+func (c *Consumer) Close() error {
+	return c.reader.Close()
+}
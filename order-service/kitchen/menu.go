@@ -0,0 +1,102 @@
+package kitchen
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/sandrinasava/cafe-services/events"
+)
+
+// позиции меню, снятые кухней с продажи: позиция -> недостающие ингредиенты через запятую
+const unavailableKey = "menu_unavailable"
+
+// Unavailable возвращает позиции заказа, которые кухня сняла с продажи
+func Unavailable(ctx context.Context, rdb *redis.Client, items []string) ([]string, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	names := make([]string, len(items))
+	for i, item := range items {
+		names[i] = normalizeItem(item)
+	}
+	found, err := rdb.HMGet(ctx, unavailableKey, names...).Result()
+	if err != nil {
+		return nil, err
+	}
+	var unavailable []string
+	for i, v := range found {
+		if v != nil {
+			unavailable = append(unavailable, items[i])
+		}
+	}
+	return unavailable, nil
+}
+
+// позиции сравниваются так же, как в меню кухни
+func normalizeItem(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// MenuConsumer читает доступность позиций меню из топика kitchen_inventory
+type MenuConsumer struct {
+	rdb    *redis.Client
+	reader *kafka.Reader
+}
+
+func NewMenuConsumer(rdb *redis.Client, brokers []string, topic string) *MenuConsumer {
+	return &MenuConsumer{
+		rdb: rdb,
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: brokers,
+			GroupID: "order-menu-group",
+			Topic:   topic,
+		}),
+	}
+}
+
+// Run читает топик до отмены ctx. Предупреждения о запасах из того же топика пропускаются
+func (c *MenuConsumer) Run(ctx context.Context) {
+	for {
+		m, err := c.reader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("неудачное чтение доступности меню из брокера: %v", err)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		env, err := events.Decode(m)
+		if err != nil {
+			log.Printf("неудачная десериализация доступности меню: %v", err)
+			continue
+		}
+		if env.Type != events.TypeMenuAvailability {
+			continue
+		}
+		var item events.MenuAvailability
+		if err := env.DecodePayload(&item); err != nil {
+			log.Printf("неудачная десериализация доступности меню: %v", err)
+			continue
+		}
+
+		name := normalizeItem(item.Item)
+		if item.Available {
+			err = c.rdb.HDel(ctx, unavailableKey, name).Err()
+		} else {
+			err = c.rdb.HSet(ctx, unavailableKey, name, strings.Join(item.MissingIngredients, ",")).Err()
+		}
+		if err != nil {
+			log.Printf("Ошибка сохранения доступности позиции %s: %v", name, err)
+		}
+	}
+}
+
+func (c *MenuConsumer) Close() error {
+	return c.reader.Close()
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -15,11 +16,23 @@ import (
 	httpSwagger "github.com/swaggo/http-swagger"
 
 	"github.com/go-redis/redis/v8"
+	_ "github.com/lib/pq"
 	"github.com/segmentio/kafka-go"
 
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/events/eta"
+	"github.com/sandrinasava/cafe-services/events/retry"
+	"github.com/sandrinasava/cafe-services/order-service/compensation"
+	"github.com/sandrinasava/cafe-services/order-service/delivery"
 	_ "github.com/sandrinasava/cafe-services/order-service/docs"
 	"github.com/sandrinasava/cafe-services/order-service/handlers"
+	"github.com/sandrinasava/cafe-services/order-service/kitchen"
 	"github.com/sandrinasava/cafe-services/order-service/models"
+	"github.com/sandrinasava/cafe-services/order-service/outbox"
+	"github.com/sandrinasava/cafe-services/order-service/priority"
+	"github.com/sandrinasava/cafe-services/order-service/schedule"
+	"github.com/sandrinasava/cafe-services/order-service/status"
+	"github.com/sandrinasava/cafe-services/order-service/zones"
 )
 
 func main() {
@@ -29,12 +42,26 @@ func main() {
 		log.Fatalf("Неудачная загрузка конфигураций: %v", err)
 	}
 
+	// сверяю схемы событий с реестром, несовместимые изменения не должны попасть в Kafka
+	if err := events.CheckSchemas(); err != nil {
+		log.Fatalf("Несовместимая схема событий: %v", err)
+	}
+	eventsEncoding, err := events.ParseEncoding(cfg.Events.Encoding)
+	if err != nil {
+		log.Fatalf("Неудачная загрузка конфигураций: %v", err)
+	}
+
 	kafkaBroker := cfg.Kafka.Broker
 	redisHost := cfg.Redis.Host
 	redisPassword := cfg.Redis.Password
 	authServiceAddress := cfg.AuthService.Address
 	dbDSN := cfg.DB.DSN
 	topicIn := cfg.Kafka.Topic
+	topicEvents := cfg.Kafka.TopicEvents
+	topicReady := cfg.Kafka.TopicReady
+	topicStatus := cfg.Kafka.TopicStatus
+	topicLoad := cfg.Kafka.TopicLoad
+	topicStock := cfg.Kafka.TopicStock
 
 	// создание нового клиента Redis
 	rdb := redis.NewClient(&redis.Options{
@@ -58,19 +85,112 @@ func main() {
 	}
 	defer db.Close()
 
-	//создаю продюсера
-	kWriter := kafka.NewWriter(kafka.WriterConfig{
-		Brokers:  []string{kafkaBroker},
-		Topic:    topicIn,
-		Balancer: &kafka.LeastBytes{},
-	})
+	// продюсер для outbox.Relay, топик берется из каждого сообщения
+	kWriter := &kafka.Writer{
+		Addr:     kafka.TCP(strings.Split(kafkaBroker, ",")...),
+		Balancer: &kafka.Hash{},
+	}
 	defer kWriter.Close()
 
+	// контекст фоновых задач, отменяется при остановке сервиса
+	appCtx, appCancel := context.WithCancel(context.Background())
+	defer appCancel()
+
+	// публикация сообщений из outbox в Kafka
+	relay := outbox.NewRelay(db, kWriter, cfg.Outbox.PollInterval, cfg.Outbox.BatchSize)
+	relayDone := make(chan struct{})
+	go func() {
+		relay.Run(appCtx)
+		close(relayDone)
+	}()
+
+	// зоны доставки: адрес заказа должен попасть в зону, от зоны зависит стоимость доставки
+	deliveryZones, err := zones.Load(cfg.Delivery.ZonesFile)
+	if err != nil {
+		log.Fatalf("Не удалось загрузить зоны доставки: %v", err)
+	}
+
+	// оценка времени доставки по загрузке кухни и курьеров, времени приготовления и расстоянию до клиента
+	restaurant := eta.Point{Lat: cfg.Restaurant.Lat, Lon: cfg.Restaurant.Lon}
+	estimator := delivery.NewEstimator(rdb, cfg.ETA, restaurant, deliveryZones.Prep(), cfg.Delivery.DefaultPrepTime)
+
+	// консьюмер статусов заказов для бд и потоков SSE/WebSocket, на каждом статусе пересчитывает время доставки
+	statusConsumer := status.NewConsumer(rdb, db, estimator, retry.Config{
+		Brokers:        strings.Split(kafkaBroker, ","),
+		Delays:         cfg.Retry.Delays,
+		MaxAttempts:    cfg.Retry.MaxAttempts,
+		CommitInterval: cfg.Kafka.CommitInterval,
+	}, topicReady, topicStatus, cfg.Kafka.TopicDelivery)
+	go statusConsumer.Run(appCtx)
+
+	// консьюмер загрузки курьеров для оценки времени доставки
+	courierConsumer := delivery.NewConsumer(rdb, strings.Split(kafkaBroker, ","), cfg.Kafka.TopicDelivery)
+	go courierConsumer.Run(appCtx)
+
+	// консьюмер запросов компенсации за недоставленные заказы
+	compensationConsumer := compensation.NewConsumer(db, retry.Config{
+		Brokers:        strings.Split(kafkaBroker, ","),
+		Topic:          cfg.Kafka.TopicDelivery,
+		Delays:         cfg.Retry.Delays,
+		MaxAttempts:    cfg.Retry.MaxAttempts,
+		CommitInterval: cfg.Kafka.CommitInterval,
+	})
+	go compensationConsumer.Run(appCtx)
+
+	// консьюмер загрузки кухни для оценки времени готовности новых заказов
+	kitchenConsumer := kitchen.NewConsumer(rdb, strings.Split(kafkaBroker, ","), topicLoad)
+	go kitchenConsumer.Run(appCtx)
+
+	// консьюмер доступности меню: позиции, которые закончились на кухне, не принимаются в заказ
+	menuConsumer := kitchen.NewMenuConsumer(rdb, strings.Split(kafkaBroker, ","), topicStock)
+	go menuConsumer.Run(appCtx)
+
+	// предзаказы уходят на кухню заранее, чтобы быть готовыми к назначенному времени
+	preorders := schedule.Policy{
+		PrepTime:     cfg.Schedule.PrepTime,
+		MaxAhead:     cfg.Schedule.MaxAhead,
+		SlotCapacity: cfg.Schedule.SlotCapacity,
+	}
+	scheduler := schedule.NewScheduler(db, rdb, topicIn, eventsEncoding, preorders, cfg.Schedule.PollInterval)
+	go scheduler.Run(appCtx)
+
+	// правила приоритета заказов на кухне
+	rules := priority.Rules{
+		StaffRoles:         cfg.Priority.StaffRoles,
+		VIPRoles:           cfg.Priority.VIPRoles,
+		DelayThreshold:     cfg.Priority.DelayThreshold,
+		CompensationWindow: cfg.Priority.CompensationWindow,
+	}
+
+	// контекст открытых потоков статусов: закрывает их при остановке сервера, иначе Shutdown будет ждать их до таймаута
+	streamsCtx, streamsCancel := context.WithCancel(context.Background())
+	defer streamsCancel()
+
 	// регистрация маршрутов
 
-	http.HandleFunc("/order", handlers.OrderHandler(rdb, db, authClient, kWriter))
+	http.HandleFunc("/order", handlers.OrderHandler(rdb, db, authClient, topicIn, eventsEncoding, cfg.Kitchen.MaxWait, preorders, rules, deliveryZones, estimator))
+
+	http.HandleFunc("GET /order/status", handlers.StatusHandler(rdb, db))
+
+	http.HandleFunc("GET /order/slots", handlers.SlotsHandler(db, preorders))
+
+	http.HandleFunc("PATCH /order/{id}", handlers.UpdateHandler(rdb, db, authClient, topicEvents, eventsEncoding, deliveryZones))
+
+	http.HandleFunc("POST /order/{id}/cancel", handlers.CancelHandler(rdb, db, authClient, topicEvents, eventsEncoding))
+
+	http.HandleFunc("POST /order/{id}/collected", handlers.CollectHandler(rdb, db, authClient, topicStatus, eventsEncoding))
+
+	http.HandleFunc("GET /order/{id}/events", handlers.EventsHandler(streamsCtx, rdb, db, authClient))
+
+	http.HandleFunc("GET /order/{id}/ws", handlers.WebSocketHandler(streamsCtx, rdb, db, authClient))
+
+	http.HandleFunc("GET /order/{id}/tracking", handlers.TrackingHandler(db, authClient, cfg.DeliveryService.URL))
+
+	http.HandleFunc("GET /order/{id}/tracking/stream", handlers.TrackingStreamHandler(streamsCtx, db, authClient, cfg.DeliveryService.URL))
+
+	http.HandleFunc("GET /orders", handlers.HistoryHandler(db, authClient))
 
-	http.HandleFunc("/order/status", handlers.StatusHandler(rdb, db))
+	http.HandleFunc("GET /admin/orders", handlers.AdminOrdersHandler(db, authClient))
 
 	http.HandleFunc("/login", handlers.AuthZHandler(rdb, db, authClient))
 
@@ -83,15 +203,16 @@ func main() {
 
 	// Добавляю таймауты для сервера для предотвращения долгих блокировок
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.Server.Port),
+		Addr:         fmt.Sprintf(":%s", cfg.Server.Port),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
+	srv.RegisterOnShutdown(streamsCancel)
 
 	// Запуск сервера в отдельной горутине
 	go func() {
-		log.Printf("Сервис заказов слушает на порту %d", cfg.Server.Port)
+		log.Printf("Сервис заказов слушает на порту %s", cfg.Server.Port)
 		if err := srv.ListenAndServe(); err != nil {
 			log.Fatalf("HTTP server failed: %v", err)
 		}
@@ -116,7 +237,33 @@ func main() {
 		log.Printf("Не удалось корректно остановить сервер: %v", err)
 	}
 
-	// закрытие консьюмера
+	// остановка фоновых задач, relay должен закончить текущую пачку до закрытия продюсера
+	appCancel()
+	<-relayDone
+
+	// закрытие консьюмера статусов
+	if err := statusConsumer.Close(); err != nil {
+		log.Printf("Не удалось закрыть консьюмера статусов Kafka: %v", err)
+	}
+
+	// закрытие консьюмера загрузки кухни
+	if err := kitchenConsumer.Close(); err != nil {
+		log.Printf("Не удалось закрыть консьюмера загрузки кухни Kafka: %v", err)
+	}
+
+	if err := menuConsumer.Close(); err != nil {
+		log.Printf("Не удалось закрыть консьюмера доступности меню Kafka: %v", err)
+	}
+
+	if err := courierConsumer.Close(); err != nil {
+		log.Printf("Не удалось закрыть консьюмера загрузки курьеров Kafka: %v", err)
+	}
+
+	if err := compensationConsumer.Close(); err != nil {
+		log.Printf("Не удалось закрыть консьюмера компенсаций Kafka: %v", err)
+	}
+
+	// закрытие продюсера
 	if err := kWriter.Close(); err != nil {
 		log.Printf("Не удалось закрыть продюсера Kafka: %v", err)
 	}
@@ -2,11 +2,18 @@ package models
 
 import (
 	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"math"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"google.golang.org/grpc"
 
+	"github.com/sandrinasava/cafe-services/events"
 	pb "github.com/sandrinasava/go-proto-module"
 )
 
@@ -25,6 +32,183 @@ type Order struct {
 	Customer uuid.UUID `json:"customer"`
 	Items    []string  `json:"items"`
 	Status   string    `json:"status"`
+	// время, к которому нужно приготовить предзаказ. Пусто - заказ готовится сразу
+	ScheduledFor *time.Time `json:"scheduled_for,omitempty"`
+	// приоритет на кухне, назначается order-service по правилам
+	Priority int `json:"priority"`
+	// delivery (по умолчанию) или pickup - самовывоз
+	Type string `json:"type,omitempty"`
+	// адрес доставки, для самовывоза не нужен
+	Address *Address `json:"address,omitempty"`
+	// зона доставки и суммы в копейках считает order-service
+	Zone        string `json:"zone,omitempty"`
+	Subtotal    int64  `json:"subtotal"`
+	DeliveryFee int64  `json:"delivery_fee"`
+	// когда заказ приедет к клиенту, а самовывоз - когда будет готов. Пересчитывается на каждом статусе
+	ETA *time.Time `json:"eta,omitempty"`
+	// оценка для клиента: "приедет через ~12 мин"
+	ETAText string `json:"eta_text,omitempty"`
+}
+
+// ETAText возвращает оценку времени для клиента, округленную вверх до минуты
+func ETAText(orderType string, eta time.Time, now time.Time) string {
+	minutes := int(math.Ceil(eta.Sub(now).Minutes()))
+	if orderType == OrderTypePickup {
+		if minutes <= 1 {
+			return "будет готов с минуты на минуту"
+		}
+		return fmt.Sprintf("будет готов через ~%d мин", minutes)
+	}
+	if minutes <= 1 {
+		return "приедет с минуты на минуту"
+	}
+	return fmt.Sprintf("приедет через ~%d мин", minutes)
+}
+
+// Типы заказа
+const (
+	OrderTypeDelivery = events.OrderTypeDelivery
+	OrderTypePickup   = events.OrderTypePickup
+)
+
+// Address представляет адрес доставки
+// @Description Адрес доставки с координатами точки на карте
+type Address struct {
+	Text string  `json:"text"`
+	Lat  float64 `json:"lat"`
+	Lon  float64 `json:"lon"`
+	// подъезд, этаж, код домофона
+	Comment string `json:"comment,omitempty"`
+}
+
+// Value сохраняет адрес в колонку JSONB
+func (a *Address) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+	return json.Marshal(a)
+}
+
+// Scan читает адрес из колонки JSONB
+func (a *Address) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, a)
+	case string:
+		return json.Unmarshal([]byte(v), a)
+	}
+	return fmt.Errorf("неподдерживаемый тип адреса: %T", src)
+}
+
+// OrderListItem представляет заказ в списке истории заказов
+// @Description Заказ с датой создания и именем клиента (имя заполняется только в админском списке)
+type OrderListItem struct {
+	Order
+	Username  string    `json:"username,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OrderPage представляет страницу списка заказов
+// @Description Страница заказов и курсор для получения следующей страницы
+type OrderPage struct {
+	Orders     []OrderListItem `json:"orders"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// Event возвращает заказ в виде полезной нагрузки события Kafka
+func (o Order) Event() events.Order {
+	e := events.Order{
+		ID:       o.ID.String(),
+		Customer: o.Customer.String(),
+		Items:    o.Items,
+		Status:   o.Status,
+		Priority: o.Priority,
+		Type:     o.Type,
+		Zone:     o.Zone,
+	}
+	if o.Address != nil {
+		e.Address = o.Address.Text
+		e.Lat = o.Address.Lat
+		e.Lon = o.Address.Lon
+		e.AddressComment = o.Address.Comment
+	}
+	return e
+}
+
+// Статусы заказа
+const (
+	StatusScheduled  = "scheduled"
+	StatusReceived   = "received"
+	StatusCooking    = "cooking"
+	StatusReady      = "ready"
+	StatusDelivering = "delivering"
+	StatusDelivered  = "delivered"
+	StatusCancelled  = "cancelled"
+	// курьер не смог передать заказ клиенту
+	StatusFailedDelivery = "failed_delivery"
+	// недоставленный заказ вернулся в ресторан. Если доставку можно повторить, заказ снова поедет к клиенту
+	StatusReturned = "returned"
+)
+
+// statusFlow - порядок, в котором заказ проходит статусы
+var statusFlow = []string{StatusScheduled, StatusReceived, StatusCooking, StatusReady, StatusDelivering, StatusDelivered}
+
+// StatusesBefore возвращает статусы, из которых заказ может перейти в status.
+// События о статусах приходят из разных топиков, поэтому запоздавшее событие не должно откатывать заказ назад,
+// а отмененный заказ больше не меняет статус
+func StatusesBefore(status string) []string {
+	if status == StatusCancelled {
+		return statusFlow[:len(statusFlow)-1]
+	}
+	switch status {
+	// доставка может сорваться и до того, как курьер забрал заказ: у внешнего провайдера или по решению поддержки
+	case StatusFailedDelivery:
+		return []string{StatusReady, StatusDelivering}
+	case StatusReturned:
+		return []string{StatusReady, StatusDelivering, StatusFailedDelivery}
+	case StatusDelivering:
+		// повторная доставка заказа, который вернулся в ресторан
+		return append(statusFlow[:4:4], StatusFailedDelivery, StatusReturned)
+	}
+	for i, s := range statusFlow {
+		if s == status {
+			return statusFlow[:i]
+		}
+	}
+	return nil
+}
+
+// StatusEvent представляет событие о смене статуса заказа
+// @Description Новый статус заказа и время его изменения
+type StatusEvent struct {
+	OrderID uuid.UUID `json:"order_id"`
+	Status  string    `json:"status"`
+	At      time.Time `json:"at"`
+	// новая оценка времени доставки
+	ETA *time.Time `json:"eta,omitempty"`
+}
+
+// CancellableStatuses - статусы, в которых заказ еще можно отменить
+var CancellableStatuses = []string{StatusScheduled, StatusReceived}
+
+// ModifiableStatuses - статусы, в которых заказ еще можно изменить
+var ModifiableStatuses = []string{StatusScheduled, StatusReceived}
+
+// ScheduleSlot представляет слот времени выдачи предзаказов
+// @Description 15-минутный слот и число свободных мест в нем
+type ScheduleSlot struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Capacity  int       `json:"capacity"`
+	Available int       `json:"available"`
+}
+
+// OrderUpdate представляет тело запроса на изменение заказа
+// @Description Новый состав заказа
+type OrderUpdate struct {
+	Items []string `json:"items"`
 }
 
 // AuthClient представляет клиент для взаимодействия с auth-service через gRPC
@@ -47,6 +231,60 @@ func (c *AuthClient) ValidateToken(ctx context.Context, token string) (bool, err
 	return resp.Valid, nil
 }
 
+// Роли пользователей
+const (
+	RoleCustomer = "customer"
+	RoleAdmin    = "admin"
+	RoleKitchen  = "kitchen"
+)
+
+// Claims - данные пользователя из проверенного токена
+type Claims struct {
+	UserID uuid.UUID
+	Role   string
+}
+
+// Authenticate проверяет токен и возвращает данные пользователя из него
+func (c *AuthClient) Authenticate(ctx context.Context, token string) (Claims, error) {
+	token = strings.TrimPrefix(token, "Bearer ")
+	valid, err := c.ValidateToken(ctx, token)
+	if err != nil {
+		return Claims{}, err
+	}
+	if !valid {
+		return Claims{}, fmt.Errorf("недействительный токен")
+	}
+	return parseClaims(token)
+}
+
+// parseClaims достает claims "sub" и "role" из уже проверенного токена
+func parseClaims(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("неверный формат токена")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Claims{}, fmt.Errorf("неверный формат токена: %w", err)
+	}
+	var raw struct {
+		Sub  string `json:"sub"`
+		Role string `json:"role"`
+	}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return Claims{}, fmt.Errorf("неверный формат токена: %w", err)
+	}
+	userID, err := uuid.Parse(raw.Sub)
+	if err != nil {
+		return Claims{}, fmt.Errorf("неверный формат токена: %w", err)
+	}
+	// токены, выданные до появления ролей, считаются токенами клиентов
+	if raw.Role == "" {
+		raw.Role = RoleCustomer
+	}
+	return Claims{UserID: userID, Role: raw.Role}, nil
+}
+
 // Login выполняет вход пользователя и возвращает токен
 func (c *AuthClient) Login(ctx context.Context, username, password string) (string, error) {
 	resp, err := c.Client.Login(ctx, &pb.LoginRequest{Username: username, Password: password})
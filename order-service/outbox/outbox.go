@@ -0,0 +1,163 @@
+// Package outbox реализует transactional outbox: сообщения для Kafka сохраняются в бд
+// в той же транзакции, что и изменения заказа, а Relay публикует их в Kafka.
+// Так сообщение уходит в Kafka тогда и только тогда, когда транзакция зафиксирована (at-least-once)
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/sandrinasava/cafe-services/events"
+)
+
+// Enqueue кодирует событие в формате enc и сохраняет его в outbox в рамках транзакции tx
+func Enqueue(ctx context.Context, tx *sql.Tx, topic, key string, event events.Envelope, enc events.Encoding) error {
+	payload, err := event.Encode(enc)
+	if err != nil {
+		return fmt.Errorf("не удалось закодировать событие %s: %w", event.Type, err)
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO outbox (topic, message_key, payload, content_type) VALUES ($1, $2, $3, $4)",
+		topic, key, payload, string(enc))
+	if err != nil {
+		return fmt.Errorf("не удалось сохранить сообщение в outbox: %w", err)
+	}
+	return nil
+}
+
+const (
+	maxBackoff      = 30 * time.Second
+	cleanupInterval = time.Hour
+)
+
+// Relay публикует неотправленные сообщения из outbox в Kafka
+type Relay struct {
+	db           *sql.DB
+	writer       *kafka.Writer
+	pollInterval time.Duration
+	batchSize    int
+}
+
+// NewRelay создает Relay. writer не должен быть привязан к топику: топик берется из строки outbox
+func NewRelay(db *sql.DB, writer *kafka.Writer, pollInterval time.Duration, batchSize int) *Relay {
+	return &Relay{
+		db:           db,
+		writer:       writer,
+		pollInterval: pollInterval,
+		batchSize:    batchSize,
+	}
+}
+
+// Run публикует сообщения до отмены ctx. При ошибке Kafka пачка повторяется целиком
+// с экспоненциальной задержкой, чтобы не нарушить порядок сообщений одного заказа
+func (r *Relay) Run(ctx context.Context) {
+	backoff := r.pollInterval
+	lastCleanup := time.Now()
+	for {
+		sent, err := r.publishBatch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		delay := r.pollInterval
+		switch {
+		case err != nil:
+			log.Printf("Ошибка публикации сообщений из outbox: %v", err)
+			backoff = min(backoff*2, maxBackoff)
+			delay = backoff
+		case sent == r.batchSize:
+			// очередь не разобрана, следующую пачку беру сразу
+			backoff = r.pollInterval
+			delay = 0
+		default:
+			backoff = r.pollInterval
+		}
+
+		if time.Since(lastCleanup) > cleanupInterval {
+			r.cleanup(ctx)
+			lastCleanup = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// publishBatch отправляет одну пачку сообщений и возвращает число отправленных.
+// FOR UPDATE SKIP LOCKED позволяет запускать несколько экземпляров order-service
+func (r *Relay) publishBatch(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, topic, message_key, payload, content_type FROM outbox
+		WHERE sent_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`, r.batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []int64
+	var messages []kafka.Message
+	for rows.Next() {
+		var id int64
+		var m kafka.Message
+		var key, contentType string
+		if err := rows.Scan(&id, &m.Topic, &key, &m.Value, &contentType); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		m.Key = []byte(key)
+		m.Headers = []kafka.Header{{Key: events.ContentTypeHeader, Value: []byte(contentType)}}
+		ids = append(ids, id)
+		messages = append(messages, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(messages) == 0 {
+		return 0, nil
+	}
+
+	if err := r.writer.WriteMessages(ctx, messages...); err != nil {
+		// фиксирую попытку отдельно от пачки, которая остается неотправленной
+		if _, dbErr := tx.ExecContext(ctx,
+			"UPDATE outbox SET attempts = attempts + 1, last_error = $1 WHERE id = ANY($2)",
+			err.Error(), pq.Array(ids)); dbErr != nil {
+			log.Printf("Не удалось сохранить ошибку публикации в outbox: %v", dbErr)
+		} else if commitErr := tx.Commit(); commitErr != nil {
+			log.Printf("Не удалось сохранить ошибку публикации в outbox: %v", commitErr)
+		}
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE outbox SET sent_at = CURRENT_TIMESTAMP, attempts = attempts + 1, last_error = NULL WHERE id = ANY($1)",
+		pq.Array(ids)); err != nil {
+		// сообщения уже в Kafka и будут отправлены повторно: консьюмеры должны быть к этому готовы
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(messages), nil
+}
+
+// cleanup удаляет отправленные сообщения старше недели, более свежие оставлены для разбора инцидентов
+func (r *Relay) cleanup(ctx context.Context) {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM outbox WHERE sent_at < CURRENT_TIMESTAMP - INTERVAL '7 days'")
+	if err != nil {
+		log.Printf("Ошибка очистки outbox: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+// Package priority определяет приоритет заказа на кухне по правилам: еда для сотрудников,
+// VIP-клиенты и компенсация клиенту, чей недавний заказ задержался
+package priority
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/order-service/models"
+)
+
+// Rules - правила назначения приоритета
+type Rules struct {
+	// роли сотрудников, чьи заказы считаются едой для персонала
+	StaffRoles []string
+	// роли VIP-клиентов
+	VIPRoles []string
+	// заказ считается задержанным, если приготовлен позже ожидаемого времени больше чем на DelayThreshold
+	DelayThreshold time.Duration
+	// за какой период ищется задержанный заказ клиента
+	CompensationWindow time.Duration
+}
+
+// Classify возвращает приоритет нового заказа пользователя. Из нескольких подходящих правил
+// выбирается самый высокий приоритет
+func (r Rules) Classify(ctx context.Context, db *sql.DB, claims models.Claims) (int, error) {
+	if r.DelayThreshold > 0 && r.CompensationWindow > 0 {
+		delayed, err := r.hasDelayedOrder(ctx, db, claims)
+		if err != nil {
+			return events.PriorityNormal, err
+		}
+		if delayed {
+			return events.PriorityCompensation, nil
+		}
+	}
+	if slices.Contains(r.VIPRoles, claims.Role) {
+		return events.PriorityVIP, nil
+	}
+	if slices.Contains(r.StaffRoles, claims.Role) {
+		return events.PriorityStaff, nil
+	}
+	return events.PriorityNormal, nil
+}
+
+// hasDelayedOrder ищет недавний задержанный заказ клиента, за который он еще не получил компенсацию.
+// Ожидаемое время готовности - время оформления заказа или назначенное время предзаказа
+func (r Rules) hasDelayedOrder(ctx context.Context, db *sql.DB, claims models.Claims) (bool, error) {
+	var delayed bool
+	err := db.QueryRowContext(ctx,
+		`SELECT EXISTS(
+			SELECT 1 FROM orders late
+			WHERE late.user_UUID = $1
+				AND late.created_at > $2
+				AND late.ready_at > COALESCE(late.scheduled_for, late.created_at) + make_interval(secs => $3)
+				AND NOT EXISTS (
+					SELECT 1 FROM orders c
+					WHERE c.user_UUID = $1 AND c.priority = $4 AND c.created_at > late.created_at))`,
+		claims.UserID, time.Now().Add(-r.CompensationWindow), r.DelayThreshold.Seconds(), events.PriorityCompensation).Scan(&delayed)
+	return delayed, err
+}
+
+// Name возвращает название приоритета для логов
+func Name(p int) string {
+	switch p {
+	case events.PriorityStaff:
+		return "staff"
+	case events.PriorityVIP:
+		return "vip"
+	case events.PriorityCompensation:
+		return "compensation"
+	default:
+		return "normal"
+	}
+}
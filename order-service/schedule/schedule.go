@@ -0,0 +1,263 @@
+// Package schedule - предзаказы на будущее время. Заказ хранится в статусе scheduled и уходит
+// на кухню заранее, за время приготовления плюс текущее ожидание кухни. Время выдачи делится
+// на слоты по 15 минут, в каждый слот принимается ограниченное число предзаказов
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/lib/pq"
+
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/order-service/kitchen"
+	"github.com/sandrinasava/cafe-services/order-service/models"
+	"github.com/sandrinasava/cafe-services/order-service/outbox"
+	"github.com/sandrinasava/cafe-services/order-service/status"
+)
+
+// SlotDuration - длина слота времени выдачи
+const SlotDuration = 15 * time.Minute
+
+// producer - имя сервиса в конвертах событий
+const producer = "order-service"
+
+// сколько предзаказов отправляется на кухню за один проход
+const releaseBatch = 100
+
+var (
+	ErrSlotFull = errors.New("в выбранный слот больше нельзя оформить предзаказ")
+	ErrTooSoon  = errors.New("время предзаказа слишком близко, оформите обычный заказ")
+	ErrTooFar   = errors.New("предзаказ на это время пока не принимается")
+)
+
+// Policy - правила приема предзаказов
+type Policy struct {
+	// сколько в среднем готовится заказ: раньше, чем за это время, предзаказ не принимается,
+	// и примерно за это время до выдачи он уходит на кухню
+	PrepTime time.Duration
+	// насколько вперед можно оформить предзаказ
+	MaxAhead time.Duration
+	// сколько предзаказов принимается в один слот
+	SlotCapacity int
+}
+
+// Check проверяет, что на время at можно оформить предзаказ
+func (p Policy) Check(at time.Time) error {
+	now := time.Now()
+	if at.Before(now.Add(p.PrepTime)) {
+		return ErrTooSoon
+	}
+	if at.After(now.Add(p.MaxAhead)) {
+		return ErrTooFar
+	}
+	return nil
+}
+
+// SlotStart возвращает начало слота, в который попадает время t
+func SlotStart(t time.Time) time.Time {
+	return t.UTC().Truncate(SlotDuration)
+}
+
+// Book занимает место в слоте в рамках транзакции создания заказа
+func Book(ctx context.Context, tx *sql.Tx, slot time.Time, capacity int) error {
+	var booked int
+	err := tx.QueryRowContext(ctx,
+		`INSERT INTO schedule_slots (slot_start, booked) VALUES ($1, 1)
+		ON CONFLICT (slot_start) DO UPDATE SET booked = schedule_slots.booked + 1
+		WHERE schedule_slots.booked < $2
+		RETURNING booked`, slot, capacity).Scan(&booked)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && booked > capacity) {
+		return ErrSlotFull
+	}
+	return err
+}
+
+// Release освобождает место в слоте при отмене предзаказа
+func Release(ctx context.Context, tx *sql.Tx, slot time.Time) error {
+	_, err := tx.ExecContext(ctx,
+		"UPDATE schedule_slots SET booked = booked - 1 WHERE slot_start = $1 AND booked > 0", slot)
+	return err
+}
+
+// Slots возвращает слоты в интервале [from, to) с числом свободных мест
+func Slots(ctx context.Context, db *sql.DB, from, to time.Time, capacity int) ([]models.ScheduleSlot, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT slot_start, booked FROM schedule_slots WHERE slot_start >= $1 AND slot_start < $2", SlotStart(from), to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	booked := make(map[time.Time]int)
+	for rows.Next() {
+		var start time.Time
+		var n int
+		if err := rows.Scan(&start, &n); err != nil {
+			return nil, err
+		}
+		booked[start.UTC()] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// первый слот - ближайший, который начинается не раньше from
+	start := SlotStart(from)
+	if start.Before(from) {
+		start = start.Add(SlotDuration)
+	}
+	slots := []models.ScheduleSlot{}
+	for s := start; s.Before(to); s = s.Add(SlotDuration) {
+		slots = append(slots, models.ScheduleSlot{
+			Start:     s,
+			End:       s.Add(SlotDuration),
+			Capacity:  capacity,
+			Available: max(capacity-booked[s], 0),
+		})
+	}
+	return slots, nil
+}
+
+// Scheduler отправляет предзаказы на кухню, когда до времени выдачи остается время приготовления
+// плюс текущее ожидание кухни
+type Scheduler struct {
+	db       *sql.DB
+	rdb      *redis.Client
+	topic    string
+	enc      events.Encoding
+	policy   Policy
+	interval time.Duration
+}
+
+func NewScheduler(db *sql.DB, rdb *redis.Client, topic string, enc events.Encoding, policy Policy, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		db:       db,
+		rdb:      rdb,
+		topic:    topic,
+		enc:      enc,
+		policy:   policy,
+		interval: interval,
+	}
+}
+
+// LeadTime возвращает, за сколько до времени выдачи заказ должен попасть на кухню
+func (s *Scheduler) LeadTime(ctx context.Context) time.Duration {
+	lead := s.policy.PrepTime
+	load, ok, err := kitchen.Current(ctx, s.rdb)
+	if err != nil {
+		log.Printf("Ошибка чтения загрузки кухни: %v", err)
+	}
+	if ok {
+		lead += kitchen.EstimatedWait(load)
+	}
+	return lead
+}
+
+// Run отправляет предзаказы до отмены ctx
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := s.release(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Ошибка отправки предзаказов на кухню: %v", err)
+				}
+				break
+			}
+			// пачка разобрана не полностью - все готовые предзаказы отправлены
+			if n < releaseBatch {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// release переводит в received и отправляет на кухню через outbox одну пачку предзаказов.
+// FOR UPDATE SKIP LOCKED позволяет запускать планировщик в нескольких экземплярах order-service
+func (s *Scheduler) release(ctx context.Context) (int, error) {
+	deadline := time.Now().Add(s.LeadTime(ctx))
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT order_UUID, user_UUID, items, scheduled_for, priority, order_type, address,
+			COALESCE(correlation_id, '') FROM orders
+		WHERE status = $1 AND scheduled_for <= $2
+		ORDER BY scheduled_for LIMIT $3 FOR UPDATE SKIP LOCKED`,
+		models.StatusScheduled, deadline, releaseBatch)
+	if err != nil {
+		return 0, err
+	}
+	type scheduled struct {
+		order         models.Order
+		correlationID string
+	}
+	var due []scheduled
+	for rows.Next() {
+		var o scheduled
+		if err := rows.Scan(&o.order.ID, &o.order.Customer, pq.Array(&o.order.Items),
+			&o.order.ScheduledFor, &o.order.Priority, &o.order.Type, &o.order.Address, &o.correlationID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		o.order.Status = models.StatusReceived
+		due = append(due, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(due) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]string, len(due))
+	for i, o := range due {
+		ids[i] = o.order.ID.String()
+		// correlation ID запроса, которым был оформлен предзаказ, чтобы заказ прослеживался от клиента до доставки
+		event, err := events.New(events.TypeOrderCreated, producer, o.correlationID, o.order.Event())
+		if err != nil {
+			return 0, err
+		}
+		if err := outbox.Enqueue(ctx, tx, s.topic, o.order.ID.String(), event, s.enc); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE orders SET status = $1 WHERE order_UUID = ANY($2)",
+		models.StatusReceived, pq.Array(ids)); err != nil {
+		return 0, fmt.Errorf("не удалось обновить статус предзаказов: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	for _, o := range due {
+		log.Printf("Предзаказ %s на %s отправлен на кухню", o.order.ID, o.order.ScheduledFor.Format(time.RFC3339))
+		if err := s.rdb.Del(ctx, o.order.ID.String()).Err(); err != nil {
+			log.Printf("Ошибка удаления заказа из кеша: %v", err)
+		}
+		event := models.StatusEvent{OrderID: o.order.ID, Status: models.StatusReceived, At: time.Now()}
+		if err := status.Notify(ctx, s.rdb, event); err != nil {
+			log.Printf("Ошибка рассылки статуса заказа %s: %v", o.order.ID, err)
+		}
+	}
+	return len(due), nil
+}
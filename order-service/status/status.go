@@ -0,0 +1,206 @@
+// Package status отслеживает смену статусов заказов: читает события из Kafka,
+// сохраняет новый статус в бд и рассылает его подписчикам через Redis Pub/Sub
+package status
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/sandrinasava/cafe-services/events"
+	"github.com/sandrinasava/cafe-services/events/retry"
+	"github.com/sandrinasava/cafe-services/order-service/delivery"
+	"github.com/sandrinasava/cafe-services/order-service/models"
+)
+
+// Channel возвращает имя канала Redis, в который публикуются статусы заказа
+func Channel(orderID uuid.UUID) string {
+	return "order_status:" + orderID.String()
+}
+
+// Notify рассылает событие подписчикам заказа
+func Notify(ctx context.Context, rdb *redis.Client, event models.StatusEvent) error {
+	message, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return rdb.Publish(ctx, Channel(event.OrderID), message).Err()
+}
+
+// Consumer читает события о статусах заказов из топиков ready_orders и order_status
+// и оценки времени доставки из топика delivery_events. У каждого топика своя группа консьюмеров,
+// смещение фиксируется только после сохранения статуса: при ошибке событие уходит в топики повторов, затем в DLQ
+type Consumer struct {
+	rdb       *redis.Client
+	db        *sql.DB
+	estimator *delivery.Estimator
+	consumers []*retry.Consumer
+}
+
+// NewConsumer создает консьюмеров статусов. В cfg задаются брокеры и настройки повторов,
+// топики и группы консьюмеров задаются здесь
+func NewConsumer(rdb *redis.Client, db *sql.DB, estimator *delivery.Estimator, cfg retry.Config, topicReady, topicStatus, topicDelivery string) *Consumer {
+	c := &Consumer{rdb: rdb, db: db, estimator: estimator}
+	for _, t := range []struct{ topic, group string }{
+		{topicReady, "order-status-ready-group"},
+		{topicStatus, "order-status-group"},
+		{topicDelivery, "order-status-delivery-group"},
+	} {
+		topicCfg := cfg
+		topicCfg.Topic, topicCfg.GroupID = t.topic, t.group
+		c.consumers = append(c.consumers, retry.NewConsumer(topicCfg, c.handle))
+	}
+	return c
+}
+
+// Run читает топики до отмены ctx
+func (c *Consumer) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, consumer := range c.consumers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			consumer.Run(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// handle сохраняет статус или оценку времени доставки из события. Битое событие повтор не исправит,
+// оно сразу уходит в DLQ
+func (c *Consumer) handle(ctx context.Context, m kafka.Message) error {
+	env, err := events.Decode(m)
+	if err != nil {
+		return retry.Permanent(err)
+	}
+	switch env.Type {
+	case events.TypeDeliveryETA:
+		if err := c.applyETA(ctx, env); err != nil {
+			return fmt.Errorf("ошибка обновления времени доставки: %w", err)
+		}
+		return nil
+	case events.TypeCourierAssigned, events.TypeCourierLoad, events.TypeCompensationRequested:
+		return nil
+	}
+	event, err := decode(env)
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("неудачная десериализация статуса заказа: %w", err))
+	}
+	if err := c.apply(ctx, event); err != nil {
+		return fmt.Errorf("ошибка обновления статуса заказа %s: %w", event.OrderID, err)
+	}
+	return nil
+}
+
+// decode достает статус из события: готовый заказ приходит от кухни целиком, из него нужен только статус
+func decode(env events.Envelope) (models.StatusEvent, error) {
+	var (
+		orderID string
+		err     error
+	)
+	event := models.StatusEvent{At: env.OccurredAt}
+	switch env.Type {
+	case events.TypeOrderReady:
+		var order events.Order
+		if err := env.DecodePayload(&order); err != nil {
+			return models.StatusEvent{}, err
+		}
+		orderID, event.Status = order.ID, models.StatusReady
+	case events.TypeStatusChanged:
+		var changed events.StatusChanged
+		if err := env.DecodePayload(&changed); err != nil {
+			return models.StatusEvent{}, err
+		}
+		orderID, event.Status = changed.OrderID, changed.Status
+		if !changed.At.IsZero() {
+			event.At = changed.At
+		}
+	default:
+		return models.StatusEvent{}, fmt.Errorf("неожиданный тип события %s", env.Type)
+	}
+
+	if event.OrderID, err = uuid.Parse(orderID); err != nil {
+		return models.StatusEvent{}, err
+	}
+	return event, nil
+}
+
+// apply сохраняет статус в бд и, если он действительно изменился, пересчитывает время доставки
+// и рассылает статус подписчикам
+func (c *Consumer) apply(ctx context.Context, event models.StatusEvent) error {
+	// время готовности нужно, чтобы компенсировать клиенту задержанный заказ приоритетом следующего
+	order := models.Order{ID: event.OrderID, Status: event.Status}
+	err := c.db.QueryRowContext(ctx,
+		`UPDATE orders SET status = $1,
+			ready_at = CASE WHEN $1 = $4 THEN CURRENT_TIMESTAMP ELSE ready_at END
+		WHERE order_UUID = $2 AND status = ANY($3)
+		RETURNING items, order_type, address, scheduled_for`,
+		event.Status, event.OrderID, pq.Array(models.StatusesBefore(event.Status)), models.StatusReady).Scan(
+		pq.Array(&order.Items), &order.Type, &order.Address, &order.ScheduledFor)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if eta, ok := c.estimator.Estimate(ctx, order); ok {
+		event.ETA = &eta
+	}
+	if _, err := c.db.ExecContext(ctx, `UPDATE orders SET eta = $2 WHERE order_UUID = $1`, event.OrderID, event.ETA); err != nil {
+		log.Printf("Ошибка сохранения времени доставки заказа %s: %v", event.OrderID, err)
+	}
+
+	if err := c.rdb.Del(ctx, event.OrderID.String()).Err(); err != nil {
+		log.Printf("Ошибка удаления заказа из кеша: %v", err)
+	}
+	return Notify(ctx, c.rdb, event)
+}
+
+// applyETA сохраняет оценку времени доставки от службы доставки: она знает, где курьер.
+// Оценка для доставленного или отмененного заказа не сохраняется, для недоставленного - сохраняется
+// оценка повторной доставки
+func (c *Consumer) applyETA(ctx context.Context, env events.Envelope) error {
+	var payload events.DeliveryETA
+	if err := env.DecodePayload(&payload); err != nil {
+		return retry.Permanent(err)
+	}
+	event := models.StatusEvent{At: payload.At, ETA: &payload.ArriveAt}
+	var err error
+	if event.OrderID, err = uuid.Parse(payload.OrderID); err != nil {
+		return retry.Permanent(err)
+	}
+
+	err = c.db.QueryRowContext(ctx,
+		`UPDATE orders SET eta = $2 WHERE order_UUID = $1 AND status = ANY($3) RETURNING status`,
+		event.OrderID, payload.ArriveAt, pq.Array([]string{models.StatusReady, models.StatusDelivering, models.StatusFailedDelivery, models.StatusReturned})).Scan(&event.Status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := c.rdb.Del(ctx, event.OrderID.String()).Err(); err != nil {
+		log.Printf("Ошибка удаления заказа из кеша: %v", err)
+	}
+	return Notify(ctx, c.rdb, event)
+}
+
+// Close закрывает консьюмеров Kafka, накопленные смещения отправляются в брокер
+func (c *Consumer) Close() error {
+	var errs []error
+	for _, consumer := range c.consumers {
+		errs = append(errs, consumer.Close())
+	}
+	return errors.Join(errs...)
+}
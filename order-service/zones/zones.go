@@ -0,0 +1,178 @@
+// Package zones описывает зоны доставки: границы зон на карте, стоимость доставки
+// и минимальную сумму заказа в каждой зоне, а также цены и время приготовления позиций меню
+package zones
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// зоны по умолчанию, если DELIVERY_ZONES_FILE не задан
+//
+//go:embed zones.json
+var defaultZones []byte
+
+var (
+	ErrOutsideZones = errors.New("адрес вне зоны доставки")
+	ErrMinOrder     = errors.New("сумма заказа меньше минимальной для зоны доставки")
+)
+
+// Point - координаты на карте
+type Point struct {
+	Lat float64
+	Lon float64
+}
+
+// Zone - зона доставки. Суммы в копейках
+type Zone struct {
+	Name string `json:"name"`
+	// стоимость доставки
+	Fee int64 `json:"fee"`
+	// минимальная сумма позиций заказа
+	MinOrder int64 `json:"min_order"`
+	// сумма позиций, начиная с которой доставка бесплатна. 0 - доставка всегда платная
+	FreeFrom int64 `json:"free_from,omitempty"`
+	// вершины многоугольника границы зоны: [широта, долгота]
+	Polygon [][2]float64 `json:"polygon"`
+}
+
+// Contains проверяет, лежит ли точка внутри зоны (метод трассировки луча). Точка на границе зоны,
+// в том числе в вершине, считается лежащей внутри
+func (z *Zone) Contains(p Point) bool {
+	inside := false
+	for i, j := 0, len(z.Polygon)-1; i < len(z.Polygon); j, i = i, i+1 {
+		a, b := z.Polygon[i], z.Polygon[j]
+		if onSegment(p, a, b) {
+			return true
+		}
+		if (a[0] > p.Lat) != (b[0] > p.Lat) &&
+			p.Lon < (b[1]-a[1])*(p.Lat-a[0])/(b[0]-a[0])+a[1] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// onSegment проверяет, лежит ли точка на отрезке ab
+func onSegment(p Point, a, b [2]float64) bool {
+	const eps = 1e-12
+	cross := (b[0]-a[0])*(p.Lon-a[1]) - (b[1]-a[1])*(p.Lat-a[0])
+	if math.Abs(cross) > eps {
+		return false
+	}
+	return p.Lat >= math.Min(a[0], b[0])-eps && p.Lat <= math.Max(a[0], b[0])+eps &&
+		p.Lon >= math.Min(a[1], b[1])-eps && p.Lon <= math.Max(a[1], b[1])+eps
+}
+
+// FeeFor возвращает стоимость доставки заказа на сумму subtotal
+func (z *Zone) FeeFor(subtotal int64) int64 {
+	if z.FreeFrom > 0 && subtotal >= z.FreeFrom {
+		return 0
+	}
+	return z.Fee
+}
+
+// Zones - зоны доставки и позиции меню: цены, по которым считается сумма заказа,
+// и время приготовления, по которому оценивается время доставки
+type Zones struct {
+	// цены позиций в копейках
+	Prices map[string]int64 `json:"prices"`
+	// время приготовления позиций
+	PrepTimes map[string]duration `json:"prep_times"`
+	// зоны проверяются по порядку, поэтому вложенные зоны идут раньше внешних
+	Zones []Zone `json:"zones"`
+}
+
+// duration - время в формате time.ParseDuration ("5m", "1m30s")
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// Load читает зоны из файла path или встроенные зоны по умолчанию, если путь пуст
+func Load(path string) (*Zones, error) {
+	data := defaultZones
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var z Zones
+	if err := json.Unmarshal(data, &z); err != nil {
+		return nil, fmt.Errorf("неправильный формат зон доставки: %w", err)
+	}
+	for _, zone := range z.Zones {
+		if len(zone.Polygon) < 3 {
+			return nil, fmt.Errorf("у зоны доставки %s меньше трех вершин", zone.Name)
+		}
+	}
+	return &z, nil
+}
+
+// Locate возвращает зону, в которую попадает точка
+func (z *Zones) Locate(p Point) (*Zone, error) {
+	for i := range z.Zones {
+		if z.Zones[i].Contains(p) {
+			return &z.Zones[i], nil
+		}
+	}
+	return nil, ErrOutsideZones
+}
+
+// Zone возвращает зону по имени
+func (z *Zones) Zone(name string) (*Zone, bool) {
+	for i := range z.Zones {
+		if z.Zones[i].Name == name {
+			return &z.Zones[i], true
+		}
+	}
+	return nil, false
+}
+
+// Subtotal считает сумму позиций заказа. Позиции без цены считаются бесплатными
+func (z *Zones) Subtotal(items []string) int64 {
+	var total int64
+	for _, item := range items {
+		total += z.Prices[item]
+	}
+	return total
+}
+
+// Prep возвращает время приготовления позиций меню
+func (z *Zones) Prep() map[string]time.Duration {
+	prep := make(map[string]time.Duration, len(z.PrepTimes))
+	for item, d := range z.PrepTimes {
+		prep[item] = time.Duration(d)
+	}
+	return prep
+}
+
+// Quote проверяет, что заказ с позициями items можно доставить в точку p, и возвращает зону и стоимость доставки
+func (z *Zones) Quote(p Point, items []string) (*Zone, int64, error) {
+	zone, err := z.Locate(p)
+	if err != nil {
+		return nil, 0, err
+	}
+	subtotal := z.Subtotal(items)
+	if subtotal < zone.MinOrder {
+		return zone, 0, fmt.Errorf("%w: %d из %d коп.", ErrMinOrder, subtotal, zone.MinOrder)
+	}
+	return zone, zone.FeeFor(subtotal), nil
+}
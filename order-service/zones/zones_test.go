@@ -0,0 +1,38 @@
+package zones
+
+import "testing"
+
+func TestZoneContains(t *testing.T) {
+	// квадрат 0..10 по широте и долготе и невыпуклый многоугольник в форме буквы L
+	square := &Zone{Name: "square", Polygon: [][2]float64{{0, 0}, {0, 10}, {10, 10}, {10, 0}}}
+	lShape := &Zone{Name: "l", Polygon: [][2]float64{{0, 0}, {0, 10}, {4, 10}, {4, 4}, {10, 4}, {10, 0}}}
+
+	tests := []struct {
+		name string
+		zone *Zone
+		p    Point
+		want bool
+	}{
+		{name: "внутри", zone: square, p: Point{Lat: 5, Lon: 5}, want: true},
+		{name: "снаружи", zone: square, p: Point{Lat: 11, Lon: 5}, want: false},
+		{name: "на луче через вершину снаружи", zone: square, p: Point{Lat: 10, Lon: -1}, want: false},
+		{name: "на нижнем ребре", zone: square, p: Point{Lat: 0, Lon: 5}, want: true},
+		{name: "на верхнем ребре", zone: square, p: Point{Lat: 10, Lon: 5}, want: true},
+		{name: "на левом ребре", zone: square, p: Point{Lat: 5, Lon: 0}, want: true},
+		{name: "на правом ребре", zone: square, p: Point{Lat: 5, Lon: 10}, want: true},
+		{name: "в вершине", zone: square, p: Point{Lat: 0, Lon: 0}, want: true},
+		{name: "в противоположной вершине", zone: square, p: Point{Lat: 10, Lon: 10}, want: true},
+		{name: "на продолжении ребра", zone: square, p: Point{Lat: 0, Lon: 11}, want: false},
+		{name: "в вырезе невыпуклой зоны", zone: lShape, p: Point{Lat: 7, Lon: 7}, want: false},
+		{name: "в полке невыпуклой зоны", zone: lShape, p: Point{Lat: 7, Lon: 2}, want: true},
+		{name: "во внутренней вершине невыпуклой зоны", zone: lShape, p: Point{Lat: 4, Lon: 4}, want: true},
+		{name: "пустая зона", zone: &Zone{}, p: Point{Lat: 0, Lon: 0}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.zone.Contains(tt.p); got != tt.want {
+				t.Errorf("Contains(%v) = %v, want %v", tt.p, got, tt.want)
+			}
+		})
+	}
+}